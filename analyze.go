@@ -0,0 +1,143 @@
+package piper
+
+import (
+	"bufio"
+	"compress/flate"
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultAnalyzeSampling is the share of the chunks an Analyzer compresses, one of this many
+const DefaultAnalyzeSampling = 16
+
+// LinkAnalysis describes the data which passed an Analyzer, e.g. to decide where a compression
+// stage pays off
+type LinkAnalysis struct {
+	Bytes int64
+	// Records counts the complete records, AvgRecord is their average size in bytes and
+	// RecordRate the records per second between the first and the last write
+	Records    int64
+	AvgRecord  float64
+	RecordRate float64
+	// Compressibility is the size of the sampled data compressed with flate relative to its
+	// size, 0.25 means compression saves 75%. Sampled counts the bytes compressed.
+	Compressibility float64
+	Sampled         int64
+	// Entropy is the Shannon entropy of the bytes in bits per byte, 8 for random data
+	Entropy float64
+}
+
+// Analyzer is a writer analyzing the data written to it, see LinkAnalysis. It can be used
+// concurrently.
+type Analyzer struct {
+	mu     sync.Mutex
+	framer framer
+	bytes  int64
+	framed int64
+	recs   int64
+	hist   [256]int64
+	first  time.Time
+	last   time.Time
+	// chunks counts the writes, every DefaultAnalyzeSampling one is compressed into zw
+	chunks  int
+	sampled int64
+	packed  countWriter
+	zw      *flate.Writer
+}
+
+// NewAnalyzer creates an Analyzer splitting the data into records with split, e.g. ScanNUL,
+// lines if it is nil.
+func NewAnalyzer(split bufio.SplitFunc) *Analyzer {
+
+	a := &Analyzer{framer: framer{split: split}}
+	a.zw, _ = flate.NewWriter(&a.packed, flate.BestSpeed)
+	return a
+
+}
+
+// Analyze analyzes everything stage i writes to its stdout, see LinkAnalysis. Records are split
+// with the framing of the chain, see WithFraming, which must be set before. The link is relayed
+// through the parent. It must be called before Start, the analysis can be read while the chain
+// runs.
+func (c *Chain) Analyze(i int) *Analyzer {
+
+	a := NewAnalyzer(c.framing)
+	c.tapStdout(i, a)
+	return a
+
+}
+
+// Write analyzes p.
+func (a *Analyzer) Write(p []byte) (int, error) {
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.first.IsZero() {
+		a.first = now
+	}
+	a.last = now
+	a.bytes += int64(len(p))
+	for _, b := range p {
+		a.hist[b]++
+	}
+
+	end, recs := a.framer.frame(p)
+	if end > 0 {
+		a.framed = a.bytes - int64(len(p)-end)
+	}
+	a.recs += int64(recs)
+
+	if a.chunks%DefaultAnalyzeSampling == 0 {
+		a.zw.Write(p)
+		a.sampled += int64(len(p))
+	}
+	a.chunks++
+	return len(p), nil
+
+}
+
+// Report returns the analysis of the data written so far.
+func (a *Analyzer) Report() LinkAnalysis {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r := LinkAnalysis{Bytes: a.bytes, Records: a.recs, Sampled: a.sampled}
+	if a.recs > 0 {
+		r.AvgRecord = float64(a.framed) / float64(a.recs)
+		if d := a.last.Sub(a.first); d > 0 {
+			r.RecordRate = float64(a.recs) / d.Seconds()
+		}
+	}
+	if a.sampled > 0 {
+		a.zw.Flush()
+		r.Compressibility = float64(a.packed.n) / float64(a.sampled)
+	}
+	for _, n := range a.hist {
+		if n > 0 {
+			p := float64(n) / float64(a.bytes)
+			r.Entropy -= p * math.Log2(p)
+		}
+	}
+	return r
+
+}
+
+// countWriter counts the bytes written to it
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+
+	w.n += int64(len(p))
+	return len(p), nil
+
+}
@@ -0,0 +1,194 @@
+package piper
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ArchiveOptions selects the files written by Archive. Patterns use the syntax of path.Match and
+// are matched against the slash separated path relative to the root. Patterns without a slash are
+// also matched against the base name.
+type ArchiveOptions struct {
+	// Include selects the files to archive, all files are archived if it is empty
+	Include []string
+	// Exclude skips matching files and directories
+	Exclude []string
+}
+
+// Archive creates a new Chain whose first stage writes a tar stream of the files in fsys.
+// It replaces running the external tar binary at the start of a chain. Symbolic links are stored
+// as links if fsys can read them, e.g. with a ReadLink method like os.DirFS, and skipped
+// otherwise. Links pointing outside of fsys are skipped as well, they are never followed.
+func Archive(fsys fs.FS, opts ArchiveOptions) *Chain {
+
+	return Func(archive(fsys, opts))
+
+}
+
+// ArchiveDir creates a new Chain whose first stage writes a tar stream of the directory dir. The
+// files are opened within dir with os.Root, see Archive for symbolic links.
+func ArchiveDir(dir string, opts ArchiveOptions) *Chain {
+
+	return Func(func(r io.Reader, w io.Writer) error {
+
+		root, err := os.OpenRoot(dir)
+		if err != nil {
+			return errors.Wrap(err, "unable to archive files")
+		}
+		defer root.Close()
+
+		return archive(rootFS{FS: root.FS(), dir: dir}, opts)(r, w)
+
+	})
+
+}
+
+// linkFS is a file system which can read symbolic links, like fs.ReadLinkFS
+type linkFS interface {
+	ReadLink(name string) (string, error)
+}
+
+// rootFS is the file system of an os.Root reading links in dir
+type rootFS struct {
+	fs.FS
+	dir string
+}
+
+func (f rootFS) ReadLink(name string) (string, error) {
+
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.Readlink(filepath.Join(f.dir, filepath.FromSlash(name)))
+
+}
+
+func archive(fsys fs.FS, opts ArchiveOptions) StageFunc {
+
+	return func(_ io.Reader, w io.Writer) error {
+
+		tw := tar.NewWriter(w)
+		err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+
+			if err != nil {
+				return err
+			}
+			if name == "." {
+				return nil
+			}
+
+			if matchAny(opts.Exclude, name) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() && len(opts.Include) > 0 {
+				return nil
+			}
+			if !d.IsDir() && len(opts.Include) > 0 && !matchAny(opts.Include, name) {
+				return nil
+			}
+
+			return archiveEntry(tw, fsys, name, d)
+
+		})
+		if err != nil {
+			return errors.Wrap(err, "unable to archive files")
+		}
+
+		return tw.Close()
+
+	}
+
+}
+
+func archiveEntry(tw *tar.Writer, fsys fs.FS, name string, d fs.DirEntry) error {
+
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+
+	var link string
+	if info.Mode()&fs.ModeSymlink != 0 {
+		lfs, ok := fsys.(linkFS)
+		if !ok {
+			return nil
+		}
+		link, err = lfs.ReadLink(name)
+		if err != nil {
+			return err
+		}
+		link = filepath.ToSlash(link)
+		if escapes(name, link) {
+			return nil
+		}
+	} else if !info.Mode().IsRegular() && !info.IsDir() {
+		return nil
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return tw.WriteHeader(hdr)
+	}
+
+	err = tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.CopyN(tw, f, hdr.Size)
+	return err
+
+}
+
+// escapes reports whether the target of the link name points outside of the root.
+func escapes(name, target string) bool {
+
+	if path.IsAbs(target) || filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return true
+	}
+	p := path.Join(path.Dir(name), target)
+	return p == ".." || strings.HasPrefix(p, "../")
+
+}
+
+// matchAny reports whether name or, for patterns without a slash, its base name matches one of the patterns.
+func matchAny(patterns []string, name string) bool {
+
+	for _, p := range patterns {
+
+		target := name
+		if !strings.Contains(p, "/") {
+			target = path.Base(name)
+		}
+		if ok, _ := path.Match(p, target); ok {
+			return true
+		}
+
+	}
+
+	return false
+
+}
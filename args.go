@@ -0,0 +1,248 @@
+package piper
+
+import (
+	"context"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ArgList builds the arguments of a command from values which may come from user input. Every
+// value ends up as exactly one argument, there is no shell splitting them, and values which are
+// no flags can't be taken for one: paths starting with "-" are prefixed with "./" and flags and
+// option names are checked. The first invalid flag or path is kept and fails the command built
+// with CommandArgs when it starts. The zero value is an empty list.
+type ArgList struct {
+	args []string
+	err  error
+	// untrusted holds the indexes of the arguments appended by Untrusted, seps the ones of the
+	// markers appended by Separator
+	untrusted []int
+	seps      []int
+}
+
+// NewArgList returns an empty ArgList.
+func NewArgList() *ArgList {
+
+	return &ArgList{}
+
+}
+
+// Flag appends the flag name, e.g. "-v" or "--verbose". The name is meant to be a constant, it
+// must start with "-" and can't contain "=" or white space. Flags have to be appended before the
+// positional arguments of Untrusted and Separator, they are no flags after the marker "--".
+func (a *ArgList) Flag(name string) *ArgList {
+
+	if a.check(name) {
+		a.args = append(a.args, name)
+	}
+	return a
+
+}
+
+// Option appends the option key with value, "--key=value" for long options and the separate
+// arguments "-k" and "value" for short ones, so value is never parsed as a flag of its own. key
+// is checked like a Flag, value can be anything.
+func (a *ArgList) Option(key, value string) *ArgList {
+
+	if !a.check(key) {
+		return a
+	}
+	if strings.HasPrefix(key, "--") {
+		a.args = append(a.args, key+"="+value)
+	} else {
+		a.args = append(a.args, key, value)
+	}
+	return a
+
+}
+
+// Path appends the path p of a file, a path starting with "-" is prefixed with "./" so it isn't
+// taken for a flag. An empty path is invalid.
+func (a *ArgList) Path(p string) *ArgList {
+
+	switch {
+	case p == "":
+		a.fail(errors.New("piper: empty path argument"))
+		return a
+	case strings.HasPrefix(p, "-"):
+		p = "./" + p
+	}
+	a.args = append(a.args, p)
+	return a
+
+}
+
+// Untrusted appends positional arguments from an untrusted source, e.g. a search pattern entered
+// by a user. The end of options marker "--" is inserted in front of the first one when the command
+// starts, unless Separator added it before, so they are never taken for flags, see
+// StageSeparator.
+func (a *ArgList) Untrusted(arg ...string) *ArgList {
+
+	for _, v := range arg {
+		a.untrusted = append(a.untrusted, len(a.args))
+		a.args = append(a.args, v)
+	}
+	return a
+
+}
+
+// Separator appends the end of options marker "--", the arguments after it are no flags. A "--"
+// appended in another way, e.g. as the value of an Option, doesn't count as the marker for
+// Untrusted.
+func (a *ArgList) Separator() *ArgList {
+
+	a.seps = append(a.seps, len(a.args))
+	a.args = append(a.args, "--")
+	return a
+
+}
+
+// Literal appends the arguments unchanged, they must be trusted.
+func (a *ArgList) Literal(arg ...string) *ArgList {
+
+	a.args = append(a.args, arg...)
+	return a
+
+}
+
+// Strings returns a copy of the arguments.
+func (a *ArgList) Strings() []string {
+
+	return append([]string(nil), a.args...)
+
+}
+
+// Err returns the first invalid flag or path appended to the list.
+func (a *ArgList) Err() error {
+
+	return a.err
+
+}
+
+// check validates the name of a flag or option, the list fails if it is invalid or follows the
+// positional arguments.
+func (a *ArgList) check(name string) bool {
+
+	valid := len(strings.TrimLeft(name, "-")) > 0 && strings.HasPrefix(name, "-") &&
+		!strings.HasPrefix(name, "---") && !strings.ContainsAny(name, "= \t\n\v\f\r")
+	switch {
+	case !valid:
+		a.fail(errors.Errorf("piper: invalid flag %q", name))
+	case len(a.untrusted) > 0 || len(a.seps) > 0:
+		a.fail(errors.Errorf("piper: flag %q follows the positional arguments", name))
+		valid = false
+	}
+	return valid
+
+}
+
+func (a *ArgList) fail(err error) {
+
+	if a.err == nil {
+		a.err = err
+	}
+
+}
+
+// CommandArgs creates a new Chain with the command name and the arguments of args as the first
+// command, see Command and ArgList. The command is configured with opts, see Chain.Configure,
+// e.g. CommandArgs("sort", NewArgList().Flag("-r"), StageDir("/tmp")).
+func CommandArgs(name string, args *ArgList, opts ...StageOption) *Chain {
+
+	return newChain(argsStage(nil, name, args).configure(opts))
+
+}
+
+// CommandContextArgs creates a new Chain with the command name and the arguments of args as the
+// first command, see CommandContext and ArgList. The command is configured with opts.
+func CommandContextArgs(ctx context.Context, name string, args *ArgList, opts ...StageOption) *Chain {
+
+	return newChain(argsStage(ctx, name, args).configure(opts)).withContextProfile(ctx)
+
+}
+
+// CommandArgs adds the command name with the arguments of args to the back of the command chain.
+// The command is configured with opts.
+func (c *Chain) CommandArgs(name string, args *ArgList, opts ...StageOption) *Chain {
+
+	c.stages = append(c.stages, argsStage(nil, name, args).configure(opts))
+	return c
+
+}
+
+// CommandContextArgs adds the command name with the arguments of args to the back of the
+// command chain, see CommandContext. The command is configured with opts.
+func (c *Chain) CommandContextArgs(ctx context.Context, name string, args *ArgList, opts ...StageOption) *Chain {
+
+	c.stages = append(c.stages, argsStage(ctx, name, args).configure(opts))
+	return c
+
+}
+
+// argsStage creates the stage of a command with an ArgList, an invalid list fails its start.
+func argsStage(ctx context.Context, name string, args *ArgList) *stage {
+
+	if err := args.Err(); err != nil {
+		return &stage{cmd: &exec.Cmd{Path: name, Args: append([]string{name}, args.args...), Err: err}}
+	}
+
+	s := commandStage(ctx, name, args.Strings())
+	if s.cmd != nil && len(args.untrusted) > 0 {
+		// an alias may have added arguments in front of the list
+		off := len(s.cmd.Args) - len(args.args)
+		for _, i := range args.untrusted {
+			s.untrusted = append(s.untrusted, off+i)
+		}
+		if len(args.seps) > 0 {
+			s.sep = off + args.seps[0]
+		}
+	}
+	return s
+
+}
+
+// separated reports whether the end of options marker added by piper precedes argument i of the
+// command. A "--" passed in otherwise, e.g. as the value of an option, doesn't count.
+func (s *stage) separated(i int) bool {
+
+	return s.sep > 0 && s.sep < i
+
+}
+
+// guardArgs inserts the end of options marker in front of the first untrusted argument, see
+// ArgList.Untrusted. Without the marker, see StageSeparator, the start of the command fails if an
+// untrusted argument may be taken for a flag.
+func (s *stage) guardArgs() {
+
+	if len(s.untrusted) == 0 || s.separated(s.untrusted[0]) {
+		return
+	}
+	if !s.noSep {
+		s.separate()
+		return
+	}
+	for _, i := range s.untrusted {
+		if arg := s.cmd.Args[i]; strings.HasPrefix(arg, "-") && !s.separated(i) && s.cmd.Err == nil {
+			s.cmd.Err = errors.Errorf("piper: untrusted argument %q looks like a flag, see StageSeparator", arg)
+		}
+	}
+
+}
+
+// separate inserts the end of options marker in front of the first untrusted argument. The
+// arguments may be shared with clones, so they are copied.
+func (s *stage) separate() {
+
+	at := s.untrusted[0]
+	s.cmd.Args = slices.Insert(slices.Clip(s.cmd.Args), at, "--")
+	s.sep = at
+	untrusted := make([]int, len(s.untrusted))
+	for i, u := range s.untrusted {
+		untrusted[i] = u + 1
+	}
+	s.untrusted = untrusted
+
+}
@@ -0,0 +1,73 @@
+package piper_test
+
+import (
+	"os/exec"
+	"slices"
+	"testing"
+
+	"github.com/noxer/piper"
+)
+
+func TestUntrustedArgs(t *testing.T) {
+
+	if _, err := exec.LookPath("true"); err != nil {
+		t.Skip("needs true")
+	}
+
+	tests := []struct {
+		name string
+		args *piper.ArgList
+		sep  bool
+		want []string // nil if the start fails
+	}{
+		{"separated", piper.NewArgList().Flag("-i").Untrusted("x", "-n"), true, []string{"-i", "--", "x", "-n"}},
+		{"option value", piper.NewArgList().Option("-e", "--").Untrusted("-n", "x"), true, []string{"-e", "--", "--", "-n", "x"}},
+		{"literal", piper.NewArgList().Literal("--").Untrusted("-n"), true, []string{"--", "--", "-n"}},
+		{"separator", piper.NewArgList().Separator().Untrusted("-n"), true, []string{"--", "-n"}},
+		{"without marker", piper.NewArgList().Untrusted("x"), false, []string{"x"}},
+		{"flag without marker", piper.NewArgList().Untrusted("-n"), false, nil},
+		{"option value without marker", piper.NewArgList().Option("-e", "--").Untrusted("-n", "x"), false, nil},
+		{"separator without marker", piper.NewArgList().Separator().Untrusted("-n"), false, []string{"--", "-n"}},
+		{"flag after untrusted", piper.NewArgList().Untrusted("x").Flag("-n"), true, nil},
+		{"option after separator", piper.NewArgList().Separator().Option("-e", "x"), true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := piper.CommandArgs("true", tt.args).Configure(piper.StageSeparator(tt.sep))
+			err := c.Run()
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("started with %q", c.Result().Stages[0].Args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.Result().Stages[0].Args[1:]; !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+}
+
+func TestUntrustedArgsClone(t *testing.T) {
+
+	if _, err := exec.LookPath("true"); err != nil {
+		t.Skip("needs true")
+	}
+
+	c := piper.CommandArgs("true", piper.NewArgList().Untrusted("-n"))
+	n := c.Clone()
+	for _, c := range []*piper.Chain{c, n, n.Clone()} {
+		if err := c.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if got := c.Result().Stages[0].Args; !slices.Equal(got, []string{"true", "--", "-n"}) {
+			t.Errorf("got %q", got)
+		}
+	}
+
+}
@@ -0,0 +1,55 @@
+package piper
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// errNotStarted is read from the Stdin held back by the start barrier if a stage failed to start
+var errNotStarted = errors.New("piper: chain failed to start, stdin was not passed on")
+
+// WithStartBarrier holds back the Stdin of the chain until every stage started. If a stage fails
+// to start, the first stage sees the end of its input without a single byte of Stdin being
+// consumed, so the input can be retried. Data written to StdinPipe is not held back.
+func WithStartBarrier() Option {
+
+	return func(c *Chain) { c.barrier = true }
+
+}
+
+// gate blocks reads from r until it is released
+type gate struct {
+	r    io.Reader
+	open chan struct{}
+	err  error
+}
+
+func newGate(r io.Reader) *gate {
+
+	return &gate{r: r, open: make(chan struct{})}
+
+}
+
+func (g *gate) Read(p []byte) (int, error) {
+
+	<-g.open
+	if g.err != nil {
+		return 0, g.err
+	}
+	return g.r.Read(p)
+
+}
+
+// release lets the reads pass, or fail if the chain failed to start. A nil gate is ignored.
+func (g *gate) release(startErr error) {
+
+	if g == nil {
+		return
+	}
+	if startErr != nil {
+		g.err = errNotStarted
+	}
+	close(g.open)
+
+}
@@ -0,0 +1,225 @@
+package piper
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Batch runs many inputs through a single long-lived clone of a template instead of starting the
+// commands for every input. This works for commands processing their input as a stream, e.g.
+// "grep --line-buffered": every input is followed by a sentinel line, which the commands must pass
+// through unchanged, and the output up to the sentinel is the output of the input. If the clone
+// fails, the input is run by a fresh clone of the template like Output does and a new clone
+// is started for the next input. A new clone is probed with the sentinel alone, if it doesn't
+// come back in time without other output, e.g. because grep filters it out, every input is run
+// by a clone of its own.
+type Batch struct {
+	template *Chain
+	sentinel []byte
+
+	// Timeout limits the time to wait for the output of an input, the clone is killed and the
+	// input run by itself once it is exceeded. No limit if zero, the probe of a new clone waits
+	// DefaultBatchProbe then.
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	worker   *batchWorker
+	disabled bool
+}
+
+// DefaultBatchProbe is the time a new clone of a Batch without a Timeout has to pass the sentinel on
+const DefaultBatchProbe = time.Second
+
+// NewBatch creates a batch running the inputs through clones of template, separated by the line
+// sentinel. The Stdin and Stdout of template are replaced, Stderr and Allerr are shared.
+func NewBatch(template *Chain, sentinel string) *Batch {
+
+	return &Batch{template: template, sentinel: []byte(sentinel + "\n")}
+
+}
+
+// Run returns the output for input. The output of an input without a final newline is the
+// output of the input with a newline appended.
+func (b *Batch) Run(input []byte) ([]byte, error) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.disabled {
+		out, err := b.batched(input)
+		if err == nil {
+			return out, nil
+		}
+	}
+
+	c := b.template.Clone()
+	c.Stdin = bytes.NewReader(input)
+	c.Stdout = nil
+	return c.Output()
+
+}
+
+// Batched reports whether the inputs are run through a long-lived clone.
+func (b *Batch) Batched() bool {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !b.disabled
+
+}
+
+// Close stops the long-lived clone.
+func (b *Batch) Close() error {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.worker == nil {
+		return nil
+	}
+	err := b.worker.close()
+	b.worker = nil
+	return err
+
+}
+
+// batched runs input through the worker, starting one if necessary. b.mu must be held.
+func (b *Batch) batched(input []byte) ([]byte, error) {
+
+	if b.worker == nil {
+		probe := b.Timeout
+		if probe <= 0 {
+			probe = DefaultBatchProbe
+		}
+		w, err := startBatchWorker(b.template, b.sentinel, probe)
+		if err != nil {
+			b.disabled = true
+			return nil, err
+		}
+		b.worker = w
+	}
+
+	out, err := b.worker.run(input, b.sentinel, b.Timeout)
+	if err != nil {
+		if b.worker.runs == 0 {
+			// the commands don't pass the sentinel on
+			b.disabled = true
+		}
+		b.worker.kill()
+		b.worker = nil
+		return nil, err
+	}
+	return out, nil
+
+}
+
+// batchWorker is a long-lived clone of the template of a Batch
+type batchWorker struct {
+	c    *Chain
+	in   io.WriteCloser
+	out  *bufio.Reader
+	runs int
+}
+
+// startBatchWorker starts a clone of template and probes whether it passes the sentinel on
+// within probe.
+func startBatchWorker(template *Chain, sentinel []byte, probe time.Duration) (*batchWorker, error) {
+
+	c := template.Clone()
+	c.Stdin = nil
+	c.Stdout = nil
+
+	in, err := c.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := c.StdoutPipe()
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	if err := c.Start(); err != nil {
+		in.Close()
+		out.Close()
+		return nil, err
+	}
+
+	w := &batchWorker{c: c, in: in, out: bufio.NewReader(out)}
+	probed, err := w.run(nil, sentinel, probe)
+	if err == nil && len(probed) > 0 {
+		err = errors.New("piper: the batch clone wrote output for no input")
+	}
+	if err != nil {
+		w.kill()
+		return nil, errors.Wrap(err, "the batch clone doesn't pass the sentinel on")
+	}
+	w.runs = 0
+	return w, nil
+
+}
+
+func (w *batchWorker) run(input, sentinel []byte, timeout time.Duration) ([]byte, error) {
+
+	if timeout > 0 {
+		t := time.AfterFunc(timeout, w.c.kill)
+		defer t.Stop()
+	}
+
+	// the input is written while the output is read, the commands may block on a full pipe
+	written := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 0, len(input)+1+len(sentinel))
+		buf = append(buf, input...)
+		if len(input) > 0 && input[len(input)-1] != '\n' {
+			buf = append(buf, '\n')
+		}
+		_, err := w.in.Write(append(buf, sentinel...))
+		written <- err
+	}()
+
+	var out []byte
+	for start := true; ; {
+		line, err := w.out.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			out = append(out, line...)
+			start = false
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "batch clone stopped")
+		}
+		if start && bytes.Equal(line, sentinel) {
+			break
+		}
+		out = append(out, line...)
+		start = true
+	}
+
+	if err := <-written; err != nil {
+		return nil, errors.Wrap(err, "unable to write to the batch clone")
+	}
+	w.runs++
+	return out, nil
+
+}
+
+func (w *batchWorker) kill() {
+
+	w.c.kill()
+	w.in.Close()
+	w.c.Wait()
+
+}
+
+func (w *batchWorker) close() error {
+
+	w.in.Close()
+	return w.c.Wait()
+
+}
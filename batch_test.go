@@ -0,0 +1,83 @@
+package piper_test
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/noxer/piper"
+)
+
+func TestBatch(t *testing.T) {
+
+	if _, err := exec.LookPath("grep"); err != nil {
+		t.Skip("needs grep")
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		batched bool
+	}{
+		{"passing the sentinel", []string{"--line-buffered", "-e", "foo", "-e", "__END__"}, true},
+		{"filtering the sentinel", []string{"--line-buffered", "foo"}, false},
+		{"buffering", []string{"-e", "foo", "-e", "__END__"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := piper.NewBatch(piper.Command("grep", tt.args...), "__END__")
+			b.Timeout = 500 * time.Millisecond
+			defer b.Close()
+
+			for in, want := range map[string]string{"foo\nbar\n": "foo\n", "bar\nfoo bar": "foo bar\n", "foofoo\n": "foofoo\n"} {
+				done := make(chan struct{})
+				var out []byte
+				var err error
+				go func() {
+					defer close(done)
+					out, err = b.Run([]byte(in))
+				}()
+				select {
+				case <-done:
+				case <-time.After(10 * time.Second):
+					t.Fatalf("%q: Run hangs", in)
+				}
+
+				if err != nil || string(out) != want {
+					t.Errorf("%q: got %q, %v, want %q", in, out, err, want)
+				}
+			}
+			if b.Batched() != tt.batched {
+				t.Errorf("batched %v, want %v", b.Batched(), tt.batched)
+			}
+		})
+	}
+
+}
+
+func TestBatchDefaultProbe(t *testing.T) {
+
+	if _, err := exec.LookPath("grep"); err != nil {
+		t.Skip("needs grep")
+	}
+
+	// the example of the request, without a Timeout
+	b := piper.NewBatch(piper.Command("grep", "--line-buffered", "foo"), "__END__")
+	defer b.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		out, err := b.Run([]byte("foo\nbar\n"))
+		if err != nil || string(out) != "foo\n" {
+			t.Errorf("got %q, %v", out, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(piper.DefaultBatchProbe + 10*time.Second):
+		t.Fatal("Run hangs")
+	}
+
+}
@@ -0,0 +1,35 @@
+package piper_test
+
+import (
+	"testing"
+
+	"github.com/noxer/piper"
+)
+
+func BenchmarkNew(b *testing.B) {
+
+	b.ReportAllocs()
+	for b.Loop() {
+		piper.New()
+	}
+
+}
+
+func BenchmarkCommand(b *testing.B) {
+
+	b.ReportAllocs()
+	for b.Loop() {
+		piper.Command("gzip", "-c").Command("base64").Command("wc", "-c")
+	}
+
+}
+
+func BenchmarkClone(b *testing.B) {
+
+	c := piper.Command("gzip", "-c").Command("base64").Command("wc", "-c")
+	b.ReportAllocs()
+	for b.Loop() {
+		c.Clone()
+	}
+
+}
@@ -0,0 +1,120 @@
+package piper
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// BlobSink stores a stream of data under a key, e.g. in an object store
+type BlobSink interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+}
+
+// StagingBlobSink is a BlobSink which can store a blob without publishing it, so Upload can keep
+// the output of a failed chain from showing up under the key
+type StagingBlobSink interface {
+	BlobSink
+	// Stage stores everything read from r, the blob isn't visible under key before it is committed
+	Stage(ctx context.Context, key string, r io.Reader) (StagedBlob, error)
+}
+
+// StagedBlob is a blob stored by a StagingBlobSink which isn't published yet
+type StagedBlob interface {
+	// Commit publishes the blob under its key
+	Commit(ctx context.Context) error
+	// Abort discards the blob
+	Abort(ctx context.Context) error
+}
+
+// BlobSource provides the data stored under a key
+type BlobSource interface {
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// Upload adds a stage to the back of the chain which stores everything it reads in sink under key.
+// A stage only sees the end of its input if the stage before it failed, so with a
+// StagingBlobSink the blob is committed once the whole chain succeeded, like WithOutputFile
+// publishes its file, and aborted otherwise. Other sinks store the blob as soon as the input ended.
+func (c *Chain) Upload(ctx context.Context, sink BlobSink, key string) *Chain {
+
+	staging, ok := sink.(StagingBlobSink)
+	if !ok {
+		return c.Func(func(r io.Reader, _ io.Writer) error {
+			return errors.Wrapf(sink.Put(ctx, key, r), "unable to upload %s", key)
+		})
+	}
+
+	return c.FuncContext(ctx, func(sctx context.Context, r io.Reader, _ io.Writer) error {
+		blob, err := staging.Stage(sctx, key, r)
+		if err != nil {
+			return errors.Wrapf(err, "unable to upload %s", key)
+		}
+		info, _ := StageFromContext(sctx)
+		info.chain.onPublish(func(ok bool) error {
+			if !ok {
+				return errors.Wrapf(blob.Abort(context.WithoutCancel(ctx)), "unable to abort the upload of %s", key)
+			}
+			return errors.Wrapf(blob.Commit(ctx), "unable to upload %s", key)
+		})
+		return nil
+	})
+
+}
+
+// onPublish adds fn to the functions called once the chain ended, ok is set if it succeeded.
+func (c *Chain) onPublish(fn func(ok bool) error) {
+
+	c.pubMu.Lock()
+	c.publishers = append(c.publishers, fn)
+	c.pubMu.Unlock()
+
+}
+
+// publishBlobs commits the blobs staged by Upload stages if ok, otherwise it aborts them. The
+// first error is returned.
+func (c *Chain) publishBlobs(ok bool) error {
+
+	c.pubMu.Lock()
+	publishers := c.publishers
+	c.publishers = nil
+	c.pubMu.Unlock()
+
+	var first error
+	for _, fn := range publishers {
+		if err := fn(ok); err != nil && first == nil {
+			first = err
+		}
+		if first != nil {
+			// a failed commit fails the chain, the blobs after it are discarded
+			ok = false
+		}
+	}
+	return first
+
+}
+
+// Download creates a new Chain whose first stage writes the blob stored under key in src.
+func Download(ctx context.Context, src BlobSource, key string) *Chain {
+
+	return Func(download(ctx, src, key))
+
+}
+
+func download(ctx context.Context, src BlobSource, key string) StageFunc {
+
+	return func(_ io.Reader, w io.Writer) error {
+
+		rc, err := src.Get(ctx, key)
+		if err != nil {
+			return errors.Wrapf(err, "unable to download %s", key)
+		}
+		defer rc.Close()
+
+		_, err = io.Copy(w, rc)
+		return errors.Wrapf(err, "unable to download %s", key)
+
+	}
+
+}
@@ -0,0 +1,160 @@
+package piper
+
+import (
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrLagged is returned to readers of a Broadcast which fell too far behind and were dropped
+var ErrLagged = errors.New("piper: broadcast reader fell behind")
+
+// Broadcast is an io.Writer whose data can be followed by any number of readers attaching at any
+// time, each receiving the data written after it attached. Writes never block, a reader with more
+// than the limit of unread data is dropped with ErrLagged.
+type Broadcast struct {
+	mu     sync.Mutex
+	limit  int
+	subs   map[*subscriber]struct{}
+	closed bool
+}
+
+// NewBroadcast creates a broadcast buffering up to limit bytes for every reader.
+func NewBroadcast(limit int) *Broadcast {
+
+	return &Broadcast{limit: limit, subs: map[*subscriber]struct{}{}}
+
+}
+
+// Broadcast passes the output of the last stage to a new Broadcast which is closed once the chain
+// finished. It must be called after all stages were added and before the chain is started.
+func (c *Chain) Broadcast(limit int) *Broadcast {
+
+	b := NewBroadcast(limit)
+	c.tapStdout(len(c.stages)-1, b)
+	c.closeAfterWait = append(c.closeAfterWait, b)
+	return b
+
+}
+
+// Attach returns a reader receiving all data written from now on. It returns io.EOF once the
+// broadcast was closed and all data was read.
+func (b *Broadcast) Attach() io.ReadCloser {
+
+	s := &subscriber{b: b}
+	s.cond = sync.NewCond(&s.mu)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		s.err = io.EOF
+		return s
+	}
+	b.subs[s] = struct{}{}
+	return s
+
+}
+
+// Write hands p to all attached readers.
+func (b *Broadcast) Write(p []byte) (int, error) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subs {
+		if !s.push(p, b.limit) {
+			delete(b.subs, s)
+		}
+	}
+	return len(p), nil
+
+}
+
+// Close ends the stream, readers receive io.EOF after the remaining data.
+func (b *Broadcast) Close() error {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for s := range b.subs {
+		s.end(io.EOF)
+	}
+	b.subs = map[*subscriber]struct{}{}
+	return nil
+
+}
+
+type subscriber struct {
+	b    *Broadcast
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  []byte
+	err  error
+}
+
+// push appends p to the buffer, it reports false if the subscriber is gone or lagged behind.
+func (s *subscriber) push(p []byte, limit int) bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return false
+	}
+	if len(s.buf)+len(p) > limit {
+		s.buf = nil
+		s.err = ErrLagged
+		s.cond.Broadcast()
+		return false
+	}
+
+	s.buf = append(s.buf, p...)
+	s.cond.Broadcast()
+	return true
+
+}
+
+func (s *subscriber) end(err error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err == nil {
+		s.err = err
+	}
+	s.cond.Broadcast()
+
+}
+
+func (s *subscriber) Read(p []byte) (int, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.buf) == 0 && s.err == nil {
+		s.cond.Wait()
+	}
+	if len(s.buf) == 0 {
+		return 0, s.err
+	}
+
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+
+}
+
+// Close detaches the reader from the broadcast.
+func (s *subscriber) Close() error {
+
+	s.b.mu.Lock()
+	delete(s.b.subs, s)
+	s.b.mu.Unlock()
+
+	s.end(io.ErrClosedPipe)
+	return nil
+
+}
@@ -0,0 +1,75 @@
+package piper
+
+import (
+	"os"
+	"os/exec"
+	"time"
+)
+
+// CancelFunc stops command #i of a canceled chain, e.g. by sending it SIGTERM, see WithCancel
+type CancelFunc func(i int, cmd *exec.Cmd) error
+
+// WithCancel sets how the commands are stopped once the context of the chain is done or its
+// timeout expired, see WithContext and WithTimeout. The default kills them. It also replaces the
+// Cancel of commands created with CommandContext, like exec.Cmd.Cancel does for a single command.
+// If fn fails, other than with os.ErrProcessDone, the command is killed. Combine it with
+// WithWaitDelay so commands ignoring fn are killed eventually.
+func WithCancel(fn CancelFunc) Option {
+
+	return func(c *Chain) { c.cancel = fn }
+
+}
+
+// WithWaitDelay kills the commands still running d after they were canceled with the CancelFunc
+// of WithCancel. It is set as the WaitDelay of every command as well, so Wait doesn't hang on
+// pipes held open by processes the commands left behind, see exec.Cmd.WaitDelay.
+func WithWaitDelay(d time.Duration) Option {
+
+	return func(c *Chain) { c.waitDelay = d }
+
+}
+
+// prepareCancel hands the cancel behavior of the chain to the command of stage i.
+func (c *Chain) prepareCancel(i int, s *stage) {
+
+	if c.waitDelay > 0 {
+		s.cmd.WaitDelay = c.waitDelay
+	}
+	if c.cancel != nil && s.ctx != nil {
+		// the command is recreated by a retry of its start, see spawn
+		s.cancel = func() error { return c.cancel(i, s.cmd) }
+		s.cmd.Cancel = s.cancel
+	}
+
+}
+
+// cancelStages stops the running commands with the CancelFunc of the chain and kills the ones
+// still running after the wait delay. It returns early once waited is closed by Wait.
+func (c *Chain) cancelStages(waited chan struct{}) {
+
+	if c.cancel == nil {
+		c.kill()
+		return
+	}
+
+	for i, s := range c.stages {
+		if s.cmd == nil || s.cmd.Process == nil {
+			continue
+		}
+		if err := c.cancel(i, s.cmd); err != nil && err != os.ErrProcessDone {
+			s.kill()
+		}
+	}
+
+	if c.waitDelay <= 0 {
+		return
+	}
+	t := time.NewTimer(c.waitDelay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		c.kill()
+	case <-waited:
+	}
+
+}
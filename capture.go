@@ -0,0 +1,271 @@
+package piper
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Limits bounds the amount of output kept by a LimitedBuffer
+type Limits struct {
+	// Bytes is the maximum number of bytes kept, it must be positive
+	Bytes int
+	// Lines is the maximum number of lines kept, zero doesn't limit the lines
+	Lines int
+	// KeepTail splits the limits between the start and the end of the output,
+	// otherwise only the start is kept
+	KeepTail bool
+}
+
+// LimitedBuffer is an io.Writer keeping a bounded part of the data written to it. Everything
+// beyond the limits is counted and dropped, so a chatty command can't exhaust memory.
+// It is safe for concurrent use.
+type LimitedBuffer struct {
+	mu     sync.Mutex
+	limits Limits
+
+	head      []byte
+	headLines int
+	headFull  bool
+	tail      []byte
+	total     int64
+}
+
+// NewLimitedBuffer creates a buffer holding the first limit bytes.
+func NewLimitedBuffer(limit int) *LimitedBuffer {
+
+	return &LimitedBuffer{limits: Limits{Bytes: limit}}
+
+}
+
+// NewHeadTailBuffer creates a buffer bounded by l.
+func NewHeadTailBuffer(l Limits) *LimitedBuffer {
+
+	return &LimitedBuffer{limits: l}
+
+}
+
+// split returns the byte and line limits of the head and the tail.
+func (l Limits) split() (headBytes, headLines, tailBytes, tailLines int) {
+
+	if !l.KeepTail {
+		return l.Bytes, l.Lines, 0, 0
+	}
+	return l.Bytes / 2, l.Lines / 2, l.Bytes - l.Bytes/2, l.Lines - l.Lines/2
+
+}
+
+// Write stores as much of p as fits and never fails.
+func (b *LimitedBuffer) Write(p []byte) (int, error) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.total += int64(len(p))
+	headBytes, headLines, tailBytes, _ := b.limits.split()
+
+	rest := p
+	if !b.headFull {
+
+		n := len(rest)
+		if free := headBytes - len(b.head); n > free {
+			n = free
+		}
+		if headLines > 0 {
+			for i := 0; i < n; i++ {
+				if rest[i] != '\n' {
+					continue
+				}
+				b.headLines++
+				if b.headLines == headLines {
+					n = i + 1
+					break
+				}
+			}
+		}
+
+		b.head = append(b.head, rest[:n]...)
+		rest = rest[n:]
+		b.headFull = len(rest) > 0 || len(b.head) == headBytes || (headLines > 0 && b.headLines == headLines)
+
+	}
+
+	if len(rest) > 0 && tailBytes > 0 {
+		b.tail = append(b.tail, rest...)
+		if len(b.tail) > 2*tailBytes {
+			b.tail = append([]byte(nil), b.tail[len(b.tail)-tailBytes:]...)
+		}
+	}
+
+	return len(p), nil
+
+}
+
+// kept returns the part of the tail which is within the limits.
+func (b *LimitedBuffer) kept() []byte {
+
+	_, _, tailBytes, tailLines := b.limits.split()
+	t := b.tail
+	if len(t) > tailBytes {
+		t = t[len(t)-tailBytes:]
+	}
+
+	if tailLines > 0 {
+		n := 0
+		for i := len(t) - 2; i >= 0; i-- {
+			if t[i] != '\n' {
+				continue
+			}
+			n++
+			if n == tailLines {
+				t = t[i+1:]
+				break
+			}
+		}
+	}
+
+	return t
+
+}
+
+func (b *LimitedBuffer) dropped() int64 {
+
+	return b.total - int64(len(b.head)) - int64(len(b.kept()))
+
+}
+
+// Bytes returns the stored data. If data was dropped a marker line stating the amount separates the
+// start and the end of the output.
+func (b *LimitedBuffer) Bytes() []byte {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	out := append([]byte(nil), b.head...)
+	if dropped := b.dropped(); dropped > 0 {
+		if len(out) > 0 && out[len(out)-1] != '\n' {
+			out = append(out, '\n')
+		}
+		out = append(out, fmt.Sprintf("[... %d bytes truncated]\n", dropped)...)
+	}
+	return append(out, b.kept()...)
+
+}
+
+// Truncated returns the number of bytes dropped.
+func (b *LimitedBuffer) Truncated() int64 {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.dropped()
+
+}
+
+// Capture holds the stdout and stderr of every stage of a chain, see Chain.Capture
+type Capture struct {
+	stdout []*LimitedBuffer
+	stderr []*LimitedBuffer
+}
+
+// Capture records up to limit bytes of the stdout and stderr of every stage while the chain runs.
+// The output of intermediate stages is still passed on to the next stage.
+// It must be called before the chain is started, the capture is complete once Wait returned.
+func (c *Chain) Capture(limit int) *Capture {
+
+	return c.CaptureLimits(Limits{Bytes: limit}, Limits{Bytes: limit})
+
+}
+
+// CaptureLimits works like Capture with separate limits for stdout and stderr.
+// The number of dropped bytes is recorded in the StageResult of every stage.
+func (c *Chain) CaptureLimits(stdout, stderr Limits) *Capture {
+
+	cp := &Capture{
+		stdout: make([]*LimitedBuffer, len(c.stages)),
+		stderr: make([]*LimitedBuffer, len(c.stages)),
+	}
+
+	for i, s := range c.stages {
+
+		cp.stdout[i] = NewHeadTailBuffer(stdout)
+		c.tapStdout(i, cp.stdout[i])
+
+		cp.stderr[i] = NewHeadTailBuffer(stderr)
+		if s.cmd != nil {
+			c.tapStderr(i, cp.stderr[i])
+		}
+
+	}
+
+	c.captures = append(c.captures, cp)
+	return cp
+
+}
+
+// Stdout returns the captured stdout of stage i.
+func (cp *Capture) Stdout(i int) []byte {
+
+	return cp.stdout[i].Bytes()
+
+}
+
+// Stderr returns the captured stderr of stage i, it is always empty for in-process stages.
+func (cp *Capture) Stderr(i int) []byte {
+
+	return cp.stderr[i].Bytes()
+
+}
+
+// Truncated reports whether output of stage i was dropped because it exceeded the limit.
+func (cp *Capture) Truncated(i int) bool {
+
+	return cp.stdout[i].Truncated() > 0 || cp.stderr[i].Truncated() > 0
+
+}
+
+// record stores the amount of dropped output in the results of the stages.
+func (cp *Capture) record(r *Result) {
+
+	for i := range r.Stages {
+		r.Stages[i].StdoutDropped += cp.stdout[i].Truncated()
+		r.Stages[i].StderrDropped += cp.stderr[i].Truncated()
+	}
+
+}
+
+// WithStderrBuffers keeps the stderr of every command in a buffer of its own, see Chain.StderrOf.
+// The start and the end of at most limit bytes are kept per command. The stderr is still written
+// to Stderr, Allerr and StderrFor.
+func WithStderrBuffers(limit int) Option {
+
+	return func(c *Chain) { c.errLimit = limit }
+
+}
+
+// StderrOf returns the stderr of stage i kept by WithStderrBuffers, without them the end of it
+// kept for its StageError, see StderrExcerpt. It is complete once Wait returned, function stages
+// have no stderr.
+func (c *Chain) StderrOf(i int) []byte {
+
+	if i < 0 || i >= len(c.stages) {
+		return nil
+	}
+	if i < len(c.errBufs) && c.errBufs[i] != nil {
+		return c.errBufs[i].Bytes()
+	}
+	return c.excerpt(i)
+
+}
+
+// bufferStderr taps the stderr of every command into its own buffer, see WithStderrBuffers.
+func (c *Chain) bufferStderr() {
+
+	c.errBufs = make([]*LimitedBuffer, len(c.stages))
+	for i, s := range c.stages {
+		if s.cmd != nil {
+			c.errBufs[i] = NewHeadTailBuffer(Limits{Bytes: c.errLimit, KeepTail: true})
+			c.tapStderr(i, c.errBufs[i])
+		}
+	}
+
+}
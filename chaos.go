@@ -0,0 +1,130 @@
+package piper
+
+import (
+	"io"
+	"math/rand"
+	"time"
+)
+
+// Chaos configures the faults injected by WithChaos. Every decision is drawn from random sources
+// seeded with Seed, so a seed injects the same faults in the same order again. Only the timing of
+// the processes themselves stays nondeterministic.
+type Chaos struct {
+	// Seed seeds the random decisions, if it is zero the seed of the chain is used, see WithSeed
+	Seed int64
+	// DelayRate is the share of the chunks passing a link which are delayed by up to Delay
+	DelayRate float64
+	Delay     time.Duration
+	// ShortReadRate is the share of the reads from a link which return at most as many bytes as a
+	// random share of the buffer, like a slow writer would cause
+	ShortReadRate float64
+	// KillRate is the probability to kill one of the commands, picked at random, at a random time
+	// within KillWithin after the start. Functions can't be killed.
+	KillRate   float64
+	KillWithin time.Duration
+}
+
+// WithChaos injects faults into the chain for resilience testing, see Chaos. Like Instrument it
+// routes every pipe link through the parent process, where the data is delayed and split up.
+func WithChaos(ch Chaos) Option {
+
+	return func(c *Chain) { c.chaos = &ch }
+
+}
+
+// reader wraps the source of the relay of link i, every link has its own random source.
+func (ch *Chaos) reader(seed int64, i int, r io.Reader) io.Reader {
+
+	if ch.DelayRate <= 0 && ch.ShortReadRate <= 0 {
+		return r
+	}
+	return &chaosReader{ch: ch, r: r, rnd: rand.New(rand.NewSource(seed + int64(i) + 1))}
+
+}
+
+// WithSeed sets the seed of the random decisions of the chain, e.g. the faults of WithChaos, so a
+// run can be reproduced. Without one a random seed is picked for every run, it is reported by
+// Result.Seed.
+func WithSeed(seed int64) Option {
+
+	return func(c *Chain) {
+		c.seed = seed
+		c.seeded = true
+	}
+
+}
+
+// pickSeed decides the seed of the run, see WithSeed.
+func (c *Chain) pickSeed() {
+
+	switch {
+	case c.chaos != nil && c.chaos.Seed != 0:
+		c.runSeed = c.chaos.Seed
+	case c.seeded:
+		c.runSeed = c.seed
+	default:
+		c.runSeed = time.Now().UnixNano()
+	}
+
+}
+
+// startChaos arms the kill of a random command, it is disarmed by stopChaos.
+func (c *Chain) startChaos() {
+
+	ch := c.chaos
+	if ch == nil || ch.KillRate <= 0 {
+		return
+	}
+
+	rnd := rand.New(rand.NewSource(c.runSeed))
+	if rnd.Float64() >= ch.KillRate {
+		return
+	}
+	var cmds []*stage
+	for _, s := range c.stages {
+		if s.cmd != nil {
+			cmds = append(cmds, s)
+		}
+	}
+	if len(cmds) == 0 {
+		return
+	}
+
+	s := cmds[rnd.Intn(len(cmds))]
+	var d time.Duration
+	if ch.KillWithin > 0 {
+		d = time.Duration(rnd.Int63n(int64(ch.KillWithin)))
+	}
+	c.chaosKill = time.AfterFunc(d, func() { s.kill() })
+
+}
+
+// stopChaos disarms the kill armed by startChaos.
+func (c *Chain) stopChaos() {
+
+	if c.chaosKill != nil {
+		c.chaosKill.Stop()
+	}
+
+}
+
+// chaosReader delays and shortens the reads from a link
+type chaosReader struct {
+	ch  *Chaos
+	r   io.Reader
+	rnd *rand.Rand
+}
+
+func (cr *chaosReader) Read(p []byte) (int, error) {
+
+	if len(p) > 1 && cr.rnd.Float64() < cr.ch.ShortReadRate {
+		p = p[:1+cr.rnd.Intn(len(p)-1)]
+	}
+
+	n, err := cr.r.Read(p)
+	if n > 0 && cr.ch.Delay > 0 && cr.rnd.Float64() < cr.ch.DelayRate {
+		time.Sleep(time.Duration(cr.rnd.Int63n(int64(cr.ch.Delay))))
+	}
+	return n, err
+
+}
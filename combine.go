@@ -0,0 +1,160 @@
+package piper
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+)
+
+// LineMux writes the complete lines of several writers to one underlying writer, so lines of
+// concurrent stages or chains sharing a log never interleave. Every stream needs its own writer,
+// e.g. from StderrFor:
+//
+//	mux := piper.NewLineMux(logFile)
+//	c.StderrFor = func(i int, path string) io.Writer { return mux.Writer("") }
+//
+// It is safe for concurrent use.
+type LineMux struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewLineMux creates a mux writing to w.
+func NewLineMux(w io.Writer) *LineMux {
+
+	return &LineMux{w: w}
+
+}
+
+// Writer returns a writer passing every line to the mux, prefixed with prefix. Incomplete lines
+// are buffered until they are completed or the writer is closed.
+func (m *LineMux) Writer(prefix string) io.WriteCloser {
+
+	return m.prefixed(prefix)
+
+}
+
+func (m *LineMux) prefixed(prefix string) *prefixWriter {
+
+	return &prefixWriter{mux: m, prefix: []byte(prefix)}
+
+}
+
+// prefixWriter collects the lines of a single stream for a LineMux
+type prefixWriter struct {
+	mu     sync.Mutex
+	mux    *LineMux
+	prefix []byte
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		err := w.line(w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+		if err != nil {
+			return len(p), err
+		}
+
+	}
+
+}
+
+// Close writes a trailing incomplete line.
+func (w *prefixWriter) Close() error {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.line(append(w.buf, '\n'))
+	w.buf = nil
+	return err
+
+}
+
+func (w *prefixWriter) line(l []byte) error {
+
+	w.mux.mu.Lock()
+	defer w.mux.mu.Unlock()
+
+	_, err := w.mux.w.Write(append(w.prefix[:len(w.prefix):len(w.prefix)], l...))
+	return err
+
+}
+
+// CombineOrder decides the order of the lines of the stages in the combined output, see WithCombineOrder
+type CombineOrder int
+
+// Orders of the combined output
+const (
+	// ArrivalOrder writes the lines in the order the stages wrote them
+	ArrivalOrder CombineOrder = iota
+	// StageOrder groups the lines by stage and stream, the stdout of stage #0 first, then its
+	// stderr, then the stdout of stage #1 and so on. The output doesn't depend on the scheduling
+	// of the stages, so it can be compared across runs.
+	StageOrder
+)
+
+// WithCombineOrder sets the order of the lines returned by CombinedOutput with CombineAll,
+// ArrivalOrder by default.
+func WithCombineOrder(o CombineOrder) Option {
+
+	return func(c *Chain) { c.combine = o }
+
+}
+
+// combineAll taps the stdout and stderr of every stage into b, prefixing every line with its
+// origin. With StageOrder the streams are buffered separately and flush appends them to b once
+// the chain was waited for.
+func (c *Chain) combineAll(b *bytes.Buffer) (flush func()) {
+
+	var streams []*bytes.Buffer
+	mux := NewLineMux(b)
+	prefixed := func(prefix string) *prefixWriter {
+		if c.combine != StageOrder {
+			return mux.prefixed(prefix)
+		}
+		buf := &bytes.Buffer{}
+		streams = append(streams, buf)
+		return NewLineMux(buf).prefixed(prefix)
+	}
+
+	for i, s := range c.stages {
+
+		// the link of a stage piping its stderr carries it as well, see PipeStreams
+		name := filepath.Base(s.name())
+		stream := map[Streams]string{StdoutStream: "out", StderrStream: "err", BothStreams: "out+err"}[s.streams]
+		out := prefixed(fmt.Sprintf("[#%d %s:%s] ", i, name, stream))
+		c.tapStdout(i, out)
+		c.closeAfterWait = append(c.closeAfterWait, out)
+
+		if s.cmd != nil && s.streams == StdoutStream {
+			errw := prefixed(fmt.Sprintf("[#%d %s:err] ", i, name))
+			c.tapStderr(i, errw)
+			c.closeAfterWait = append(c.closeAfterWait, errw)
+		}
+
+	}
+
+	return func() {
+		for _, buf := range streams {
+			b.Write(buf.Bytes())
+		}
+	}
+
+}
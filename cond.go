@@ -0,0 +1,148 @@
+package piper
+
+import (
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// errConditional is returned by the pipe methods of chains using And or Or
+var errConditional = errors.New("piper: pipes are not supported by chains using And or Or")
+
+// errNoStage fails chains configuring the last added stage without one, e.g. right after And
+var errNoStage = errors.New("piper: there is no stage to configure, e.g. right after And or Or")
+
+// condition joins a pipeline of a conditional chain to the one before it
+type condition int
+
+// Conditions of the pipelines
+const (
+	condNone condition = iota
+	condAnd
+	condOr
+)
+
+// part is a pipeline of a conditional chain which runs before the stages of the chain itself
+type part struct {
+	cond   condition
+	stages []*stage
+}
+
+// And ends the current pipeline, the stages added from now on only run if it succeeded, like
+// "a && b" in a shell. The pipelines run one after the other, all of them write to the Stdout,
+// Stderr and Allerr of the chain and read from the same Stdin. Configuration by stage index, like
+// TapStdin or Capture, applies to the stages added after the last And or Or, and so do the
+// output options like WithOutputFile. WithTimeout limits every pipeline on its own.
+func (c *Chain) And() *Chain {
+
+	return c.join(condAnd)
+
+}
+
+// Or ends the current pipeline, the stages added from now on only run if it failed, like
+// "a || b" in a shell, see And. Conditions are evaluated from left to right, so in
+// "a && b || c" c runs if a or b failed.
+func (c *Chain) Or() *Chain {
+
+	return c.join(condOr)
+
+}
+
+func (c *Chain) join(cond condition) *Chain {
+
+	c.parts = append(c.parts, part{cond: c.cond, stages: c.stages})
+	c.cond = cond
+	c.stages = nil
+	return c
+
+}
+
+// startParts starts the first pipeline of a conditional chain.
+func (c *Chain) startParts() error {
+
+	p := c.partChain(0)
+	if err := p.Start(); err != nil {
+		return err
+	}
+	c.running = p
+	return nil
+
+}
+
+// waitParts waits for the running pipeline of a conditional chain and runs the following ones
+// whose condition holds. The results of all pipelines which ran are merged.
+func (c *Chain) waitParts() error {
+
+	var prior Result
+	collect := func(r *Result) {
+		if r != nil {
+			prior.Stages = append(prior.Stages, r.Stages...)
+			prior.Links = append(prior.Links, r.Links...)
+		}
+	}
+
+	err := c.running.Wait()
+	collect(c.running.Result())
+	c.running = nil
+
+	for i := 1; i <= len(c.parts); i++ {
+
+		cond := c.cond
+		if i < len(c.parts) {
+			cond = c.parts[i].cond
+		}
+		if (cond == condAnd) != (err == nil) {
+			continue
+		}
+
+		if i < len(c.parts) {
+			p := c.partChain(i)
+			err = p.Run()
+			collect(p.Result())
+			continue
+		}
+
+		// the stages of the chain itself
+		err = c.startPipeline()
+		if err == nil {
+			err = c.Wait()
+		}
+		if c.result != nil {
+			c.result.Stages = append(prior.Stages, c.result.Stages...)
+			c.result.Links = append(prior.Links, c.result.Links...)
+			return err
+		}
+		break
+
+	}
+
+	c.result = &prior
+	return err
+
+}
+
+// partChain returns the chain running the pipeline i of a conditional chain.
+func (c *Chain) partChain(i int) *Chain {
+
+	p := c.derive()
+	// the output file and the output kept for Output belong to the whole chain
+	p.outFile, p.keepOutput = nil, 0
+	p.stages = c.parts[i].stages
+	return p
+
+}
+
+// cloneParts copies the pipelines of a conditional chain to n.
+func (c *Chain) cloneParts(n *Chain) {
+
+	n.cond = c.cond
+	for _, pt := range c.parts {
+		stages := make([]*stage, len(pt.stages))
+		for i, s := range pt.stages {
+			stages[i] = &stage{}
+			s.clone(stages[i], &exec.Cmd{})
+		}
+		n.parts = append(n.parts, part{cond: pt.cond, stages: stages})
+	}
+
+}
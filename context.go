@@ -0,0 +1,52 @@
+package piper
+
+import (
+	"context"
+	"log"
+)
+
+// StageInfo describes the stage a function created by FuncContext runs as
+type StageInfo struct {
+	// Chain is the Name of the chain
+	Chain string
+	// Index is the position of the stage in the chain
+	Index int
+	// Logger is the Logger of the chain, it may be nil
+	Logger *log.Logger
+
+	// chain runs the stage, e.g. to publish staged blobs once it ended, see Upload
+	chain *Chain
+}
+
+type stageInfoKey struct{}
+
+// StageFromContext returns the StageInfo carried by the context of a function stage.
+func StageFromContext(ctx context.Context) (StageInfo, bool) {
+
+	info, ok := ctx.Value(stageInfoKey{}).(StageInfo)
+	return info, ok
+
+}
+
+// stageContext returns the context passed to the function of stage i. It is canceled with the
+// context of the chain as well, see WithContext.
+func (c *Chain) stageContext(i int, s *stage) context.Context {
+
+	ctx := s.ctx
+	switch {
+	case ctx == nil && c.ctx != nil:
+		ctx = c.ctx
+	case ctx == nil:
+		ctx = context.Background()
+	case c.ctx != nil && c.ctx != ctx:
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		stop := context.AfterFunc(c.ctx, func() { cancel(context.Cause(c.ctx)) })
+		c.ctxStops = append(c.ctxStops, func() {
+			stop()
+			cancel(nil)
+		})
+	}
+	return context.WithValue(ctx, stageInfoKey{}, StageInfo{Chain: c.Name, Index: i, Logger: c.Logger, chain: c})
+
+}
@@ -0,0 +1,79 @@
+package piper
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// WithCoreDumps collects the core dumps of commands which crashed into dir, the path is recorded
+// in StageResult.Core. The soft core size limit of the process is raised to the hard limit when
+// the chain starts, which affects every command started from then on. The core is looked up
+// according to the core_pattern of the kernel, cores handed to a program like systemd-coredump
+// can't be collected. It is only supported on Linux.
+func WithCoreDumps(dir string) Option {
+
+	return func(c *Chain) { c.coreDir = dir }
+
+}
+
+// collectCores moves the core dumps of the crashed commands into the core directory of the chain.
+func (c *Chain) collectCores(r *Result) {
+
+	for i, s := range c.stages {
+
+		st := r.Stages[i]
+		if st.Exit == nil || !st.Exit.CoreDumped {
+			continue
+		}
+
+		src, err := findCore(s.cmd, st.Exit)
+		if err != nil {
+			r.Stages[i].CoreErr = err
+			continue
+		}
+		dst := filepath.Join(c.coreDir, fmt.Sprintf("core.%d.%s.%d", i, filepath.Base(s.cmd.Path), s.cmd.ProcessState.Pid()))
+		if err := moveFile(src, dst); err != nil {
+			r.Stages[i].CoreErr = errors.Wrapf(err, "unable to collect the core dump %s", src)
+			continue
+		}
+		r.Stages[i].Core = dst
+
+	}
+
+}
+
+// moveFile moves src to dst, copying it if they are on different file systems.
+func moveFile(src, dst string) error {
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if os.Rename(src, dst) == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+
+}
@@ -0,0 +1,103 @@
+package piper
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// enableCores raises the soft core size limit to the hard limit, it is inherited by the commands.
+func enableCores() error {
+
+	var l syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_CORE, &l); err != nil {
+		return err
+	}
+	if l.Cur == l.Max {
+		return nil
+	}
+	l.Cur = l.Max
+	return syscall.Setrlimit(syscall.RLIMIT_CORE, &l)
+
+}
+
+// findCore returns the path of the core dumped by cmd according to /proc/sys/kernel/core_pattern.
+func findCore(cmd *exec.Cmd, st *ExitStatus) (string, error) {
+
+	b, err := os.ReadFile("/proc/sys/kernel/core_pattern")
+	if err != nil {
+		return "", errors.Wrap(err, "unable to read the core pattern")
+	}
+	pattern := strings.TrimSpace(string(b))
+	if strings.HasPrefix(pattern, "|") {
+		return "", errors.Errorf("piper: core dumps are passed to %s", strings.Fields(pattern[1:])[0])
+	}
+
+	pid := strconv.Itoa(cmd.ProcessState.Pid())
+	// the kernel truncates the command name to 15 bytes
+	comm := filepath.Base(cmd.Path)
+	if len(comm) > 15 {
+		comm = comm[:15]
+	}
+
+	var glob strings.Builder
+	hasPid := false
+	for i := 0; i < len(pattern); i++ {
+
+		if pattern[i] != '%' || i == len(pattern)-1 {
+			glob.WriteByte(pattern[i])
+			continue
+		}
+		i++
+		switch pattern[i] {
+		case '%':
+			glob.WriteByte('%')
+		case 'p', 'P', 'i', 'I':
+			hasPid = true
+			glob.WriteString(pid)
+		case 'e':
+			glob.WriteString(comm)
+		case 'E':
+			glob.WriteString(strings.ReplaceAll(cmd.Path, "/", "!"))
+		case 's':
+			glob.WriteString(strconv.Itoa(int(st.Signal)))
+		case 'u':
+			glob.WriteString(strconv.Itoa(os.Getuid()))
+		case 'g':
+			glob.WriteString(strconv.Itoa(os.Getgid()))
+		default:
+			// the time, the hostname and the rest are matched with a wildcard
+			glob.WriteByte('*')
+		}
+
+	}
+
+	if !hasPid {
+		if b, err := os.ReadFile("/proc/sys/kernel/core_uses_pid"); err == nil && strings.TrimSpace(string(b)) == "1" {
+			glob.WriteString("." + pid)
+		}
+	}
+
+	path := glob.String()
+	if !filepath.IsAbs(path) {
+		dir := cmd.Dir
+		if dir == "" {
+			if dir, err = os.Getwd(); err != nil {
+				return "", err
+			}
+		}
+		path = filepath.Join(dir, path)
+	}
+
+	m, err := filepath.Glob(path)
+	if err != nil || len(m) == 0 {
+		return "", errors.Errorf("piper: no core dump matching %s", path)
+	}
+	return m[0], nil
+
+}
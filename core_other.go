@@ -0,0 +1,23 @@
+//go:build !linux
+
+package piper
+
+import (
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// enableCores is only supported on Linux.
+func enableCores() error {
+
+	return nil
+
+}
+
+// findCore is only supported on Linux.
+func findCore(cmd *exec.Cmd, st *ExitStatus) (string, error) {
+
+	return "", errors.New("piper: core dumps can only be collected on Linux")
+
+}
@@ -0,0 +1,138 @@
+package piper
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrDeadlock is the cause of the error returned by Wait when the deadlock guard killed the chain
+var ErrDeadlock = errors.New("piper: deadlock detected")
+
+// errReadAfterWait explains the "file already closed" error of reading a pipe after Wait
+var errReadAfterWait = errors.New("piper: read from pipe after Wait, read the pipe to EOF before calling Wait")
+
+// watchedPipe tracks the progress on a pipe handed out by StdinPipe, StdoutPipe or StderrPipe
+type watchedPipe struct {
+	chain  *Chain
+	method string
+	r      io.ReadCloser
+	w      io.WriteCloser
+	n      int64
+	done   int32
+}
+
+func (c *Chain) watch(method string, r io.ReadCloser, w io.WriteCloser) *watchedPipe {
+
+	p := &watchedPipe{chain: c, method: method, r: r, w: w}
+	c.watched = append(c.watched, p)
+	return p
+
+}
+
+func (p *watchedPipe) Read(b []byte) (int, error) {
+
+	n, err := p.r.Read(b)
+	atomic.AddInt64(&p.n, int64(n))
+	if err == io.EOF {
+		atomic.StoreInt32(&p.done, 1)
+	}
+	if pe, ok := err.(*os.PathError); ok && pe.Err == os.ErrClosed && atomic.LoadInt32(&p.chain.waiting) == 1 {
+		err = errReadAfterWait
+	}
+	return n, err
+
+}
+
+func (p *watchedPipe) Write(b []byte) (int, error) {
+
+	n, err := p.w.Write(b)
+	atomic.AddInt64(&p.n, int64(n))
+	if taps := p.chain.inTaps; n > 0 && len(taps) > 0 {
+		tee(nil, taps).Write(b[:n])
+	}
+	return n, err
+
+}
+
+func (p *watchedPipe) Close() error {
+
+	atomic.StoreInt32(&p.done, 1)
+	if p.r != nil {
+		return p.r.Close()
+	}
+	return p.w.Close()
+
+}
+
+// stalled reports whether the pipe blocks the chain. It returns the explanation and the progress
+// counter which has to stay unchanged for the pipe to be considered stuck.
+func (p *watchedPipe) stalled() (string, int64, bool) {
+
+	n := atomic.LoadInt64(&p.n)
+	if atomic.LoadInt32(&p.done) == 1 {
+		return "", n, false
+	}
+	if p.w != nil {
+		return "Wait blocks because StdinPipe was not closed, the first command is waiting for more input", n, true
+	}
+	return "Wait blocks because " + p.method + " has unread output, read it to EOF before calling Wait", n, true
+
+}
+
+// guard starts the deadlock watchdog for Wait. When the watched pipes make no progress for
+// DeadlockTimeout while Wait is blocked, all commands are killed. The returned function stops
+// the watchdog and returns the deadlock error if it fired.
+func (c *Chain) guard() func() error {
+
+	if c.DeadlockTimeout <= 0 || len(c.watched) == 0 {
+		return func() error { return nil }
+	}
+
+	done := make(chan struct{})
+	result := make(chan error, 1)
+	c.labeled(-1, "deadlock-guard", func() {
+		go func() { result <- c.watchdog(done) }()
+	})
+
+	return func() error {
+		close(done)
+		return <-result
+	}
+
+}
+
+// watchdog kills the chain and returns the reason once a watched pipe stalled.
+func (c *Chain) watchdog(done chan struct{}) error {
+
+	last := make([]int64, len(c.watched))
+	ticker := time.NewTicker(c.DeadlockTimeout)
+	defer ticker.Stop()
+
+	first := true
+	for {
+
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+		}
+
+		for i, p := range c.watched {
+
+			why, n, stalled := p.stalled()
+			if stalled && !first && n == last[i] {
+				c.kill()
+				return errors.Wrap(ErrDeadlock, why)
+			}
+			last[i] = n
+
+		}
+		first = false
+
+	}
+
+}
@@ -0,0 +1,88 @@
+package piper
+
+import (
+	"sync/atomic"
+)
+
+// DebugInfo is a snapshot of the state of a running chain, see Chain.Debug
+type DebugInfo struct {
+	Stages []StageState
+	Links  []LinkState
+}
+
+// StageState describes a single stage of a running chain
+type StageState struct {
+	Index int
+	Path  string
+	PID   int
+
+	Running bool
+	Exited  bool
+	// ExitCode is valid once the stage exited, functions which failed report 1
+	ExitCode int
+
+	// The following fields are filled from /proc on Linux and empty elsewhere.
+
+	// State is the process state as reported by the kernel, e.g. "S (sleeping)"
+	State string
+	// Wchan is the kernel function the process is sleeping in
+	Wchan string
+	// Fds maps the standard file descriptors 0, 1 and 2 to their targets, e.g. "pipe:[1234]"
+	Fds map[int]string
+	// Blocked is a guess what the process is waiting for, e.g. "reading from a pipe"
+	Blocked string
+	// ReadBytes and WrittenBytes are the I/O counters of the process
+	ReadBytes    int64
+	WrittenBytes int64
+}
+
+// LinkState describes the link between stage From and stage From+1
+type LinkState struct {
+	From int
+	// Bytes is the number of bytes passed on so far or -1 if the link isn't routed through the
+	// parent process, see Chain.Instrument
+	Bytes int64
+}
+
+// Debug returns the state of every stage and link of the chain. It is safe to call while the chain
+// is running and is meant to diagnose hung pipelines.
+func (c *Chain) Debug() *DebugInfo {
+
+	d := &DebugInfo{
+		Stages: make([]StageState, len(c.stages)),
+		Links:  make([]LinkState, len(c.stages)-1),
+	}
+
+	for i, s := range c.stages {
+
+		st := StageState{
+			Index: i,
+			Path:  s.name(),
+			PID:   int(atomic.LoadInt32(&s.pid)),
+		}
+
+		switch atomic.LoadInt32(&s.status) {
+		case stageRunning:
+			st.Running = true
+		case stageExited:
+			st.Exited = true
+			st.ExitCode = int(atomic.LoadInt32(&s.code))
+		}
+		if st.Running && st.PID != 0 {
+			probe(&st)
+		}
+
+		d.Stages[i] = st
+
+	}
+
+	for i := range d.Links {
+		d.Links[i] = LinkState{From: i, Bytes: -1}
+	}
+	for _, rl := range c.relays {
+		d.Links[rl.from].Bytes = rl.bytes()
+	}
+
+	return d
+
+}
@@ -0,0 +1,78 @@
+package piper
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// probe fills the OS specific fields of st from /proc.
+func probe(st *StageState) {
+
+	dir := "/proc/" + strconv.Itoa(st.PID) + "/"
+
+	if f, err := os.Open(dir + "status"); err == nil {
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			if v := strings.TrimPrefix(sc.Text(), "State:"); v != sc.Text() {
+				st.State = strings.TrimSpace(v)
+			}
+		}
+		f.Close()
+	}
+
+	if b, err := os.ReadFile(dir + "wchan"); err == nil {
+		st.Wchan = strings.TrimSpace(string(b))
+	}
+
+	if f, err := os.Open(dir + "io"); err == nil {
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			k, v, _ := strings.Cut(sc.Text(), ": ")
+			n, _ := strconv.ParseInt(v, 10, 64)
+			switch k {
+			case "rchar":
+				st.ReadBytes = n
+			case "wchar":
+				st.WrittenBytes = n
+			}
+		}
+		f.Close()
+	}
+
+	st.Fds = map[int]string{}
+	for fd := 0; fd <= 2; fd++ {
+		if t, err := os.Readlink(dir + "fd/" + strconv.Itoa(fd)); err == nil {
+			st.Fds[fd] = t
+		}
+	}
+
+	st.Blocked = blockedOn(st)
+
+}
+
+// blockedOn guesses what a process is waiting for from its wait channel.
+func blockedOn(st *StageState) string {
+
+	switch {
+	case strings.HasPrefix(st.State, "Z"):
+		return "exited, waiting to be reaped"
+	case strings.HasPrefix(st.State, "R"):
+		return ""
+	case strings.Contains(st.Wchan, "pipe_read"):
+		return "reading from a pipe (" + st.Fds[0] + ")"
+	case strings.Contains(st.Wchan, "pipe_write"):
+		return "writing to a pipe (" + st.Fds[1] + ")"
+	case strings.Contains(st.Wchan, "pipe_wait"):
+		return "reading from or writing to a pipe"
+	case strings.Contains(st.Wchan, "wait"):
+		return "waiting for a child process"
+	case strings.Contains(st.Wchan, "poll"), strings.Contains(st.Wchan, "select"):
+		return "polling file descriptors"
+	case strings.Contains(st.Wchan, "sleep"):
+		return "sleeping"
+	}
+	return ""
+
+}
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package piper
+
+// probe is only supported on Linux.
+func probe(st *StageState) {}
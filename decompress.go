@@ -0,0 +1,53 @@
+package piper
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+)
+
+// DecompressTools lists the commands used by AutoDecompress for formats without a decoder in the
+// standard library. Entries for gzip and bzip2 replace the built-in decoders.
+var DecompressTools = map[ContentType][]string{
+	TypeXz:   {"xz", "-dc"},
+	TypeZstd: {"zstd", "-dc"},
+}
+
+// AutoDecompress adds a stage to the back of the chain which detects the compression of its input
+// from the magic bytes and decompresses it, uncompressed data is passed on unchanged.
+// gzip and bzip2 are decoded in-process, other formats use the commands in DecompressTools.
+func (c *Chain) AutoDecompress() *Chain {
+
+	routes := map[ContentType]*Chain{
+		TypeGzip:  Func(gunzip),
+		TypeBzip2: Func(bunzip2),
+	}
+	for typ, tool := range DecompressTools {
+		if len(tool) > 0 {
+			routes[typ] = Command(tool[0], tool[1:]...)
+		}
+	}
+
+	return c.Route(routes, nil)
+
+}
+
+func gunzip(r io.Reader, w io.Writer) error {
+
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	_, err = io.Copy(w, zr)
+	return err
+
+}
+
+func bunzip2(r io.Reader, w io.Writer) error {
+
+	_, err := io.Copy(w, bzip2.NewReader(r))
+	return err
+
+}
@@ -0,0 +1,225 @@
+package piper
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// DefaultExcerpt is the amount of captured stderr attached to a StageError if Chain.StderrExcerpt is zero
+const DefaultExcerpt = 4 << 10
+
+// StageError is the error of a failed stage, it is recorded in the StageResult. If the stderr of
+// the stage was kept, see Chain.StderrExcerpt, WithStderrBuffers and Chain.Capture, its end is
+// attached to the error.
+type StageError struct {
+	// Index is the position of the stage in the chain
+	Index int
+	// Path is the path of the command, the name of a virtual command or "func"
+	Path string
+	// Args are the arguments of the command including its name, nil for functions
+	Args []string
+	// ExitCode is the exit code of the command, -1 for functions and commands which didn't exit
+	ExitCode int
+	// Err is the error of the stage, e.g. an *exec.ExitError
+	Err error
+
+	stderr []byte
+}
+
+// PipelineError is the error returned by Wait, Run and Output if stages failed. It embeds the
+// error of the stage which failed the chain and aggregates the errors of all failed stages like
+// errors.Join, errors.Is and errors.As search all of them, the one which failed the chain first.
+type PipelineError struct {
+	*StageError
+	// Failed holds the errors of all failed stages in order, including the ignored ones
+	Failed []*StageError
+}
+
+// Cause returns the error of the stage which failed the chain, see errors.Cause.
+func (e *PipelineError) Cause() error {
+
+	return e.StageError
+
+}
+
+// Unwrap returns the error of the stage which failed the chain followed by the errors of the
+// other failed stages.
+func (e *PipelineError) Unwrap() []error {
+
+	errs := []error{e.StageError}
+	for _, se := range e.Failed {
+		if se != e.StageError {
+			errs = append(errs, se)
+		}
+	}
+	return errs
+
+}
+
+// Error describes the failure of the stage which failed the chain, the other failed stages
+// follow on their own lines like with errors.Join.
+func (e *PipelineError) Error() string {
+
+	msg := e.StageError.Error()
+	for _, se := range e.Failed {
+		if se != e.StageError {
+			msg += "\n" + se.Error()
+		}
+	}
+	return msg
+
+}
+
+// Error describes the failure, followed by the last line of stderr if it was kept.
+func (e *StageError) Error() string {
+
+	msg := fmt.Sprintf("unable to wait for process #%d (%s): %v", e.Index, e.Path, e.Err)
+	if line := lastLine(e.stderr); len(line) > 0 {
+		msg += fmt.Sprintf(" (stderr: %s)", line)
+	}
+	return msg
+
+}
+
+// Stderr returns the end of the stderr of the stage, it is nil if stderr wasn't kept.
+func (e *StageError) Stderr() []byte {
+
+	return e.stderr
+
+}
+
+// Cause returns the error of the stage, see errors.Cause.
+func (e *StageError) Cause() error {
+
+	return e.Err
+
+}
+
+// Unwrap returns the error of the stage.
+func (e *StageError) Unwrap() error {
+
+	return e.Err
+
+}
+
+// StartError is the error returned by Start if a stage couldn't be started. The stages started
+// before it were killed and reaped and the pipes of the others closed, nothing of the chain is
+// left running.
+type StartError struct {
+	// Index is the position of the stage in the chain
+	Index int
+	// Path is the path of the command, the name of a virtual command or "func"
+	Path string
+	// Args are the arguments of the command including its name, nil for functions
+	Args []string
+	// Err is the error of the start, e.g. an *exec.Error
+	Err error
+}
+
+// Error describes the failure.
+func (e *StartError) Error() string {
+
+	return fmt.Sprintf("unable to start command #%d (%s): %v", e.Index, e.Path, e.Err)
+
+}
+
+// Cause returns the error of the start, see errors.Cause.
+func (e *StartError) Cause() error {
+
+	return e.Err
+
+}
+
+// Unwrap returns the error of the start.
+func (e *StartError) Unwrap() error {
+
+	return e.Err
+
+}
+
+// startError creates the StartError of stage i.
+func (c *Chain) startError(i int, err error) *StartError {
+
+	s := c.stages[i]
+	se := &StartError{Index: i, Path: s.name(), Err: err}
+	if s.cmd != nil {
+		se.Args = s.cmd.Args
+	}
+	return se
+
+}
+
+// lastLine returns the last non-empty line of b.
+func lastLine(b []byte) []byte {
+
+	b = bytes.TrimRight(b, "\r\n")
+	if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+		b = b[i+1:]
+	}
+	return bytes.TrimSpace(b)
+
+}
+
+// excerpt returns the end of the kept stderr of stage i.
+func (c *Chain) excerpt(i int) []byte {
+
+	n := c.StderrExcerpt
+	if n <= 0 {
+		n = DefaultExcerpt
+	}
+
+	if i < len(c.excerpts) && c.excerpts[i] != nil {
+		return c.excerpts[i].bytes()
+	}
+	bufs := make([]*LimitedBuffer, 0, len(c.captures)+1)
+	if i < len(c.errBufs) && c.errBufs[i] != nil {
+		bufs = append(bufs, c.errBufs[i])
+	}
+	for _, cp := range c.captures {
+		bufs = append(bufs, cp.stderr[i])
+	}
+	for _, buf := range bufs {
+		if b := buf.Bytes(); len(b) > 0 {
+			if len(b) > n {
+				b = b[len(b)-n:]
+			}
+			return b
+		}
+	}
+	return nil
+
+}
+
+// tailBuffer keeps the last bytes written to it
+type tailBuffer struct {
+	mu    sync.Mutex
+	limit int
+	buf   []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > 2*t.limit {
+		t.buf = append(t.buf[:0:0], t.buf[len(t.buf)-t.limit:]...)
+	}
+	return len(p), nil
+
+}
+
+func (t *tailBuffer) bytes() []byte {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.buf
+	if len(b) > t.limit {
+		b = b[len(b)-t.limit:]
+	}
+	return append([]byte(nil), b...)
+
+}
@@ -0,0 +1,253 @@
+package piper
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultExpectTimeout is the time Expect waits for its pattern unless WithExpectTimeout is used
+const DefaultExpectTimeout = 10 * time.Second
+
+// ErrExpectTimeout is the cause of the error returned by Wait if an expected pattern didn't show
+// up in time
+var ErrExpectTimeout = errors.New("piper: expect timed out")
+
+// WithExpectTimeout sets the time every Expect of the chain waits for its pattern.
+func WithExpectTimeout(d time.Duration) Option {
+
+	return func(c *Chain) { c.expectWait = d }
+
+}
+
+// Expect waits for s in the output of the last stage before the next step of the script runs,
+// e.g. a Send answering a prompt. The steps of the script run in order once the chain was
+// started, the input of the first stage is fed by Send and closed after the last step, so Stdin
+// must not be set. The output is matched from where the previous match ended and still reaches
+// Stdout. If the pattern doesn't show up within the timeout, see WithExpectTimeout, or the output
+// ends before, the chain is killed and Wait fails with the cause ErrExpectTimeout or
+// io.ErrUnexpectedEOF. Commands prompting on their terminal have to run with Terminal. It must be
+// called after the stages were added and before Start.
+func (c *Chain) Expect(s string) *Chain {
+
+	p := []byte(s)
+	c.script().add(scriptStep{desc: s, match: func(b []byte) int {
+		if i := bytes.Index(b, p); i >= 0 {
+			return i + len(p)
+		}
+		return -1
+	}})
+	return c
+
+}
+
+// ExpectRegexp waits for a match of re in the output of the last stage, see Expect.
+func (c *Chain) ExpectRegexp(re *regexp.Regexp) *Chain {
+
+	c.script().add(scriptStep{desc: re.String(), match: func(b []byte) int {
+		if loc := re.FindIndex(b); loc != nil {
+			return loc[1]
+		}
+		return -1
+	}})
+	return c
+
+}
+
+// Send writes s to the input of the first stage once the previous steps of the script are done,
+// see Expect.
+func (c *Chain) Send(s string) *Chain {
+
+	c.script().add(scriptStep{send: []byte(s)})
+	return c
+
+}
+
+// scriptStep is a step of a script, it either waits for a match or sends data
+type scriptStep struct {
+	// desc describes the pattern, match returns the end of its first match in the output or -1
+	desc  string
+	match func([]byte) int
+	send  []byte
+}
+
+// script runs the steps of Expect and Send against the chain
+type script struct {
+	steps   []scriptStep
+	timeout time.Duration
+	tapped  bool
+	stdin   io.WriteCloser
+	done    chan error
+
+	// mu guards the output which wasn't matched yet, changed is closed and replaced whenever it
+	// grows, ended is set once the script is done or the chain exited
+	mu      sync.Mutex
+	buf     []byte
+	changed chan struct{}
+	ended   bool
+}
+
+// script returns the script of the chain, it is created on first use.
+func (c *Chain) script() *script {
+
+	if c.scripted == nil {
+		c.scripted = &script{changed: make(chan struct{})}
+	}
+	return c.scripted
+
+}
+
+func (sc *script) add(step scriptStep) {
+
+	sc.steps = append(sc.steps, step)
+
+}
+
+// Write collects the output of the last stage.
+func (sc *script) Write(p []byte) (int, error) {
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.ended {
+		return len(p), nil
+	}
+	sc.buf = append(sc.buf, p...)
+	close(sc.changed)
+	sc.changed = make(chan struct{})
+	return len(p), nil
+
+}
+
+// end stops collecting the output and wakes a waiting Expect. The output collected before can
+// still be matched.
+func (sc *script) end() {
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if !sc.ended {
+		sc.ended = true
+		close(sc.changed)
+	}
+
+}
+
+// prepareScript connects the script to the input of the first and the output of the last stage.
+func (c *Chain) prepareScript() error {
+
+	sc := c.scripted
+	if sc == nil {
+		return nil
+	}
+	if c.Stdin != nil {
+		return errors.New("piper: the input of a scripted chain is fed by Send, Stdin must not be set")
+	}
+
+	w, err := c.stages[0].stdinPipe()
+	if err != nil {
+		return err
+	}
+	sc.stdin = w
+	sc.timeout = c.expectWait
+	if sc.timeout <= 0 {
+		sc.timeout = DefaultExpectTimeout
+	}
+	if !sc.tapped {
+		c.tapStdout(len(c.stages)-1, sc)
+		sc.tapped = true
+	}
+	return nil
+
+}
+
+// runScript runs the steps of the script, a failing step kills the chain.
+func (c *Chain) runScript() {
+
+	sc := c.scripted
+	if sc == nil {
+		return
+	}
+
+	sc.done = make(chan error, 1)
+	c.labeled(-1, "expect", func() {
+		go func() {
+			err := sc.run()
+			sc.end()
+			sc.stdin.Close()
+			if err != nil {
+				c.kill()
+			}
+			sc.done <- err
+		}()
+	})
+
+}
+
+func (sc *script) run() error {
+
+	for _, step := range sc.steps {
+		if step.match == nil {
+			if _, err := sc.stdin.Write(step.send); err != nil {
+				return errors.Wrapf(err, "unable to send %q", step.send)
+			}
+			continue
+		}
+		if err := sc.expect(step); err != nil {
+			return err
+		}
+	}
+	return nil
+
+}
+
+// expect waits for the pattern of step and drops the output up to the end of its match.
+func (sc *script) expect(step scriptStep) error {
+
+	timer := time.NewTimer(sc.timeout)
+	defer timer.Stop()
+
+	for {
+		sc.mu.Lock()
+		if end := step.match(sc.buf); end >= 0 {
+			sc.buf = sc.buf[end:]
+			sc.mu.Unlock()
+			return nil
+		}
+		ended, changed := sc.ended, sc.changed
+		sc.mu.Unlock()
+		if ended {
+			return errors.Wrapf(io.ErrUnexpectedEOF, "output ended, expecting %q", step.desc)
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return errors.Wrapf(ErrExpectTimeout, "expecting %q for %v", step.desc, sc.timeout)
+		}
+	}
+
+}
+
+// stopScript waits for the script once the chain exited and returns its error. If the chain
+// failed to start, the input is closed only.
+func (c *Chain) stopScript() error {
+
+	sc := c.scripted
+	if sc == nil || sc.stdin == nil {
+		return nil
+	}
+	sc.end()
+	if sc.done == nil {
+		sc.stdin.Close()
+		return nil
+	}
+	err := <-sc.done
+	sc.done = nil
+	return err
+
+}
@@ -0,0 +1,81 @@
+package piper_test
+
+import (
+	"io"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/noxer/piper"
+)
+
+func TestExpect(t *testing.T) {
+
+	if _, err := exec.LookPath("sh"); err != nil || runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+
+	dialog := `printf 'name? '; read n; echo "hi $n"; printf 'age? '; read a; echo "$a ok"`
+	tests := []struct {
+		name  string
+		chain func() *piper.Chain
+		want  string
+		cause error
+	}{
+		{"dialog", func() *piper.Chain {
+			return piper.Command("sh", "-c", dialog).Expect("name? ").Send("bob\n").Expect("age? ").Send("3\n").Expect("ok")
+		}, "name? hi bob\nage? 3 ok\n", nil},
+		{"regexp", func() *piper.Chain {
+			return piper.Command("sh", "-c", dialog).ExpectRegexp(regexp.MustCompile(`[a-z]+\? $`)).Send("amy\n").
+				ExpectRegexp(regexp.MustCompile(`age\? `)).Send("4\n")
+		}, "name? hi amy\nage? 4 ok\n", nil},
+		{"several stages", func() *piper.Chain {
+			return piper.Command("cat").Command("cat").Send("ping\n").Expect("ping\n").Send("pong\n").Expect("pong\n")
+		}, "ping\npong\n", nil},
+		{"match at the end of the output", func() *piper.Chain {
+			return piper.Command("sh", "-c", "echo done").Expect("done")
+		}, "done\n", nil},
+		{"after the previous match", func() *piper.Chain {
+			return piper.Command("sh", "-c", "echo a a").Expect("a").Expect("a").Expect("a")
+		}, "a a\n", io.ErrUnexpectedEOF},
+		{"output ends", func() *piper.Chain {
+			return piper.Command("sh", "-c", "echo nope").Expect("yes").Send("never\n")
+		}, "nope\n", io.ErrUnexpectedEOF},
+		{"timeout", func() *piper.Chain {
+			return piper.Command("sh", "-c", "echo waiting; exec sleep 5").
+				With(piper.WithExpectTimeout(50 * time.Millisecond)).Expect("ready")
+		}, "waiting\n", piper.ErrExpectTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			out, err := tt.chain().Output()
+			if errors.Cause(err) != tt.cause {
+				t.Errorf("got %v, want %v", err, tt.cause)
+			}
+			if string(out) != tt.want {
+				t.Errorf("got %q, want %q", out, tt.want)
+			}
+			if time.Since(start) > 3*time.Second {
+				t.Errorf("took %v", time.Since(start))
+			}
+		})
+	}
+
+}
+
+func TestExpectStdin(t *testing.T) {
+
+	c := piper.Command("cat").Expect("x")
+	c.Stdin = strings.NewReader("x")
+	if err := c.Run(); err == nil {
+		t.Error("started a scripted chain with Stdin")
+	}
+
+}
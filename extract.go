@@ -0,0 +1,383 @@
+package piper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ArchiveFormat selects the format of the stream read by Extract
+type ArchiveFormat int
+
+const (
+	// DetectFormat picks zip or tar by looking at the first bytes of the stream
+	DetectFormat ArchiveFormat = iota
+	// Tar reads an uncompressed tar stream
+	Tar
+	// Zip reads a zip archive. The stream is buffered in a temporary file because zip needs random access.
+	Zip
+)
+
+// ExtractOptions controls how Extract writes the archive to disk
+type ExtractOptions struct {
+	Format ArchiveFormat
+	// Mode maps the mode stored in the archive to the permissions used on disk.
+	// If it is nil the permission bits of the archive are used, setuid, setgid and sticky bits are dropped.
+	Mode func(name string, mode fs.FileMode) fs.FileMode
+	// Symlinks creates symbolic links which point into the target directory, all links are skipped otherwise.
+	// A target leaving a directory with ".." must pass through real directories, not through other links.
+	Symlinks bool
+	// MaxSize limits the total size of the extracted files and of the zip stream buffered in a
+	// temporary file in bytes, the stage fails once it is exceeded. No limit if zero.
+	MaxSize int64
+}
+
+// Extract adds a stage to the back of the chain which extracts the tar or zip stream it reads into dir.
+// Entries which would be written outside of dir, directly or through a symbolic link, fail the stage.
+func (c *Chain) Extract(dir string, opts ExtractOptions) *Chain {
+
+	return c.Func(extract(dir, opts))
+
+}
+
+func extract(dir string, opts ExtractOptions) StageFunc {
+
+	return func(r io.Reader, _ io.Writer) error {
+
+		err := os.MkdirAll(dir, 0777)
+		if err != nil {
+			return errors.Wrap(err, "unable to create target directory")
+		}
+		root, err := filepath.Abs(dir)
+		if err == nil {
+			root, err = filepath.EvalSymlinks(root)
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to resolve target directory")
+		}
+		x := &extractor{root: root, opts: opts}
+
+		br := bufio.NewReader(r)
+		format := opts.Format
+		if format == DetectFormat {
+			format = Tar
+			if head, _ := br.Peek(4); bytes.Equal(head, []byte("PK\x03\x04")) {
+				format = Zip
+			}
+		}
+
+		if format == Zip {
+			err = x.zip(br)
+		} else {
+			err = x.tar(br)
+		}
+		return errors.Wrap(err, "unable to extract archive")
+
+	}
+
+}
+
+type extractor struct {
+	root string
+	opts ExtractOptions
+	// written counts the bytes of the extracted files
+	written int64
+}
+
+func (x *extractor) tar(r io.Reader) error {
+
+	tr := tar.NewReader(r)
+	for {
+
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = x.dir(hdr.Name, mode)
+		case tar.TypeReg, tar.TypeRegA:
+			err = x.file(hdr.Name, mode, tr)
+		case tar.TypeSymlink:
+			err = x.symlink(hdr.Name, hdr.Linkname)
+		case tar.TypeLink:
+			err = x.hardlink(hdr.Name, hdr.Linkname)
+		}
+		if err != nil {
+			return err
+		}
+
+	}
+
+}
+
+func (x *extractor) zip(r io.Reader) error {
+
+	tmp, err := os.CreateTemp("", "piper-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, x.limit(r, 0))
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range zr.File {
+
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = x.dir(f.Name, mode)
+		case mode&fs.ModeSymlink != 0:
+			err = x.zipSymlink(f)
+		case mode.IsRegular():
+			err = x.zipFile(f)
+		}
+		if err != nil {
+			return err
+		}
+
+	}
+
+	return nil
+
+}
+
+func (x *extractor) zipFile(f *zip.File) error {
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return x.file(f.Name, f.Mode(), rc)
+
+}
+
+func (x *extractor) zipSymlink(f *zip.File) error {
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	target, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return err
+	}
+	return x.symlink(f.Name, string(target))
+
+}
+
+func (x *extractor) dir(name string, mode fs.FileMode) error {
+
+	p, err := x.path(name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(p, x.mode(name, mode))
+
+}
+
+func (x *extractor) file(name string, mode fs.FileMode, r io.Reader) error {
+
+	p, err := x.prepare(name)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, x.mode(name, mode))
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, x.limit(r, x.written))
+	x.written += n
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+
+}
+
+// limit fails reading r once more than the MaxSize of the options minus used bytes were read.
+func (x *extractor) limit(r io.Reader, used int64) io.Reader {
+
+	if x.opts.MaxSize <= 0 {
+		return r
+	}
+	return &limitedReader{r: r, n: x.opts.MaxSize - used, max: x.opts.MaxSize}
+
+}
+
+// limitedReader fails once more than n bytes are read
+type limitedReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+
+	// one byte more is read to tell a stream of exactly n bytes from a larger one
+	if rest := l.n + 1; rest > 0 && int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, errors.Errorf("piper: the archive exceeds %d bytes", l.max)
+	}
+	return n, err
+
+}
+
+func (x *extractor) symlink(name, target string) error {
+
+	if !x.opts.Symlinks {
+		return nil
+	}
+
+	p, err := x.prepare(name)
+	if err != nil {
+		return err
+	}
+	if !x.inside(filepath.Dir(p), target) {
+		return errors.Errorf("piper: link %q points outside of the target directory", name)
+	}
+	return os.Symlink(target, p)
+
+}
+
+// inside reports whether the link target stays inside the root when it is resolved from dir.
+// Checking the joined path lexically isn't enough, a ".." after a link moves up from the target of
+// the link, so the components left with ".." must be real directories. Links they pass through
+// otherwise were checked when they were created.
+func (x *extractor) inside(dir, target string) bool {
+
+	if filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return false
+	}
+	cur, err := filepath.EvalSymlinks(dir)
+	if err != nil || !x.within(cur) {
+		return false
+	}
+
+	for _, name := range strings.Split(filepath.ToSlash(target), "/") {
+		switch name {
+		case "", ".":
+		case "..":
+			if fi, err := os.Lstat(cur); err != nil || !fi.IsDir() {
+				return false
+			}
+			cur = filepath.Dir(cur)
+			if !x.within(cur) {
+				return false
+			}
+		default:
+			cur = filepath.Join(cur, name)
+		}
+	}
+	return x.within(cur)
+
+}
+
+func (x *extractor) hardlink(name, target string) error {
+
+	p, err := x.prepare(name)
+	if err != nil {
+		return err
+	}
+	t, err := x.path(target)
+	if err != nil {
+		return err
+	}
+	return os.Link(t, p)
+
+}
+
+// prepare validates the entry name, creates its parent directory and removes
+// an existing non-directory entry so it can't redirect the write.
+func (x *extractor) prepare(name string) (string, error) {
+
+	p, err := x.path(name)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(filepath.Dir(p), 0777)
+	if err != nil {
+		return "", err
+	}
+	if fi, err := os.Lstat(p); err == nil && !fi.IsDir() {
+		os.Remove(p)
+	}
+	return p, nil
+
+}
+
+// path maps an entry name to its location on disk, rejecting names that escape the root
+// either lexically or through symbolic links which already exist on disk.
+func (x *extractor) path(name string) (string, error) {
+
+	local := filepath.FromSlash(name)
+	if !filepath.IsLocal(local) {
+		return "", errors.Errorf("piper: entry %q points outside of the target directory", name)
+	}
+	p := filepath.Join(x.root, local)
+
+	dir := filepath.Dir(p)
+	for {
+
+		real, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			if !x.within(real) {
+				return "", errors.Errorf("piper: entry %q points outside of the target directory", name)
+			}
+			return p, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		dir = filepath.Dir(dir)
+
+	}
+
+}
+
+func (x *extractor) within(p string) bool {
+
+	rel, err := filepath.Rel(x.root, p)
+	return err == nil && (rel == "." || filepath.IsLocal(rel))
+
+}
+
+func (x *extractor) mode(name string, mode fs.FileMode) fs.FileMode {
+
+	if x.opts.Mode != nil {
+		return x.opts.Mode(name, mode)
+	}
+	return mode.Perm()
+
+}
@@ -0,0 +1,180 @@
+package piper_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/noxer/piper"
+)
+
+// entry is a file, directory or link of a test archive
+type entry struct {
+	name string
+	body string
+	link string
+	dir  bool
+	hard bool
+}
+
+func tarArchive(t *testing.T, entries []entry) []byte {
+
+	t.Helper()
+
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		switch {
+		case e.dir:
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		case e.hard:
+			hdr.Typeflag, hdr.Linkname = tar.TypeLink, e.link
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname = tar.TypeSymlink, e.link
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			io.WriteString(tw, e.body)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+
+}
+
+func zipArchive(t *testing.T, entries []entry) []byte {
+
+	t.Helper()
+
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name}
+		hdr.SetMode(0644)
+		body := e.body
+		if e.link != "" {
+			hdr.SetMode(os.ModeSymlink | 0777)
+			body = e.link
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, body)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+
+}
+
+func extract(archive []byte, dir string, opts piper.ExtractOptions) error {
+
+	return piper.Func(func(_ io.Reader, w io.Writer) error {
+		_, err := w.Write(archive)
+		return err
+	}).Extract(dir, opts).Run()
+
+}
+
+func TestExtractTraversal(t *testing.T) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links need privileges on Windows")
+	}
+
+	tests := []struct {
+		name    string
+		entries []entry
+		ok      bool
+	}{
+		{"plain", []entry{{name: "a/b.txt", body: "b"}, {name: "c", dir: true}}, true},
+		{"dot dot", []entry{{name: "../evil", body: "x"}}, false},
+		{"nested dot dot", []entry{{name: "a/../../evil", body: "x"}}, false},
+		{"absolute", []entry{{name: "/evil", body: "x"}}, false},
+		{"link inside", []entry{{name: "a/b.txt", body: "b"}, {name: "l", link: "a/b.txt"}}, true},
+		{"link to parent inside", []entry{{name: "x/up", link: ".."}}, true},
+		{"absolute link", []entry{{name: "l", link: "/etc"}}, false},
+		{"link outside", []entry{{name: "l", link: "../outside"}}, false},
+		{"link through a link", []entry{{name: "x/up", link: ".."}, {name: "l", link: "x/up/.."}}, false},
+		{"link through a missing directory", []entry{{name: "l", link: "x/../.."}}, false},
+		{"link through a later link", []entry{{name: "l", link: "a/../b"}, {name: "a", link: "."}}, false},
+		{"write through a link", []entry{{name: "l", link: "."}, {name: "l/../../evil", body: "x"}}, false},
+		{"write through an outer link", []entry{{name: "sub", dir: true}, {name: "sub/l", link: ".."}, {name: "sub/l/f", body: "x"}}, true},
+		{"hard link outside", []entry{{name: "h", link: "../outside", hard: true}}, false},
+	}
+
+	for _, tt := range tests {
+		for format, archive := range map[string]func(*testing.T, []entry) []byte{"tar": tarArchive, "zip": zipArchive} {
+			hard := false
+			for _, e := range tt.entries {
+				hard = hard || e.hard || e.dir
+			}
+			if format == "zip" && hard {
+				continue
+			}
+
+			t.Run(tt.name+"/"+format, func(t *testing.T) {
+				base := t.TempDir()
+				dir := filepath.Join(base, "root")
+				os.WriteFile(filepath.Join(base, "outside"), []byte("secret"), 0644)
+
+				err := extract(archive(t, tt.entries), dir, piper.ExtractOptions{Symlinks: true})
+				if (err == nil) != tt.ok {
+					t.Fatalf("got %v, ok %v", err, tt.ok)
+				}
+				if _, err := os.Stat(filepath.Join(base, "evil")); err == nil {
+					t.Error("wrote outside of the target directory")
+				}
+				filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+					if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+						return err
+					}
+					real, err := filepath.EvalSymlinks(p)
+					if err == nil && !strings.HasPrefix(real, dir) {
+						t.Errorf("%s resolves to %s", p, real)
+					}
+					return nil
+				})
+			})
+		}
+	}
+
+}
+
+func TestExtractMaxSize(t *testing.T) {
+
+	entries := []entry{{name: "a", body: strings.Repeat("a", 600)}, {name: "b", body: strings.Repeat("b", 600)}}
+	tests := []struct {
+		format  string
+		archive []byte
+		max     int64
+		ok      bool
+	}{
+		{"tar", tarArchive(t, entries), 0, true},
+		{"tar", tarArchive(t, entries), 1200, true},
+		{"tar", tarArchive(t, entries), 1000, false},
+		{"zip", zipArchive(t, entries), 0, true},
+		{"zip", zipArchive(t, entries), 100, false},
+	}
+
+	for _, tt := range tests {
+		err := extract(tt.archive, t.TempDir(), piper.ExtractOptions{MaxSize: tt.max})
+		if (err == nil) != tt.ok {
+			t.Errorf("%s with MaxSize %d: got %v, ok %v", tt.format, tt.max, err, tt.ok)
+		}
+	}
+
+}
@@ -0,0 +1,63 @@
+package piper
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	nullOnce sync.Once
+	null     *os.File
+	nullErr  error
+)
+
+// devNull returns a shared handle of the null device, it is opened once and never closed.
+func devNull() (*os.File, error) {
+
+	nullOnce.Do(func() {
+		null, nullErr = os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	})
+	return null, nullErr
+
+}
+
+// prepareFast sets up the commands to be started with as little work in the parent as possible,
+// see Chain.FastStart.
+func (c *Chain) prepareFast() error {
+
+	null, err := devNull()
+	if err != nil {
+		return err
+	}
+
+	var env []string
+	for _, s := range c.stages {
+
+		if s.cmd == nil || s.warm != nil {
+			continue
+		}
+		cmd := s.cmd
+
+		if cmd.Stdin == nil {
+			cmd.Stdin = null
+		}
+		if cmd.Stdout == nil || cmd.Stdout == io.Discard {
+			cmd.Stdout = null
+		}
+		if cmd.Stderr == nil || cmd.Stderr == io.Discard {
+			cmd.Stderr = null
+		}
+
+		if cmd.Env == nil {
+			if env == nil {
+				env = os.Environ()
+			}
+			cmd.Env = env
+		}
+
+	}
+
+	return nil
+
+}
@@ -0,0 +1,90 @@
+package piper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"sync/atomic"
+)
+
+// WithFraming sets how the data passing the links of the chain is split into records, e.g. with
+// ScanNUL or ScanVarint, so Result can tell where the last complete record ended. By default the
+// data is split into lines. Records larger than DefaultMaxRecord bytes end the tracking, the data
+// after the last complete record before them counts as truncated.
+func WithFraming(split bufio.SplitFunc) Option {
+
+	return func(c *Chain) { c.framing = split }
+
+}
+
+// framer splits the data written to a meter or an Analyzer into records with split, lines if
+// it is nil. tail holds the incomplete record at the end of the data, broken is set if the
+// framing failed.
+type framer struct {
+	split  bufio.SplitFunc
+	tail   []byte
+	broken bool
+}
+
+// frame feeds p to the framing and returns the number of bytes of p up to the end of the last
+// record completed by it, 0 if p completes none, and the number of records it completed.
+func (f *framer) frame(p []byte) (end, records int) {
+
+	if f.split == nil {
+		return bytes.LastIndexByte(p, '\n') + 1, bytes.Count(p, []byte{'\n'})
+	}
+	if f.broken {
+		return 0, 0
+	}
+
+	pending := len(f.tail)
+	buf := p
+	if pending > 0 {
+		f.tail = append(f.tail, p...)
+		buf = f.tail
+	}
+
+	off := 0
+	for off < len(buf) {
+		adv, _, err := f.split(buf[off:], false)
+		if err != nil || adv < 0 || adv > len(buf)-off {
+			f.broken = true
+			f.tail = nil
+			return 0, 0
+		}
+		if adv == 0 {
+			break
+		}
+		off += adv
+		records++
+	}
+
+	// room for the delimiter or size prefix of a record of DefaultMaxRecord bytes, like NewScanner
+	if len(buf)-off > DefaultMaxRecord+binary.MaxVarintLen64 {
+		f.broken = true
+		f.tail = nil
+	} else {
+		f.tail = append(f.tail[:0], buf[off:]...)
+	}
+
+	if off <= pending {
+		return 0, records
+	}
+	return off - pending, records
+
+}
+
+// framed returns the number of bytes up to the end of the last complete record.
+func (m *meter) framed() int64 {
+
+	return atomic.LoadInt64(&m.end)
+
+}
+
+// partial reports whether the run was cut short, see Result.Partial.
+func (c *Chain) partial() bool {
+
+	return atomic.LoadInt32(&c.canceled) == 1 || atomic.LoadInt32(&c.timedOut) == 1 ||
+		atomic.LoadInt32(&c.killed) == 1
+
+}
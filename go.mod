@@ -1,3 +1,8 @@
 module github.com/noxer/piper
 
-require github.com/pkg/errors v0.8.0
+go 1.24
+
+require (
+	github.com/pkg/errors v0.8.0
+	gopkg.in/yaml.v3 v3.0.1
+)
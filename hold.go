@@ -0,0 +1,79 @@
+package piper
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// errKeepOpen is returned by the methods running a chain with a KeepOpenLink to completion
+var errKeepOpen = errors.New("piper: a chain with a KeepOpenLink must be run with Start, Wait and Drain")
+
+// hold keeps the write end of the KeepOpenLink after stage i open until Drain.
+func (c *Chain) hold(i int, w *os.File) {
+
+	c.heldMu.Lock()
+	if c.held == nil {
+		c.held = make(map[int]*os.File)
+	}
+	c.held[i] = w
+	c.heldMu.Unlock()
+
+}
+
+// keepsOpen reports whether a stage of the chain is linked with a KeepOpenLink.
+func (c *Chain) keepsOpen() bool {
+
+	for _, s := range c.stages {
+		if s.link == KeepOpenLink {
+			return true
+		}
+	}
+	return false
+
+}
+
+// Attach returns the write end of the KeepOpenLink after stage i, so a new upstream can take over
+// once stage i exited, e.g. a restarted copy of it:
+//
+//	up := exec.Command("producer")
+//	up.Stdout, err = c.Attach(0)
+//
+// The file belongs to the chain and must not be closed, Drain closes it. The chain doesn't wait
+// for the new upstream, the stage after the link sees EOF once it exited and Drain was called.
+// Attach fails if stage i has no KeepOpenLink, the chain isn't started or it was drained.
+func (c *Chain) Attach(i int) (*os.File, error) {
+
+	if i < 0 || i >= len(c.stages)-1 || c.stages[i].link != KeepOpenLink {
+		return nil, errors.Errorf("piper: stage #%d has no KeepOpenLink", i)
+	}
+
+	c.heldMu.Lock()
+	defer c.heldMu.Unlock()
+
+	w, ok := c.held[i]
+	if !ok {
+		return nil, errors.Errorf("piper: the KeepOpenLink after stage #%d is not open", i)
+	}
+	return w, nil
+
+}
+
+// Drain closes the keep-open links of the chain, see KeepOpenLink. The stage after such a link
+// sees EOF once the stage before it exited as well. A chain with a keep-open link must be drained,
+// Wait doesn't return before, so it is run with Start, Wait and a call to Drain, e.g. from a
+// supervisor, and Run, Output and CombinedOutput fail right away. Drain can be called concurrently
+// with Wait and more than once, Shutdown and the cancellation of the context of the chain drain
+// the links too.
+func (c *Chain) Drain() {
+
+	c.heldMu.Lock()
+	held := c.held
+	c.held = nil
+	c.heldMu.Unlock()
+
+	for _, w := range held {
+		w.Close()
+	}
+
+}
@@ -0,0 +1,40 @@
+package piper
+
+import (
+	"os"
+	"os/exec"
+)
+
+// BeforeStart adds fn to the hooks called with every command right before it is started, after
+// the chain configured it, so fn can log it or change it, e.g. its environment. Function stages
+// and the instances of a Pool don't run the hooks. It must be called before Start.
+func (c *Chain) BeforeStart(fn func(i int, cmd *exec.Cmd)) *Chain {
+
+	c.before = append(c.before, fn)
+	return c
+
+}
+
+// AfterExit adds fn to the hooks called once stage i exited and was reaped, e.g. to record
+// metrics. state is nil for function stages, err is the error recorded for the stage, see
+// ErrorTransformer. Stages which never started don't run the hooks. fn is called concurrently
+// for stages exiting at the same time. It must be called before Wait.
+func (c *Chain) AfterExit(fn func(i int, state *os.ProcessState, err error)) *Chain {
+
+	c.after = append(c.after, fn)
+	return c
+
+}
+
+// afterExit runs the AfterExit hooks for stage i.
+func (c *Chain) afterExit(i int, s *stage, err error) {
+
+	var state *os.ProcessState
+	if s.cmd != nil {
+		state = s.cmd.ProcessState
+	}
+	for _, fn := range c.after {
+		fn(i, state, err)
+	}
+
+}
@@ -0,0 +1,149 @@
+package jobs
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/noxer/piper/stream"
+	"github.com/pkg/errors"
+)
+
+// SubmitRequest is the body of a submission to the HTTP interface
+type SubmitRequest struct {
+	Template string `json:"template"`
+	// Stdin is passed to the first stage of the job
+	Stdin string `json:"stdin,omitempty"`
+}
+
+// Handler returns the HTTP interface of the controller:
+//
+//	POST   /jobs           submit a job, the body is a SubmitRequest, responds with its Status
+//	GET    /jobs           list the status of all jobs
+//	GET    /jobs/{id}      get the status of a job
+//	DELETE /jobs/{id}      cancel a job
+//	GET    /jobs/{id}/logs follow stdout and stderr of a job as Server-Sent Events
+//
+// Use http.StripPrefix to mount it below another path.
+func (c *Controller) Handler() http.Handler {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", c.handleSubmit)
+	mux.HandleFunc("GET /jobs", c.handleList)
+	mux.HandleFunc("GET /jobs/{id}", c.handleStatus)
+	mux.HandleFunc("DELETE /jobs/{id}", c.handleCancel)
+	mux.HandleFunc("GET /jobs/{id}/logs", c.handleLogs)
+	return mux
+
+}
+
+func (c *Controller) handleSubmit(w http.ResponseWriter, req *http.Request) {
+
+	var sr SubmitRequest
+	if err := json.NewDecoder(req.Body).Decode(&sr); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var stdin io.Reader
+	if sr.Stdin != "" {
+		stdin = strings.NewReader(sr.Stdin)
+	}
+	id, err := c.Submit(req.Context(), sr.Template, stdin)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	st, err := c.Status(req.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+id)
+	writeJSON(w, http.StatusCreated, st)
+
+}
+
+func (c *Controller) handleList(w http.ResponseWriter, req *http.Request) {
+
+	l, err := c.List(req.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, l)
+
+}
+
+func (c *Controller) handleStatus(w http.ResponseWriter, req *http.Request) {
+
+	st, err := c.Status(req.Context(), req.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+
+}
+
+func (c *Controller) handleCancel(w http.ResponseWriter, req *http.Request) {
+
+	id := req.PathValue("id")
+	if err := c.Cancel(req.Context(), id); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	st, err := c.Status(req.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, st)
+
+}
+
+func (c *Controller) handleLogs(w http.ResponseWriter, req *http.Request) {
+
+	id := req.PathValue("id")
+	stdout, err := c.Logs(req.Context(), id, Stdout)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	stderr, err := c.Logs(req.Context(), id, Stderr)
+	if err != nil {
+		stdout.Close()
+		writeError(w, err)
+		return
+	}
+
+	stream.SSE(w, req, stream.Options{},
+		stream.Source{Event: Stdout, R: stdout},
+		stream.Source{Event: Stderr, R: stderr},
+	)
+
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+
+}
+
+func writeError(w http.ResponseWriter, err error) {
+
+	code := http.StatusInternalServerError
+	switch errors.Cause(err) {
+	case ErrNotFound:
+		code = http.StatusNotFound
+	case ErrUnknownTemplate:
+		code = http.StatusBadRequest
+	}
+	http.Error(w, err.Error(), code)
+
+}
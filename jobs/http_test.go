@@ -0,0 +1,70 @@
+package jobs_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/noxer/piper/jobs"
+)
+
+func TestHandler(t *testing.T) {
+
+	c := &jobs.Controller{Templates: templates()}
+	srv := httptest.NewServer(c.Handler())
+	defer srv.Close()
+
+	do := func(method, path, body string) (int, string) {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	code, body := do("POST", "/jobs", `{"template":"copy","stdin":"hello\nworld"}`)
+	var st jobs.Status
+	if err := json.Unmarshal([]byte(body), &st); code != http.StatusCreated || err != nil {
+		t.Fatalf("submit: got %d %s", code, body)
+	}
+	wait(t, c, st.ID)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int
+		want   string
+	}{
+		{"status", "GET", "/jobs/" + st.ID, "", http.StatusOK, `"state":"succeeded"`},
+		{"list", "GET", "/jobs", "", http.StatusOK, `"id":"` + st.ID + `"`},
+		{"logs", "GET", "/jobs/" + st.ID + "/logs", "", http.StatusOK, "event: stdout\ndata: hello\ndata: world\n"},
+		{"cancel finished", "DELETE", "/jobs/" + st.ID, "", http.StatusAccepted, `"state":"succeeded"`},
+		{"unknown job", "GET", "/jobs/missing", "", http.StatusNotFound, "no such job"},
+		{"cancel unknown job", "DELETE", "/jobs/missing", "", http.StatusNotFound, "no such job"},
+		{"logs of unknown job", "GET", "/jobs/missing/logs", "", http.StatusNotFound, "no such job"},
+		{"unknown template", "POST", "/jobs", `{"template":"missing"}`, http.StatusBadRequest, "no such template"},
+		{"invalid request", "POST", "/jobs", `{"template":`, http.StatusBadRequest, "invalid request"},
+		{"method", "PUT", "/jobs", "", http.StatusMethodNotAllowed, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := do(tt.method, tt.path, tt.body)
+			if code != tt.code || !strings.Contains(body, tt.want) {
+				t.Errorf("got %d %q, want %d with %q", code, body, tt.code, tt.want)
+			}
+		})
+	}
+
+}
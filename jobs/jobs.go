@@ -0,0 +1,477 @@
+// Package jobs is a minimal embeddable job server for chains. Chains are submitted by the name of
+// a template, which is cloned for every job, and run by a piper.Scheduler. The status, result and
+// output of every job can be queried while it runs and after it finished, either through the
+// Controller or its HTTP interface, see Controller.Handler.
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/noxer/piper"
+	"github.com/pkg/errors"
+)
+
+// DefaultLogLimit is the number of bytes of stdout and stderr kept per job if Controller.LogLimit is zero
+const DefaultLogLimit = 1 << 20
+
+// Errors returned by the Controller
+var (
+	ErrNotFound        = errors.New("jobs: no such job")
+	ErrUnknownTemplate = errors.New("jobs: no such template")
+)
+
+// Names of the logs of a job
+const (
+	Stdout = "stdout"
+	Stderr = "stderr"
+)
+
+// State is the state of a job
+type State string
+
+// States of a job
+const (
+	Queued    State = "queued"
+	Running   State = "running"
+	Succeeded State = "succeeded"
+	Failed    State = "failed"
+	Canceled  State = "canceled"
+)
+
+// Status describes a job
+type Status struct {
+	ID        string        `json:"id"`
+	Template  string        `json:"template"`
+	State     State         `json:"state"`
+	Submitted time.Time     `json:"submitted"`
+	Finished  time.Time     `json:"finished,omitzero"`
+	Error     string        `json:"error,omitempty"`
+	Stages    []StageStatus `json:"stages,omitempty"`
+}
+
+// StageStatus is the outcome of a single stage of a finished job
+type StageStatus struct {
+	Path     string   `json:"path,omitempty"`
+	Args     []string `json:"args,omitempty"`
+	ExitCode int      `json:"exitCode"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Controller runs and keeps track of jobs. Templates must not be changed once jobs were submitted.
+type Controller struct {
+	// Templates maps the names jobs are submitted with to the chains they run. The Stdin, Stdout,
+	// Stderr and Allerr of the templates are replaced by the input and the logs of the job.
+	Templates map[string]*piper.Chain
+	// Scheduler runs the jobs, any number of jobs run at the same time if nil
+	Scheduler *piper.Scheduler
+	// Store persists the jobs, a MemoryStore if nil. Running jobs are served from memory, finished
+	// ones from the store.
+	Store Store
+	// LogLimit is the number of bytes of stdout and stderr kept per job, DefaultLogLimit if zero.
+	// The start and the end of longer output are kept.
+	LogLimit int
+
+	once  sync.Once
+	sched *piper.Scheduler
+	store Store
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// Submit starts a job running a clone of the template name with the input stdin, which may be nil.
+// It returns the ID of the job.
+func (c *Controller) Submit(ctx context.Context, name string, stdin io.Reader) (string, error) {
+
+	t, ok := c.Templates[name]
+	if !ok {
+		return "", errors.Wrapf(ErrUnknownTemplate, "unable to submit %q", name)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to create job ID")
+	}
+
+	limit := c.LogLimit
+	if limit <= 0 {
+		limit = DefaultLogLimit
+	}
+	j := &job{
+		id:        id,
+		template:  name,
+		chain:     t.Clone(),
+		submitted: time.Now(),
+		stdout:    newJobLog(limit),
+		stderr:    newJobLog(limit),
+		done:      make(chan struct{}),
+	}
+	j.chain.Stdin = stdin
+	j.chain.Stdout = j.stdout
+	j.chain.Stderr = j.stderr
+	j.chain.Allerr = j.stderr
+
+	err = c.storage().Put(ctx, j.record())
+	if err != nil {
+		return "", err
+	}
+
+	var jctx context.Context
+	jctx, j.cancel = context.WithCancel(context.Background())
+
+	c.mu.Lock()
+	if c.jobs == nil {
+		c.jobs = map[string]*job{}
+	}
+	c.jobs[id] = j
+	c.mu.Unlock()
+
+	go c.run(jctx, j)
+	return id, nil
+
+}
+
+// Restore marks the jobs which were queued or running when the service stopped as failed.
+// Call it once on startup, before submitting jobs.
+func (c *Controller) Restore(ctx context.Context) error {
+
+	l, err := c.storage().List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, st := range l {
+		if st.State != Queued && st.State != Running {
+			continue
+		}
+		if _, err := c.job(st.ID); err == nil {
+			continue
+		}
+
+		r, err := c.storage().Get(ctx, st.ID)
+		if err != nil {
+			return err
+		}
+		r.Status.State = Failed
+		r.Status.Error = "interrupted by a restart"
+		r.Status.Finished = time.Now()
+		if err := c.storage().Put(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+
+}
+
+// Status returns the status of the job id.
+func (c *Controller) Status(ctx context.Context, id string) (Status, error) {
+
+	if j, err := c.job(id); err == nil {
+		return j.status(), nil
+	}
+
+	r, err := c.storage().Get(ctx, id)
+	if err != nil {
+		return Status{}, err
+	}
+	return r.Status, nil
+
+}
+
+// List returns the status of all jobs, ordered by submission.
+func (c *Controller) List(ctx context.Context) ([]Status, error) {
+
+	l, err := c.storage().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the store knows the running jobs only as submitted
+	for i, st := range l {
+		if j, err := c.job(st.ID); err == nil {
+			l[i] = j.status()
+		}
+	}
+	return l, nil
+
+}
+
+// Cancel stops the job id. Queued jobs never start, running ones are killed. Canceling a finished
+// job has no effect.
+func (c *Controller) Cancel(ctx context.Context, id string) error {
+
+	j, err := c.job(id)
+	if err == nil {
+		j.cancel()
+		return nil
+	}
+
+	_, err = c.storage().Get(ctx, id)
+	return err
+
+}
+
+// Wait blocks until the job id finished or ctx is done and returns its status.
+func (c *Controller) Wait(ctx context.Context, id string) (Status, error) {
+
+	j, err := c.job(id)
+	if err != nil {
+		return c.Status(ctx, id)
+	}
+
+	select {
+	case <-j.done:
+		return j.status(), nil
+	case <-ctx.Done():
+		return Status{}, ctx.Err()
+	}
+
+}
+
+// Logs returns a reader for the log name (Stdout or Stderr) of the job id. It delivers the output
+// kept so far followed by the output written from now on and returns io.EOF once the job finished.
+func (c *Controller) Logs(ctx context.Context, id, name string) (io.ReadCloser, error) {
+
+	if name != Stdout && name != Stderr {
+		return nil, errors.Errorf("jobs: no log %q", name)
+	}
+
+	if j, err := c.job(id); err == nil {
+		if name == Stdout {
+			return j.stdout.follow(), nil
+		}
+		return j.stderr.follow(), nil
+	}
+
+	r, err := c.storage().Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if name == Stdout {
+		return io.NopCloser(bytes.NewReader(r.Stdout)), nil
+	}
+	return io.NopCloser(bytes.NewReader(r.Stderr)), nil
+
+}
+
+// run runs the job and hands it over to the store once it finished. If that fails the job is
+// kept in memory.
+func (c *Controller) run(ctx context.Context, j *job) {
+
+	j.run(ctx, c.scheduler())
+
+	if err := c.storage().Put(context.Background(), j.record()); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	delete(c.jobs, j.id)
+	c.mu.Unlock()
+
+}
+
+func (c *Controller) job(id string) (*job, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	j, ok := c.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return j, nil
+
+}
+
+func (c *Controller) scheduler() *piper.Scheduler {
+
+	c.init()
+	return c.sched
+
+}
+
+func (c *Controller) storage() Store {
+
+	c.init()
+	return c.store
+
+}
+
+func (c *Controller) init() {
+
+	c.once.Do(func() {
+		c.sched = c.Scheduler
+		if c.sched == nil {
+			c.sched = &piper.Scheduler{}
+		}
+		c.store = c.Store
+		if c.store == nil {
+			c.store = &MemoryStore{}
+		}
+	})
+
+}
+
+func newID() (string, error) {
+
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+
+}
+
+type job struct {
+	id        string
+	template  string
+	chain     *piper.Chain
+	submitted time.Time
+	cancel    context.CancelFunc
+	stdout    *jobLog
+	stderr    *jobLog
+	done      chan struct{}
+
+	mu       sync.Mutex
+	finished time.Time
+	state    State
+	err      error
+}
+
+func (j *job) run(ctx context.Context, s *piper.Scheduler) {
+
+	err := s.Run(ctx, j.chain)
+
+	j.stdout.Close()
+	j.stderr.Close()
+
+	j.mu.Lock()
+	j.finished = time.Now()
+	j.err = err
+	switch {
+	case err == nil:
+		j.state = Succeeded
+	case ctx.Err() != nil:
+		j.state = Canceled
+	default:
+		j.state = Failed
+	}
+	j.mu.Unlock()
+
+	j.cancel()
+	close(j.done)
+
+}
+
+func (j *job) status() Status {
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	st := Status{
+		ID:        j.id,
+		Template:  j.template,
+		State:     j.state,
+		Submitted: j.submitted,
+		Finished:  j.finished,
+	}
+	if j.err != nil {
+		st.Error = j.err.Error()
+	}
+
+	if st.State == "" {
+		st.State = Queued
+		for _, s := range j.chain.Debug().Stages {
+			if s.Running || s.Exited {
+				st.State = Running
+				break
+			}
+		}
+		return st
+	}
+
+	if r := j.chain.Result(); r != nil {
+		for _, s := range r.Stages {
+			ss := StageStatus{Path: s.Path, Args: s.Args, ExitCode: -1}
+			if s.State != nil {
+				ss.ExitCode = s.State.ExitCode()
+			}
+			if s.Err != nil {
+				ss.Error = s.Err.Error()
+			}
+			st.Stages = append(st.Stages, ss)
+		}
+	}
+	return st
+
+}
+
+// record returns the persisted state of the job.
+func (j *job) record() *Record {
+
+	r := &Record{Status: j.status()}
+	for _, n := range j.chain.Topology().Nodes {
+		r.Chain = append(r.Chain, Command{Path: n.Path, Args: n.Args})
+	}
+	if r.Status.State != Queued && r.Status.State != Running {
+		r.Stdout = j.stdout.buf.Bytes()
+		r.Stderr = j.stderr.buf.Bytes()
+	}
+	return r
+
+}
+
+// jobLog keeps the output of a job and passes it on to followers
+type jobLog struct {
+	mu   sync.Mutex
+	buf  *piper.LimitedBuffer
+	live *piper.Broadcast
+}
+
+func newJobLog(limit int) *jobLog {
+
+	return &jobLog{
+		buf:  piper.NewHeadTailBuffer(piper.Limits{Bytes: limit, KeepTail: true}),
+		live: piper.NewBroadcast(limit),
+	}
+
+}
+
+func (l *jobLog) Write(p []byte) (int, error) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf.Write(p)
+	return l.live.Write(p)
+
+}
+
+func (l *jobLog) Close() error {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.live.Close()
+
+}
+
+// follow returns the output kept so far followed by the live output.
+func (l *jobLog) follow() io.ReadCloser {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	live := l.live.Attach()
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(l.buf.Bytes()), live), live}
+
+}
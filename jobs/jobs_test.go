@@ -0,0 +1,236 @@
+package jobs_test
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/noxer/piper"
+	"github.com/noxer/piper/jobs"
+)
+
+// templates returns chains which copy their input, fail or write more than a log keeps
+func templates() map[string]*piper.Chain {
+
+	return map[string]*piper.Chain{
+		"copy": piper.Func(func(r io.Reader, w io.Writer) error {
+			_, err := io.Copy(w, r)
+			return err
+		}),
+		"fail": piper.Func(func(_ io.Reader, w io.Writer) error {
+			io.WriteString(w, "partial")
+			return errors.New("broken")
+		}),
+		"long": piper.Func(func(_ io.Reader, w io.Writer) error {
+			_, err := io.WriteString(w, "head"+strings.Repeat(".", 100)+"tail")
+			return err
+		}),
+	}
+
+}
+
+// wait returns the status of the finished job id
+func wait(t *testing.T, c *jobs.Controller, id string) jobs.Status {
+
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	st, err := c.Wait(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
+
+}
+
+// logs returns the content of the log name of the job id
+func logs(t *testing.T, c *jobs.Controller, id, name string) string {
+
+	t.Helper()
+
+	r, err := c.Logs(context.Background(), id, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+
+}
+
+func TestController(t *testing.T) {
+
+	tests := []struct {
+		template string
+		stdin    io.Reader
+		state    jobs.State
+		err      string
+		stdout   string
+	}{
+		{"copy", strings.NewReader("input"), jobs.Succeeded, "", "input"},
+		{"copy", nil, jobs.Succeeded, "", ""},
+		{"fail", nil, jobs.Failed, "broken", "partial"},
+		{"long", nil, jobs.Succeeded, "", "head......\n[... 88 bytes truncated]\n......tail"},
+	}
+
+	c := &jobs.Controller{Templates: templates(), LogLimit: 20}
+	var ids []string
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			id, err := c.Submit(context.Background(), tt.template, tt.stdin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+
+			st := wait(t, c, id)
+			if st.ID != id || st.Template != tt.template || st.State != tt.state || !strings.Contains(st.Error, tt.err) {
+				t.Errorf("got %+v, want %s with error %q", st, tt.state, tt.err)
+			}
+			if st.Finished.Before(st.Submitted) || len(st.Stages) != 1 {
+				t.Errorf("got %+v", st)
+			}
+			if out := logs(t, c, id, jobs.Stdout); out != tt.stdout {
+				t.Errorf("got %q, want %q", out, tt.stdout)
+			}
+		})
+	}
+
+	l, err := c.List(context.Background())
+	if err != nil || len(l) != len(ids) {
+		t.Fatalf("got %v, %v", l, err)
+	}
+	for i, st := range l {
+		if st.ID != ids[i] {
+			t.Errorf("job #%d is %s, want %s", i, st.ID, ids[i])
+		}
+	}
+
+}
+
+func TestControllerErrors(t *testing.T) {
+
+	c := &jobs.Controller{Templates: templates()}
+	ctx := context.Background()
+
+	if _, err := c.Submit(ctx, "missing", nil); errors.Cause(err) != jobs.ErrUnknownTemplate {
+		t.Errorf("Submit: got %v, want ErrUnknownTemplate", err)
+	}
+	if _, err := c.Status(ctx, "missing"); errors.Cause(err) != jobs.ErrNotFound {
+		t.Errorf("Status: got %v, want ErrNotFound", err)
+	}
+	if err := c.Cancel(ctx, "missing"); errors.Cause(err) != jobs.ErrNotFound {
+		t.Errorf("Cancel: got %v, want ErrNotFound", err)
+	}
+	if _, err := c.Logs(ctx, "missing", jobs.Stdout); errors.Cause(err) != jobs.ErrNotFound {
+		t.Errorf("Logs: got %v, want ErrNotFound", err)
+	}
+
+	id, err := c.Submit(ctx, "copy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Logs(ctx, id, "other"); err == nil {
+		t.Error("got an unknown log")
+	}
+
+}
+
+func TestControllerRunning(t *testing.T) {
+
+	if _, err := exec.LookPath("sh"); err != nil || runtime.GOOS == "windows" {
+		t.Skip("needs sh and sleep")
+	}
+
+	release := make(chan struct{})
+	tmpl := templates()
+	tmpl["block"] = piper.Func(func(io.Reader, io.Writer) error {
+		<-release
+		return nil
+	})
+	tmpl["follow"] = piper.Command("sh", "-c", "echo first; echo warning >&2; read x; echo second")
+	tmpl["sleep"] = piper.Command("sleep", "5")
+	s := &piper.Scheduler{Limit: 1}
+	c := &jobs.Controller{Templates: tmpl, Scheduler: s}
+	ctx := context.Background()
+
+	// queued behind a running job
+	blocked, err := c.Submit(ctx, "block", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for s.Running() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	queued, err := c.Submit(ctx, "copy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := c.Status(ctx, blocked); st.State != jobs.Running {
+		t.Errorf("blocking job is %s", st.State)
+	}
+	if st, _ := c.Status(ctx, queued); st.State != jobs.Queued {
+		t.Errorf("queued job is %s", st.State)
+	}
+
+	// a canceled queued job never runs
+	if err := c.Cancel(ctx, queued); err != nil {
+		t.Fatal(err)
+	}
+	if st := wait(t, c, queued); st.State != jobs.Canceled {
+		t.Errorf("canceled job is %s", st.State)
+	}
+	close(release)
+	if st := wait(t, c, blocked); st.State != jobs.Succeeded {
+		t.Errorf("blocking job is %s", st.State)
+	}
+
+	// following the logs of a running job
+	pr, pw := io.Pipe()
+	id, err := c.Submit(ctx, "follow", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := c.Logs(ctx, id, jobs.Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(stdout, buf); err != nil || string(buf) != "first\n" {
+		t.Fatalf("got %q, %v", buf, err)
+	}
+	pw.Write([]byte("\n"))
+	pw.Close()
+	if rest, err := io.ReadAll(stdout); err != nil || string(rest) != "second\n" {
+		t.Errorf("got %q, %v", rest, err)
+	}
+	wait(t, c, id)
+	if out := logs(t, c, id, jobs.Stderr); out != "warning\n" {
+		t.Errorf("got %q on stderr", out)
+	}
+
+	// canceling a running job kills it
+	id, err = c.Submit(ctx, "sleep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for st, _ := c.Status(ctx, id); st.State != jobs.Running; st, _ = c.Status(ctx, id) {
+		time.Sleep(time.Millisecond)
+	}
+	c.Cancel(ctx, id)
+	if st := wait(t, c, id); st.State != jobs.Canceled {
+		t.Errorf("canceled job is %s", st.State)
+	}
+
+}
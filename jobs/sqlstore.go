@@ -0,0 +1,115 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultTable is the table used by SQLStore if Table is empty
+const DefaultTable = "piper_jobs"
+
+// SQLStore keeps the records in a SQL database. The statements are written for SQLite (3.24 or
+// newer), open DB with the driver of your choice, e.g. sql.Open("sqlite", "jobs.db"). Call Init
+// once to create the table.
+type SQLStore struct {
+	DB *sql.DB
+	// Table is the name of the table holding the records, DefaultTable if empty
+	Table string
+}
+
+func (s *SQLStore) table() string {
+
+	if s.Table != "" {
+		return s.Table
+	}
+	return DefaultTable
+
+}
+
+// Init creates the table if it doesn't exist yet.
+func (s *SQLStore) Init(ctx context.Context) error {
+
+	_, err := s.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+s.table()+` (
+		id TEXT PRIMARY KEY,
+		submitted INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		chain TEXT NOT NULL,
+		stdout BLOB,
+		stderr BLOB
+	)`)
+	return errors.Wrap(err, "unable to create job table")
+
+}
+
+// Put inserts or replaces the record.
+func (s *SQLStore) Put(ctx context.Context, r *Record) error {
+
+	status, err := json.Marshal(r.Status)
+	if err != nil {
+		return err
+	}
+	chain, err := json.Marshal(r.Chain)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.ExecContext(ctx, `INSERT INTO `+s.table()+` (id, submitted, status, chain, stdout, stderr)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET status = excluded.status, chain = excluded.chain,
+			stdout = excluded.stdout, stderr = excluded.stderr`,
+		r.Status.ID, r.Status.Submitted.UnixNano(), string(status), string(chain), r.Stdout, r.Stderr)
+	return errors.Wrapf(err, "unable to store job %s", r.Status.ID)
+
+}
+
+// Get loads the record id.
+func (s *SQLStore) Get(ctx context.Context, id string) (*Record, error) {
+
+	var status, chain string
+	r := &Record{}
+	err := s.DB.QueryRowContext(ctx, `SELECT status, chain, stdout, stderr FROM `+s.table()+` WHERE id = ?`, id).
+		Scan(&status, &chain, &r.Stdout, &r.Stderr)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to load job %s", id)
+	}
+
+	if err := json.Unmarshal([]byte(status), &r.Status); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode job %s", id)
+	}
+	if err := json.Unmarshal([]byte(chain), &r.Chain); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode job %s", id)
+	}
+	return r, nil
+
+}
+
+// List returns the status of all records.
+func (s *SQLStore) List(ctx context.Context) ([]Status, error) {
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT status FROM `+s.table()+` ORDER BY submitted`)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list jobs")
+	}
+	defer rows.Close()
+
+	var l []Status
+	for rows.Next() {
+		var status string
+		if err := rows.Scan(&status); err != nil {
+			return nil, errors.Wrap(err, "unable to list jobs")
+		}
+		var st Status
+		if err := json.Unmarshal([]byte(status), &st); err != nil {
+			return nil, errors.Wrap(err, "unable to decode job")
+		}
+		l = append(l, st)
+	}
+	return l, errors.Wrap(rows.Err(), "unable to list jobs")
+
+}
@@ -0,0 +1,91 @@
+package jobs
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Store persists jobs so their state survives restarts of the embedding service. The Controller
+// saves a job when it is submitted and again with its outcome and logs once it finished.
+type Store interface {
+	// Put inserts or replaces the record with the ID r.Status.ID
+	Put(ctx context.Context, r *Record) error
+	// Get returns the record id or an error with the cause ErrNotFound
+	Get(ctx context.Context, id string) (*Record, error)
+	// List returns the status of all records, ordered by submission
+	List(ctx context.Context) ([]Status, error)
+}
+
+// Record is the persisted state of a job
+type Record struct {
+	Status Status `json:"status"`
+	// Chain describes the stages the job runs
+	Chain []Command `json:"chain"`
+	// Stdout and Stderr are the logs kept of a finished job
+	Stdout []byte `json:"stdout,omitempty"`
+	Stderr []byte `json:"stderr,omitempty"`
+}
+
+// Command describes a stage of a job, the Path of function stages is "func"
+type Command struct {
+	Path string   `json:"path"`
+	Args []string `json:"args,omitempty"`
+}
+
+// MemoryStore keeps the records in memory, it is the default store of a Controller
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]*Record
+}
+
+// Put stores a copy of r.
+func (m *MemoryStore) Put(ctx context.Context, r *Record) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.records == nil {
+		m.records = map[string]*Record{}
+	}
+	cp := *r
+	m.records[r.Status.ID] = &cp
+	return nil
+
+}
+
+// Get returns a copy of the record id.
+func (m *MemoryStore) Get(ctx context.Context, id string) (*Record, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.records[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *r
+	return &cp, nil
+
+}
+
+// List returns the status of all records.
+func (m *MemoryStore) List(ctx context.Context) ([]Status, error) {
+
+	m.mu.Lock()
+	l := make([]Status, 0, len(m.records))
+	for _, r := range m.records {
+		l = append(l, r.Status)
+	}
+	m.mu.Unlock()
+
+	sortStatus(l)
+	return l, nil
+
+}
+
+func sortStatus(l []Status) {
+
+	sort.Slice(l, func(a, b int) bool { return l[a].Submitted.Before(l[b].Submitted) })
+
+}
@@ -0,0 +1,146 @@
+package jobs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/noxer/piper/jobs"
+)
+
+func TestMemoryStore(t *testing.T) {
+
+	ctx := context.Background()
+	now := time.Now()
+	m := &jobs.MemoryStore{}
+
+	if _, err := m.Get(ctx, "a"); errors.Cause(err) != jobs.ErrNotFound {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+	for _, st := range []jobs.Status{
+		{ID: "b", Submitted: now.Add(time.Second), State: jobs.Queued},
+		{ID: "a", Submitted: now, State: jobs.Queued},
+		{ID: "b", Submitted: now.Add(time.Second), State: jobs.Succeeded},
+	} {
+		if err := m.Put(ctx, &jobs.Record{Status: st, Stdout: []byte("out")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := m.Get(ctx, "b")
+	if err != nil || r.Status.State != jobs.Succeeded || string(r.Stdout) != "out" {
+		t.Fatalf("got %+v, %v", r, err)
+	}
+	r.Status.State = jobs.Failed
+	if r, _ := m.Get(ctx, "b"); r.Status.State != jobs.Succeeded {
+		t.Error("changing a returned record changed the store")
+	}
+
+	l, err := m.List(ctx)
+	if err != nil || len(l) != 2 || l[0].ID != "a" || l[1].ID != "b" {
+		t.Errorf("got %+v, %v, want a and b", l, err)
+	}
+
+}
+
+// failingStore fails to store the outcome of jobs
+type failingStore struct {
+	jobs.MemoryStore
+}
+
+func (f *failingStore) Put(ctx context.Context, r *jobs.Record) error {
+
+	if r.Status.State != jobs.Queued {
+		return errors.New("disk full")
+	}
+	return f.MemoryStore.Put(ctx, r)
+
+}
+
+func TestControllerStore(t *testing.T) {
+
+	ctx := context.Background()
+	store := &jobs.MemoryStore{}
+	c := &jobs.Controller{Templates: templates(), Store: store}
+
+	id, err := c.Submit(ctx, "copy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait(t, c, id)
+
+	// a second controller finds the finished job in the store
+	c2 := &jobs.Controller{Templates: templates(), Store: store}
+	for _, c := range []*jobs.Controller{c, c2} {
+		st, err := c.Status(ctx, id)
+		if err != nil || st.State != jobs.Succeeded || len(st.Stages) != 1 {
+			t.Errorf("got %+v, %v", st, err)
+		}
+		if st, err := c.Wait(ctx, id); err != nil || st.State != jobs.Succeeded {
+			t.Errorf("Wait: got %+v, %v", st, err)
+		}
+		if l, err := c.List(ctx); err != nil || len(l) != 1 {
+			t.Errorf("List: got %+v, %v", l, err)
+		}
+	}
+	r, err := store.Get(ctx, id)
+	if err != nil || len(r.Chain) != 1 || r.Chain[0].Path != "func" {
+		t.Errorf("got %+v, %v", r, err)
+	}
+
+	// jobs which failed to be stored stay in memory
+	c = &jobs.Controller{Templates: templates(), Store: &failingStore{}}
+	id, err = c.Submit(ctx, "fail", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait(t, c, id)
+	if st, err := c.Status(ctx, id); err != nil || st.State != jobs.Failed {
+		t.Errorf("got %+v, %v", st, err)
+	}
+	if out := logs(t, c, id, jobs.Stdout); out != "partial" {
+		t.Errorf("got %q", out)
+	}
+
+}
+
+func TestRestore(t *testing.T) {
+
+	ctx := context.Background()
+	store := &jobs.MemoryStore{}
+	for _, st := range []jobs.Status{
+		{ID: "queued", State: jobs.Queued},
+		{ID: "running", State: jobs.Running},
+		{ID: "done", State: jobs.Succeeded},
+	} {
+		store.Put(ctx, &jobs.Record{Status: st})
+	}
+
+	c := &jobs.Controller{Templates: templates(), Store: store}
+	if err := c.Restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id    string
+		state jobs.State
+		err   string
+	}{
+		{"queued", jobs.Failed, "interrupted by a restart"},
+		{"running", jobs.Failed, "interrupted by a restart"},
+		{"done", jobs.Succeeded, ""},
+	}
+
+	for _, tt := range tests {
+		st, err := c.Status(ctx, tt.id)
+		if err != nil || st.State != tt.state || st.Error != tt.err {
+			t.Errorf("%s: got %+v, %v", tt.id, st, err)
+		}
+		if tt.state == jobs.Failed && st.Finished.IsZero() {
+			t.Errorf("%s: no finish time", tt.id)
+		}
+	}
+
+}
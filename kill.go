@@ -0,0 +1,31 @@
+package piper
+
+import (
+	"os"
+	"sync/atomic"
+)
+
+// WithProcessGroup starts all commands of the chain in a new process group on Unix, so Signal
+// and Kill also reach the processes spawned by the commands. Commands in their own process group
+// don't receive the signals of the terminal, e.g. SIGINT from Ctrl-C.
+func WithProcessGroup() Option {
+
+	return func(c *Chain) { c.group = true }
+
+}
+
+// Signal sends sig to every started process of the chain, or to its process group with
+// WithProcessGroup. On Windows only os.Kill is supported.
+func (c *Chain) Signal(sig os.Signal) error {
+
+	return c.signal(sig)
+
+}
+
+// Kill kills every started process of the chain, see Signal. Wait still has to be called.
+func (c *Chain) Kill() error {
+
+	atomic.StoreInt32(&c.killed, 1)
+	return c.Signal(os.Kill)
+
+}
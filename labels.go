@@ -0,0 +1,35 @@
+package piper
+
+import (
+	"context"
+	"runtime/pprof"
+	"strconv"
+)
+
+// labeled runs fn with pprof labels naming the chain, the stage and the role of the goroutine.
+// Goroutines started by fn, including the copy loops of os/exec, inherit the labels.
+// A stage of -1 marks goroutines which belong to the chain as a whole. The labels are added to
+// the ones of the context of the chain, see WithContext, or of the stage, see CommandContext, and
+// the labels of that context are restored once fn returned. Run a labeled caller with the context
+// it got from pprof.Do so its labels survive.
+func (c *Chain) labeled(stage int, role string, fn func()) {
+
+	labels := pprof.Labels("piper.chain", c.Name, "piper.stage", strconv.Itoa(stage), "piper.role", role)
+	pprof.Do(c.labelContext(stage), labels, func(context.Context) {
+		fn()
+	})
+
+}
+
+// labelContext returns the context whose labels the goroutines of stage start with.
+func (c *Chain) labelContext(stage int) context.Context {
+
+	switch {
+	case c.ctx != nil:
+		return c.ctx
+	case stage >= 0 && stage < len(c.stages) && c.stages[stage].ctx != nil:
+		return c.stages[stage].ctx
+	}
+	return context.Background()
+
+}
@@ -0,0 +1,75 @@
+package piper
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// StreamLines runs the chain and calls fn with every line of the output of the last stage as soon
+// as it was written, without its line ending. A last line without newline is delivered as well.
+// fn runs on the calling goroutine and the chain is held up while it runs. A line longer than
+// DefaultMaxRecord bytes kills the chain and fails with bufio.ErrTooLong. Once the output ended,
+// the chain is waited for and the error of Wait is returned.
+func (c *Chain) StreamLines(fn func(line string)) error {
+
+	if c.Stdout != nil {
+		return errors.New("piper: Stdout already set")
+	}
+
+	r, err := c.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = c.Start()
+	if err != nil {
+		r.Close()
+		return err
+	}
+
+	sc := NewScanner(r, bufio.ScanLines, 0)
+	for sc.Scan() {
+		fn(sc.Text())
+	}
+	serr := sc.Err()
+	if serr != nil {
+		c.Kill()
+		io.Copy(io.Discard, r)
+	}
+
+	err = c.Wait()
+	if serr != nil {
+		return errors.Wrap(serr, "unable to read the output")
+	}
+	return err
+
+}
+
+// OutputString runs the chain and returns the output of the last stage as a string without the
+// trailing newlines, like command substitution in a shell. The output is returned on failure as
+// well, see Output.
+func (c *Chain) OutputString() (string, error) {
+
+	o, err := c.Output()
+	return strings.TrimRight(string(o), "\r\n"), err
+
+}
+
+// OutputLines runs the chain and returns the lines of the output of the last stage without their
+// line endings. A trailing newline doesn't add an empty line, an empty output has no lines. The
+// output is returned on failure as well, see Output.
+func (c *Chain) OutputLines() ([]string, error) {
+
+	o, err := c.Output()
+	if len(o) == 0 {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(o), "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSuffix(l, "\r")
+	}
+	return lines, err
+
+}
@@ -0,0 +1,277 @@
+package piper
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// relay copies the data flowing from stage from to stage to through the parent process so it can
+// be observed. Links without observers connect the stages directly.
+type relay struct {
+	from int
+	src  *os.File
+	dst  *os.File
+	// in reads from src, it may inject faults, see WithChaos
+	in   io.Reader
+	m    *meter
+	done chan struct{}
+	// started is set once the copy runs, see start
+	started bool
+}
+
+func newRelay(from int, taps []io.Writer) (r *relay, upstream, downstream *os.File, err error) {
+
+	src, upstream, err := os.Pipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	downstream, dst, err := os.Pipe()
+	if err != nil {
+		src.Close()
+		upstream.Close()
+		return nil, nil, nil, err
+	}
+
+	r = &relay{
+		from: from,
+		src:  src,
+		dst:  dst,
+		in:   src,
+		m:    &meter{w: io.MultiWriter(append([]io.Writer{dst}, taps...)...)},
+		done: make(chan struct{}),
+	}
+	return r, upstream, downstream, nil
+
+}
+
+func (r *relay) start() {
+
+	r.started = true
+	go func() {
+		// a write error means the downstream stage stopped reading, closing src passes that on
+		io.Copy(r.m, r.in)
+		r.dst.Close()
+		r.src.Close()
+		close(r.done)
+	}()
+
+}
+
+// bytes returns the number of bytes passed on so far.
+func (r *relay) bytes() int64 {
+
+	return r.m.bytes()
+
+}
+
+// wait waits for the copy to finish. A relay which was never started closes its pipes instead.
+func (r *relay) wait() {
+
+	if !r.started {
+		r.src.Close()
+		r.dst.Close()
+		return
+	}
+	<-r.done
+
+}
+
+// meter counts the bytes written to w and remembers the last one and when the first and the
+// last byte were written, in Unix nanoseconds. end is the number of bytes up to the end of the
+// last complete record, see WithFraming.
+type meter struct {
+	w     io.Writer
+	n     int64
+	last  int32
+	first int64
+	now   int64
+	end   int64
+	framer
+}
+
+func (m *meter) Write(p []byte) (int, error) {
+
+	n, err := m.w.Write(p)
+	if n > 0 {
+		now := time.Now().UnixNano()
+		atomic.CompareAndSwapInt64(&m.first, 0, now)
+		atomic.StoreInt64(&m.now, now)
+		total := atomic.AddInt64(&m.n, int64(n))
+		atomic.StoreInt32(&m.last, int32(p[n-1]))
+		if end, _ := m.frame(p[:n]); end > 0 {
+			atomic.StoreInt64(&m.end, total-int64(n-end))
+		}
+	}
+	return n, err
+
+}
+
+func (m *meter) bytes() int64 {
+
+	return atomic.LoadInt64(&m.n)
+
+}
+
+// checkpoint reports how much data passed the meter, where the last complete record ended and
+// when the first and the last byte passed it, relative to start.
+func (m *meter) checkpoint(from int, start time.Time) LinkResult {
+
+	n := m.bytes()
+	end := m.framed()
+	lr := LinkResult{
+		From:     from,
+		Bytes:    n,
+		Framed:   end,
+		Complete: end == n,
+	}
+	if n > 0 {
+		lr.FirstByte = time.Unix(0, atomic.LoadInt64(&m.first)).Sub(start)
+		lr.LastByte = time.Unix(0, atomic.LoadInt64(&m.now)).Sub(start)
+	}
+	return lr
+
+}
+
+// tap wraps an observer of a stream. Failures of the observer must not disturb the chain,
+// so errors are swallowed and the observer is skipped from then on.
+type tap struct {
+	w   io.Writer
+	err error
+}
+
+func (t *tap) Write(p []byte) (int, error) {
+
+	if t.err == nil {
+		_, t.err = t.w.Write(p)
+	}
+	return len(p), nil
+
+}
+
+// tee returns a writer writing to w and all taps, w may be nil.
+func tee(w io.Writer, taps []io.Writer) io.Writer {
+
+	if len(taps) == 0 {
+		return w
+	}
+	if w == nil {
+		return io.MultiWriter(taps...)
+	}
+	return io.MultiWriter(append([]io.Writer{w}, taps...)...)
+
+}
+
+// tapStdout adds an observer for the stdout of stage i.
+func (c *Chain) tapStdout(i int, w io.Writer) {
+
+	if c.outTaps == nil {
+		c.outTaps = map[int][]io.Writer{}
+	}
+	c.outTaps[i] = append(c.outTaps[i], &tap{w: w})
+
+}
+
+// untapStdout removes the observer w added by tapStdout.
+func (c *Chain) untapStdout(i int, w io.Writer) {
+
+	taps := c.outTaps[i]
+	for j, t := range taps {
+		if t.(*tap).w == w {
+			c.outTaps[i] = append(taps[:j:j], taps[j+1:]...)
+			return
+		}
+	}
+
+}
+
+// tapOutput adds an observer for the stdout of the stage which is the last one when the chain
+// starts.
+func (c *Chain) tapOutput(w io.Writer) {
+
+	c.endTaps = append(c.endTaps, &tap{w: w})
+
+}
+
+// tapStderr adds an observer for the stderr of stage i.
+func (c *Chain) tapStderr(i int, w io.Writer) {
+
+	if c.errTaps == nil {
+		c.errTaps = map[int][]io.Writer{}
+	}
+	c.errTaps[i] = append(c.errTaps[i], &tap{w: w})
+
+}
+
+// TapStdin copies everything delivered to the stdin of stage i to w, e.g. a LimitedBuffer. This shows
+// exactly what a stage received. Failures of w don't affect the chain. It must be called before Start.
+func (c *Chain) TapStdin(i int, w io.Writer) *Chain {
+
+	if i > 0 {
+		c.tapStdout(i-1, w)
+		return c
+	}
+
+	c.inTaps = append(c.inTaps, &tap{w: w})
+	return c
+
+}
+
+// TapAfter copies everything stage i writes to its stdout to w, e.g. to log or checksum the data
+// flowing between two stages. The data still reaches the next stage unchanged and failures of w
+// don't affect the chain. It must be called before Start.
+func (c *Chain) TapAfter(i int, w io.Writer) *Chain {
+
+	c.tapStdout(i, w)
+	return c
+
+}
+
+// Tee copies the output of the last added stage to w, like "cmd | tee file | ..." in a shell,
+// see TapAfter.
+func (c *Chain) Tee(w io.Writer) *Chain {
+
+	if len(c.stages) == 0 {
+		c.fail(errNoStage)
+		return c
+	}
+	return c.TapAfter(len(c.stages)-1, w)
+
+}
+
+// Link sets how the last added stage is connected to the next one: PipeLink, the default,
+// SocketLink, PacketLink, KeepOpenLink, see Drain and Attach, or PtyLink. Sockets are only
+// supported on Unix. Links other than pipes can't be combined with taps on the link, e.g.
+// TapAfter or Capture. A function stage can read from its end of a socket by asserting w to an
+// io.Reader. It must be called before Start.
+func (c *Chain) Link(kind LinkKind) *Chain {
+
+	c.last().link = kind
+	return c
+
+}
+
+// Streams selects the output streams of a stage feeding the next stage, see Chain.PipeStreams
+type Streams int
+
+// Streams feeding the next stage
+const (
+	// StdoutStream pipes stdout, the default
+	StdoutStream Streams = iota
+	// StderrStream pipes stderr and discards stdout
+	StderrStream
+	// BothStreams pipes stdout and stderr, like "|&" in bash
+	BothStreams
+)
+
+// PipeStreams selects which streams of the last added stage feed the next one, e.g. BothStreams
+// for "cmd |& next". The piped stderr is still copied to the stderr taps and captures of the
+// stage, but not to Allerr or StderrFor. Only commands have a stderr and the last stage has no
+// next one. It must be called before Start.
+func (c *Chain) PipeStreams(s Streams) *Chain {
+
+	c.last().streams = s
+	return c
+
+}
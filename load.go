@@ -0,0 +1,183 @@
+package piper
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Definition describes a chain declaratively, e.g. in a configuration file, see Load. It carries
+// json and yaml tags, so it can be embedded in the configuration of an application and passed to
+// FromDefinition.
+type Definition struct {
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Env and Dir are the environment and the working directory of the chain, see Chain.Env
+	Env []string `json:"env,omitempty" yaml:"env,omitempty"`
+	Dir string   `json:"dir,omitempty" yaml:"dir,omitempty"`
+	// Timeout kills the chain once it expired, see WithTimeout
+	Timeout Duration          `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	Stages  []StageDefinition `json:"stages" yaml:"stages"`
+}
+
+// StageDefinition describes a stage of a Definition. It runs either the command Command with
+// Args or the script Shell with the platform shell, see Shell.
+type StageDefinition struct {
+	Command string   `json:"command,omitempty" yaml:"command,omitempty"`
+	Args    []string `json:"args,omitempty" yaml:"args,omitempty"`
+	Shell   string   `json:"shell,omitempty" yaml:"shell,omitempty"`
+	// Env is added to the environment of the command, Dir is its working directory, see StageEnv
+	// and StageDir
+	Env []string `json:"env,omitempty" yaml:"env,omitempty"`
+	Dir string   `json:"dir,omitempty" yaml:"dir,omitempty"`
+	// Stderr routes the stderr of the command: "" or "inherit" writes it to the stderr of the
+	// chain, "discard" drops it, "pipe" feeds it to the next stage instead of stdout and "both"
+	// feeds both streams to the next stage, see PipeStreams
+	Stderr string `json:"stderr,omitempty" yaml:"stderr,omitempty"`
+	// IgnoreFailure and Not mark the stage like the methods of Chain
+	IgnoreFailure bool `json:"ignoreFailure,omitempty" yaml:"ignoreFailure,omitempty"`
+	Not           bool `json:"not,omitempty" yaml:"not,omitempty"`
+}
+
+// Duration is a time.Duration written like "1m30s" in a Definition
+type Duration time.Duration
+
+// UnmarshalText parses the duration with time.ParseDuration.
+func (d *Duration) UnmarshalText(text []byte) error {
+
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+
+}
+
+// MarshalText formats the duration like time.Duration.String.
+func (d Duration) MarshalText() ([]byte, error) {
+
+	return []byte(time.Duration(d).String()), nil
+
+}
+
+// Load builds a chain from the Definition read from r, encoded as JSON or YAML. A document
+// starting with "{" is JSON, everything else is decoded as a single YAML document. Unknown fields
+// are rejected, so a misspelled option doesn't go unnoticed.
+func Load(r io.Reader) (*Chain, error) {
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read the pipeline definition")
+	}
+
+	var def Definition
+	if t := bytes.TrimSpace(data); len(t) > 0 && t[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&def)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&def)
+		if err == nil && dec.Decode(new(yaml.Node)) != io.EOF {
+			err = errors.New("more than one document")
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decode the pipeline definition")
+	}
+	return FromDefinition(&def)
+
+}
+
+// Parse builds a chain from the JSON or YAML encoded Definition in data, see Load.
+func Parse(data []byte) (*Chain, error) {
+
+	return Load(bytes.NewReader(data))
+
+}
+
+// FromDefinition builds a chain from def.
+func FromDefinition(def *Definition) (*Chain, error) {
+
+	if len(def.Stages) == 0 {
+		return nil, errors.New("piper: the pipeline definition has no stages")
+	}
+
+	var opts []Option
+	if def.Timeout > 0 {
+		opts = append(opts, WithTimeout(time.Duration(def.Timeout)))
+	}
+	c := New(opts...)
+	c.Name = def.Name
+	c.Env = def.Env
+	c.Dir = def.Dir
+
+	var discard []bool
+	for i, sd := range def.Stages {
+
+		switch {
+		case sd.Command != "" && sd.Shell != "":
+			return nil, errors.Errorf("piper: stage #%d has both a command and a shell script", i)
+		case sd.Command != "":
+			c.Command(sd.Command, sd.Args...)
+		case sd.Shell != "" && len(sd.Args) == 0:
+			c.Shell(sd.Shell)
+		case sd.Shell != "":
+			return nil, errors.Errorf("piper: stage #%d passes arguments to a shell script", i)
+		default:
+			return nil, errors.Errorf("piper: stage #%d has no command", i)
+		}
+
+		if len(sd.Env) > 0 {
+			c.Configure(StageEnv(sd.Env...))
+		}
+		if sd.Dir != "" {
+			c.Configure(StageDir(sd.Dir))
+		}
+		if sd.IgnoreFailure {
+			c.IgnoreFailure()
+		}
+		if sd.Not {
+			c.Not()
+		}
+
+		dropped := false
+		switch sd.Stderr {
+		case "", "inherit":
+		case "discard":
+			dropped = true
+		case "pipe", "both":
+			if i == len(def.Stages)-1 {
+				return nil, errors.Errorf("piper: stage #%d can't pipe its stderr, it is the last one", i)
+			}
+			if sd.Stderr == "pipe" {
+				c.PipeStreams(StderrStream)
+			} else {
+				c.PipeStreams(BothStreams)
+			}
+		default:
+			return nil, errors.Errorf("piper: stage #%d has the unknown stderr routing %q", i, sd.Stderr)
+		}
+		discard = append(discard, dropped)
+
+	}
+
+	for _, d := range discard {
+		if d {
+			c.StderrFor = func(i int, path string) io.Writer {
+				if discard[i] {
+					return io.Discard
+				}
+				return nil
+			}
+			break
+		}
+	}
+	return c, nil
+
+}
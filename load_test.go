@@ -0,0 +1,132 @@
+package piper_test
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/noxer/piper"
+)
+
+func TestParse(t *testing.T) {
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("needs sh")
+	}
+
+	tests := []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{"json", `{"name": "demo", "stages": [
+			{"command": "printf", "args": ["%s\n", "a # b", "it's", "true"]},
+			{"shell": "tr a-z A-Z |\n  cat\n", "ignoreFailure": true}
+		]}`, "A # B\nIT'S\nTRUE\n"},
+		{"comments and flow sequence", `---
+# a comment
+name: demo
+stages:
+  - command: printf
+    args: ["%s\n", "a # b"] # another comment
+  - shell: tr a-z A-Z
+`, "A # B\n"},
+		{"quoting", `name: 'demo'
+stages:
+- command: printf
+  args:
+  - "%s\n"
+  - 'it''s'
+  - "tab\there"
+  - true
+  - 1e3
+  - -n
+`, "it's\ntab\there\ntrue\n1e3\n-n\n"},
+		{"literal block", `name: demo
+stages:
+  - shell: |
+      echo one
+      echo two |
+        tr a-z A-Z
+`, "one\nTWO\n"},
+		{"folded block", `name: demo
+stages:
+  - command: printf
+    args:
+      - "%s|"
+      - >-
+        folded
+        line
+
+        kept
+`, "folded line\nkept|"},
+		{"anchors", `name: demo
+env: &env [GREETING=hi]
+stages:
+  - shell: 'echo "$GREETING $WHO"'
+    env: [WHO=you]
+    dir: &dir .
+  - command: cat
+    dir: *dir
+`, "hi you\n"},
+		{"timeout and stderr", `name: demo
+timeout: 1m30s
+stages:
+  - shell: echo out; echo err >&2
+    stderr: discard
+  - command: cat
+`, "out\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := piper.Parse([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Name != "demo" {
+				t.Errorf("name %q", c.Name)
+			}
+			o, err := c.Output()
+			if err != nil || string(o) != tt.want {
+				t.Errorf("got %q, %v, want %q", o, err, tt.want)
+			}
+		})
+	}
+
+}
+
+func TestParseErrors(t *testing.T) {
+
+	tests := []struct {
+		name string
+		doc  string
+		err  string
+	}{
+		{"empty", "", "EOF"},
+		{"no stages", "name: demo\n", "no stages"},
+		{"unknown field", "stages:\n  - command: ls\n    bogus: 1\n", "bogus"},
+		{"unknown json field", `{"stages": [{"command": "ls", "bogus": 1}]}`, "bogus"},
+		{"tab indentation", "stages:\n\t- command: ls\n", "line 2"},
+		{"bad indentation", "stages:\n  - command: ls\n     args: [a]\n", "line 3"},
+		{"unterminated quote", "name: \"demo\nstages:\n  - command: ls\n", "line"},
+		{"duplicate key", "name: a\nname: b\nstages:\n  - command: ls\n", "already defined"},
+		{"unknown alias", "stages:\n  - command: *ls\n", "unknown anchor"},
+		{"two documents", "stages:\n  - command: ls\n---\nstages:\n  - command: cat\n", "more than one document"},
+		{"bad duration", "timeout: soon\nstages:\n  - command: ls\n", "soon"},
+		{"args of a script", "stages:\n  - shell: ls\n    args: [a]\n", "passes arguments"},
+		{"no command", "stages:\n  - dir: /tmp\n", "has no command"},
+		{"piped last stderr", "stages:\n  - command: ls\n    stderr: pipe\n", "last one"},
+		{"unknown stderr", "stages:\n  - command: ls\n    stderr: nowhere\n", "nowhere"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := piper.Parse([]byte(tt.doc))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+
+}
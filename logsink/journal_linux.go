@@ -0,0 +1,74 @@
+package logsink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Journal sends stage output to the systemd journal using its native protocol
+type Journal struct {
+	opts Options
+	conn *net.UnixConn
+}
+
+// DialJournal connects to the local systemd journal.
+func DialJournal(opts Options) (*Journal, error) {
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: "/run/systemd/journal/socket", Net: "unixgram"})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to connect to the journal")
+	}
+	return &Journal{opts: opts, conn: conn}, nil
+
+}
+
+// Stderr returns the writer for stage i, it can be used as piper.Chain.StderrFor.
+// Besides SYSLOG_IDENTIFIER every message carries the PIPER_STAGE and PIPER_COMMAND fields.
+func (j *Journal) Stderr(i int, path string) io.Writer {
+
+	ident := j.opts.ident(i, path)
+	return &lineWriter{emit: func(line []byte) error {
+
+		var b bytes.Buffer
+		field(&b, "PRIORITY", []byte(strconv.Itoa(int(j.opts.level(line)))))
+		field(&b, "SYSLOG_IDENTIFIER", []byte(ident))
+		field(&b, "PIPER_STAGE", []byte(strconv.Itoa(i)))
+		field(&b, "PIPER_COMMAND", []byte(path))
+		field(&b, "MESSAGE", line)
+
+		_, err := j.conn.Write(b.Bytes())
+		return err
+
+	}}
+
+}
+
+// field encodes a journal field, values containing newlines use the binary form.
+func field(b *bytes.Buffer, name string, value []byte) {
+
+	b.WriteString(name)
+	if bytes.IndexByte(value, '\n') < 0 {
+		b.WriteByte('=')
+		b.Write(value)
+		b.WriteByte('\n')
+		return
+	}
+
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.Write(value)
+	b.WriteByte('\n')
+
+}
+
+// Close closes the connection to the journal.
+func (j *Journal) Close() error {
+
+	return j.conn.Close()
+
+}
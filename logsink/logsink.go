@@ -0,0 +1,117 @@
+// Package logsink forwards the stderr of chain stages to syslog or the systemd journal.
+// Use the Stderr method of a sink as piper.Chain.StderrFor, every stage then logs with its own
+// identifier and every line of output becomes one log message.
+package logsink
+
+import (
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// Priority is the severity of a log message as defined by syslog
+type Priority int
+
+// Severities in decreasing order of importance
+const (
+	Emerg Priority = iota
+	Alert
+	Crit
+	Err
+	Warning
+	Notice
+	Info
+	Debug
+)
+
+// Options configures a sink
+type Options struct {
+	// Tag is the identifier prefix, the stage number and command name are appended
+	Tag string
+	// Level maps a line of output to its priority, DefaultLevel if nil
+	Level func(line []byte) Priority
+}
+
+// DefaultLevel logs lines mentioning errors or warnings with the respective priority and
+// everything else as Info.
+func DefaultLevel(line []byte) Priority {
+
+	l := bytes.ToLower(line)
+	switch {
+	case bytes.Contains(l, []byte("fatal")), bytes.Contains(l, []byte("panic")):
+		return Crit
+	case bytes.Contains(l, []byte("error")):
+		return Err
+	case bytes.Contains(l, []byte("warn")):
+		return Warning
+	}
+	return Info
+
+}
+
+func (o Options) level(line []byte) Priority {
+
+	if o.Level != nil {
+		return o.Level(line)
+	}
+	return DefaultLevel(line)
+
+}
+
+// ident builds the identifier of stage i running path.
+func (o Options) ident(i int, path string) string {
+
+	tag := o.Tag
+	if tag == "" {
+		tag = "piper"
+	}
+	return tag + "." + strconv.Itoa(i) + "." + filepath.Base(path)
+
+}
+
+// lineWriter splits the written data into lines and emits each of them.
+// Close emits a trailing partial line.
+type lineWriter struct {
+	mu   sync.Mutex
+	buf  []byte
+	emit func(line []byte) error
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		err := w.emit(bytes.TrimSuffix(w.buf[:i], []byte("\r")))
+		w.buf = w.buf[i+1:]
+		if err != nil {
+			return len(p), err
+		}
+
+	}
+
+	return len(p), nil
+
+}
+
+func (w *lineWriter) Close() error {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.emit(w.buf)
+	w.buf = nil
+	return err
+
+}
@@ -0,0 +1,158 @@
+package logsink_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noxer/piper"
+	"github.com/noxer/piper/logsink"
+)
+
+func TestDefaultLevel(t *testing.T) {
+
+	tests := []struct {
+		line string
+		want logsink.Priority
+	}{
+		{"starting", logsink.Info},
+		{"", logsink.Info},
+		{"WARNING: disk almost full", logsink.Warning},
+		{"error: no such file", logsink.Err},
+		{"Fatal error", logsink.Crit},
+		{"panic: nil map", logsink.Crit},
+	}
+
+	for _, tt := range tests {
+		if got := logsink.DefaultLevel([]byte(tt.line)); got != tt.want {
+			t.Errorf("DefaultLevel(%q) = %d, want %d", tt.line, got, tt.want)
+		}
+	}
+
+}
+
+// listen returns a UDP socket receiving syslog messages
+func listen(t *testing.T) *net.UDPConn {
+
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+
+}
+
+// receive returns the next n messages of conn
+func receive(t *testing.T, conn *net.UDPConn, n int) []string {
+
+	t.Helper()
+
+	var msgs []string
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(msgs) < n {
+		m, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("received %q: %v", msgs, err)
+		}
+		msgs = append(msgs, string(buf[:m]))
+	}
+	return msgs
+
+}
+
+func TestSyslog(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		facility int
+		opts     logsink.Options
+		writes   []string
+		want     []string
+	}{
+		{"lines", 0, logsink.Options{}, []string{"one\ntwo\r\n"}, []string{
+			"<14> piper.2.tool[%d]: one\n",
+			"<14> piper.2.tool[%d]: two\n",
+		}},
+		{"split writes", 0, logsink.Options{}, []string{"an er", "ror\nlast"}, []string{
+			"<11> piper.2.tool[%d]: an error\n",
+			"<14> piper.2.tool[%d]: last\n",
+		}},
+		{"facility and tag", 16, logsink.Options{Tag: "job"}, []string{"warn\n"}, []string{
+			"<132> job.2.tool[%d]: warn\n",
+		}},
+		{"level", 0, logsink.Options{Level: func([]byte) logsink.Priority { return logsink.Debug }}, []string{"error\n"}, []string{
+			"<15> piper.2.tool[%d]: error\n",
+		}},
+	}
+
+	stamp := regexp.MustCompile(`^(<\d+>)[A-Z][a-z]{2} [ 0-9]\d \d\d:\d\d:\d\d `)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := listen(t)
+			s, err := logsink.DialSyslog("udp", conn.LocalAddr().String(), tt.facility, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			w := s.Stderr(2, "/usr/bin/tool")
+			for _, data := range tt.writes {
+				if _, err := io.WriteString(w, data); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.(io.Closer).Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// the timestamp is replaced to compare the messages
+			for i, msg := range receive(t, conn, len(tt.want)) {
+				got := stamp.ReplaceAllString(msg, "$1 ")
+				if want := fmt.Sprintf(tt.want[i], os.Getpid()); got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			}
+		})
+	}
+
+}
+
+func TestSyslogChain(t *testing.T) {
+
+	if _, err := exec.LookPath("sh"); err != nil || runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+
+	conn := listen(t)
+	s, err := logsink.DialSyslog("udp", conn.LocalAddr().String(), 0, logsink.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	c := piper.Command("sh", "-c", "echo out; echo first >&2").Command("sh", "-c", "cat; printf 'error: partial' >&2")
+	c.StderrFor = s.Stderr
+	out, err := c.Output()
+	if err != nil || string(out) != "out\n" {
+		t.Fatalf("got %q, %v", out, err)
+	}
+
+	msgs := strings.Join(receive(t, conn, 2), "")
+	for _, want := range []string{"<14>", "piper.0.sh[", "]: first\n", "<11>", "piper.1.sh[", "]: error: partial\n"} {
+		if !strings.Contains(msgs, want) {
+			t.Errorf("%q is missing %q", msgs, want)
+		}
+	}
+
+}
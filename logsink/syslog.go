@@ -0,0 +1,79 @@
+package logsink
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FacilityUser is the default syslog facility
+const FacilityUser = 1
+
+// Syslog sends stage output to a syslog daemon
+type Syslog struct {
+	opts     Options
+	facility int
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// DialSyslog connects to the syslog daemon at raddr over network, an empty network connects to
+// the local daemon. A facility of zero uses FacilityUser.
+func DialSyslog(network, raddr string, facility int, opts Options) (*Syslog, error) {
+
+	if facility == 0 {
+		facility = FacilityUser
+	}
+
+	var conn net.Conn
+	var err error
+	if network == "" {
+		for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+			conn, err = net.Dial("unixgram", path)
+			if err == nil {
+				break
+			}
+		}
+	} else {
+		conn, err = net.Dial(network, raddr)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to connect to syslog")
+	}
+
+	return &Syslog{opts: opts, facility: facility, conn: conn}, nil
+
+}
+
+// Stderr returns the writer for stage i, it can be used as piper.Chain.StderrFor.
+func (s *Syslog) Stderr(i int, path string) io.Writer {
+
+	ident := s.opts.ident(i, path)
+	return &lineWriter{emit: func(line []byte) error {
+		return s.send(s.opts.level(line), ident, line)
+	}}
+
+}
+
+func (s *Syslog) send(p Priority, ident string, line []byte) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := fmt.Fprintf(s.conn, "<%d>%s %s[%d]: %s\n", s.facility*8+int(p), time.Now().Format(time.Stamp), ident, os.Getpid(), line)
+	return err
+
+}
+
+// Close closes the connection to the daemon.
+func (s *Syslog) Close() error {
+
+	return s.conn.Close()
+
+}
@@ -0,0 +1,211 @@
+package piper
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// PathCache, if set, resolves the names of the commands created by Command and CommandContext
+// instead of exec.LookPath. Services creating many chains set it to a LookPathCache.
+var PathCache *LookPathCache
+
+// DefaultRevalidate is the revalidation interval of a LookPathCache if Revalidate is zero
+const DefaultRevalidate = time.Second
+
+// LookPathCache memoizes exec.LookPath. Entries are dropped once PATH changes or a directory
+// of PATH changes, and an entry is looked up again once its binary changes. The file system is
+// checked at most once per Revalidate interval. It is safe for concurrent use.
+type LookPathCache struct {
+	// Revalidate is the interval the cached entries are checked against the file system,
+	// DefaultRevalidate if zero, never if negative
+	Revalidate time.Duration
+
+	mu          sync.Mutex
+	env         string
+	dirs        map[string]time.Time
+	dirsChecked time.Time
+	entries     map[string]*pathEntry
+	stats       LookPathStats
+}
+
+// LookPathStats counts the lookups of a LookPathCache
+type LookPathStats struct {
+	Hits   int64
+	Misses int64
+	// Invalidations counts how often all entries were dropped
+	Invalidations int64
+	Entries       int
+}
+
+type pathEntry struct {
+	path    string
+	err     error
+	mtime   time.Time
+	checked time.Time
+}
+
+// LookPath works like exec.LookPath and caches the result.
+func (l *LookPathCache) LookPath(file string) (string, error) {
+
+	env := os.Getenv("PATH")
+	now := time.Now()
+
+	l.mu.Lock()
+	if env != l.env || l.entries == nil {
+		l.flush(env, now)
+	} else if l.due(l.dirsChecked, now) && l.dirsChanged(now) {
+		l.flush(env, now)
+	}
+	if e, ok := l.entries[file]; ok && !l.stale(e, now) {
+		l.stats.Hits++
+		l.mu.Unlock()
+		return e.path, e.err
+	}
+	l.stats.Misses++
+	l.mu.Unlock()
+
+	path, err := exec.LookPath(file)
+	e := &pathEntry{path: path, err: err, checked: now}
+	if err == nil {
+		e.mtime = mtime(path)
+	}
+
+	l.mu.Lock()
+	if l.env == env {
+		l.entries[file] = e
+	}
+	l.mu.Unlock()
+
+	return path, err
+
+}
+
+// Stats returns the counters of the cache.
+func (l *LookPathCache) Stats() LookPathStats {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	st := l.stats
+	st.Entries = len(l.entries)
+	return st
+
+}
+
+// Reset drops all entries.
+func (l *LookPathCache) Reset() {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = nil
+
+}
+
+// flush drops all entries and records the state of the directories of env, l.mu must be held.
+func (l *LookPathCache) flush(env string, now time.Time) {
+
+	if l.entries != nil {
+		l.stats.Invalidations++
+	}
+	l.env = env
+	l.entries = map[string]*pathEntry{}
+	l.dirs = map[string]time.Time{}
+	for _, dir := range filepath.SplitList(env) {
+		l.dirs[dir] = mtime(dir)
+	}
+	l.dirsChecked = now
+
+}
+
+// dirsChanged reports whether a directory of PATH changed, l.mu must be held.
+func (l *LookPathCache) dirsChanged(now time.Time) bool {
+
+	l.dirsChecked = now
+	for dir, t := range l.dirs {
+		if !mtime(dir).Equal(t) {
+			return true
+		}
+	}
+	return false
+
+}
+
+// stale reports whether the binary of e changed, l.mu must be held.
+func (l *LookPathCache) stale(e *pathEntry, now time.Time) bool {
+
+	if e.err != nil || !l.due(e.checked, now) {
+		return false
+	}
+	e.checked = now
+	return !mtime(e.path).Equal(e.mtime)
+
+}
+
+func (l *LookPathCache) due(checked, now time.Time) bool {
+
+	d := l.Revalidate
+	if d == 0 {
+		d = DefaultRevalidate
+	}
+	return d > 0 && now.Sub(checked) >= d
+
+}
+
+// mtime returns the modification time of path or the zero time if it doesn't exist.
+func mtime(path string) time.Time {
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+
+}
+
+// command creates the command of a stage like exec.Command, resolving name through PathCache if set.
+func command(name string, arg ...string) *exec.Cmd {
+
+	if PathCache == nil || filepath.Base(name) != name {
+		return exec.Command(name, arg...)
+	}
+
+	path, err := PathCache.LookPath(name)
+	if path == "" {
+		return &exec.Cmd{Path: name, Args: append([]string{name}, arg...), Err: err}
+	}
+	return resolved(exec.Command(path, arg...), name, err)
+
+}
+
+// commandContext works like command for exec.CommandContext.
+func commandContext(ctx context.Context, name string, arg ...string) *exec.Cmd {
+
+	if PathCache == nil || filepath.Base(name) != name {
+		return exec.CommandContext(ctx, name, arg...)
+	}
+
+	path, err := PathCache.LookPath(name)
+	if path == "" {
+		// the command never starts, so it needs no context
+		return &exec.Cmd{Path: name, Args: append([]string{name}, arg...), Err: err}
+	}
+	return resolved(exec.CommandContext(ctx, path, arg...), name, err)
+
+}
+
+// resolved restores the name in the arguments of cmd created from the resolved path. A lookup
+// error with a path, e.g. exec.ErrDot, is reported like exec.Command does.
+func resolved(cmd *exec.Cmd, name string, err error) *exec.Cmd {
+
+	cmd.Args[0] = name
+	if err != nil {
+		cmd.Err = err
+	}
+	return cmd
+
+}
@@ -0,0 +1,99 @@
+package piper
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// MapResult holds the outcome of running a chain against one input of Map
+type MapResult struct {
+	Index  int
+	Name   string
+	Output []byte
+	Err    error
+	Result *Result
+}
+
+// Map runs a clone of template once for every input and returns the results in input order.
+// At most parallelism clones run at the same time, a value below 1 runs one clone at a time.
+// The Stdin and Stdout of template are replaced for each run, Stderr and Allerr are shared.
+func Map(inputs []io.Reader, template *Chain, parallelism int) []MapResult {
+
+	return mapChain(len(inputs), template, parallelism, func(i int) (string, io.ReadCloser, error) {
+		return "", io.NopCloser(inputs[i]), nil
+	})
+
+}
+
+// MapFiles works like Map but reads the input of every run from the file at the given path.
+func MapFiles(paths []string, template *Chain, parallelism int) []MapResult {
+
+	return mapChain(len(paths), template, parallelism, func(i int) (string, io.ReadCloser, error) {
+		f, err := os.Open(paths[i])
+		if err != nil {
+			return paths[i], nil, errors.Wrapf(err, "unable to open input #%d (%s)", i, paths[i])
+		}
+		return paths[i], f, nil
+	})
+
+}
+
+func mapChain(n int, template *Chain, parallelism int, open func(i int) (string, io.ReadCloser, error)) []MapResult {
+
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	results := make([]MapResult, n)
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism && w < n; w++ {
+
+		wg.Add(1)
+		template.labeled(-1, "map", func() {
+			go func() {
+				defer wg.Done()
+				for i := range indexes {
+					results[i] = mapOne(i, template, open)
+				}
+			}()
+		})
+
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+
+}
+
+func mapOne(i int, template *Chain, open func(i int) (string, io.ReadCloser, error)) MapResult {
+
+	name, in, err := open(i)
+	if err != nil {
+		return MapResult{Index: i, Name: name, Err: err}
+	}
+	defer in.Close()
+
+	c := template.Clone()
+	c.Stdin = in
+	c.Stdout = nil
+
+	out, err := c.Output()
+	return MapResult{
+		Index:  i,
+		Name:   name,
+		Output: out,
+		Err:    err,
+		Result: c.Result(),
+	}
+
+}
@@ -0,0 +1,341 @@
+package nats_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/noxer/piper"
+	"github.com/noxer/piper/nats"
+)
+
+// fakeServer speaks enough of the NATS protocol to deliver published messages to the subscriptions
+// of the same connection. greet replaces the INFO line, ping makes the server check the client
+// before it answers the PING of the handshake.
+type fakeServer struct {
+	ln    net.Listener
+	greet string
+	ping  bool
+	fail  string
+
+	mu      sync.Mutex
+	connect string
+}
+
+func newServer(t *testing.T, setup func(*fakeServer)) *fakeServer {
+
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{ln: ln, greet: "INFO {\"server_id\":\"fake\"}"}
+	if setup != nil {
+		setup(s)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+
+}
+
+func (s *fakeServer) addr() string {
+
+	return s.ln.Addr().String()
+
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "%s\r\n", s.greet)
+
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "CONNECT ") {
+		return
+	}
+	s.mu.Lock()
+	s.connect = line
+	s.mu.Unlock()
+	if line, _ := r.ReadString('\n'); line != "PING\r\n" {
+		return
+	}
+	if s.ping {
+		io.WriteString(conn, "PING\r\n")
+		if pong, _ := r.ReadString('\n'); pong != "PONG\r\n" {
+			return
+		}
+	}
+	if s.fail != "" {
+		fmt.Fprintf(conn, "-ERR '%s'\r\n", s.fail)
+		return
+	}
+	io.WriteString(conn, "PONG\r\n")
+
+	subs := map[string]string{}
+	for {
+
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		f := strings.Fields(line)
+		switch {
+		case len(f) == 3 && f[0] == "SUB":
+			subs[f[2]] = f[1]
+		case len(f) == 4 && f[0] == "SUB":
+			subs[f[3]] = f[1]
+		case len(f) == 2 && f[0] == "UNSUB":
+			delete(subs, f[1])
+		case len(f) == 3 && f[0] == "PUB":
+			n, _ := strconv.Atoi(f[2])
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			for sid, subject := range subs {
+				if subject == f[1] {
+					fmt.Fprintf(conn, "MSG %s %s %d\r\n%s", subject, sid, n, payload)
+				}
+			}
+		}
+
+	}
+
+}
+
+func TestDial(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		setup   func(*fakeServer)
+		addr    string
+		connect string
+		ok      bool
+	}{
+		{"plain", nil, "%s", `"name":"piper"`, true},
+		{"user and password", nil, "nats://me:secret@%s", `"pass":"secret"`, true},
+		{"token", nil, "nats://token@%s", `"auth_token":"token"`, true},
+		{"ping of the server", func(s *fakeServer) { s.ping = true }, "%s", "", true},
+		{"refused", func(s *fakeServer) { s.fail = "Authorization Violation" }, "%s", "", false},
+		{"not NATS", func(s *fakeServer) { s.greet = "HTTP/1.1 400 Bad Request" }, "%s", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newServer(t, tt.setup)
+			conn, err := dialTimeout(t, fmt.Sprintf(tt.addr, s.addr()))
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v, ok %v", err, tt.ok)
+			}
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if !strings.Contains(s.connect, tt.connect) {
+				t.Errorf("sent %q, want %s", s.connect, tt.connect)
+			}
+		})
+	}
+
+}
+
+// dialTimeout fails the test if Dial hangs
+func dialTimeout(t *testing.T, addr string) (*nats.Conn, error) {
+
+	t.Helper()
+
+	type result struct {
+		conn *nats.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := nats.Dial(addr)
+		done <- result{conn, err}
+	}()
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Dial hangs")
+		return nil, nil
+	}
+
+}
+
+// limited ends a source after n records
+type limited struct {
+	src piper.RecordSource
+	n   int
+}
+
+func (l *limited) Next(ctx context.Context) ([]byte, error) {
+
+	if l.n == 0 {
+		return nil, io.EOF
+	}
+	l.n--
+	return l.src.Next(ctx)
+
+}
+
+func TestPublishSubscribe(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		records []string
+		want    string
+		ok      bool
+	}{
+		{"lines", []string{"a", "b c", ""}, "a\nb c\n\n", true},
+		{"binary", []string{"\x00\r\xff"}, "\x00\r\xff\n", true},
+		{"embedded newline", []string{"a", "b\nc"}, "a\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newServer(t, nil)
+			conn, err := dialTimeout(t, s.addr())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			sub, err := conn.Subscribe("records", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			pub := conn.Publisher("records")
+			for _, rec := range tt.records {
+				if err := pub.Publish(context.Background(), []byte(rec)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			out, err := piper.Subscribe(ctx, &limited{sub, len(tt.records)}).Output()
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v, ok %v", err, tt.ok)
+			}
+			if string(out) != tt.want {
+				t.Errorf("got %q, want %q", out, tt.want)
+			}
+		})
+	}
+
+}
+
+func TestPublishChain(t *testing.T) {
+
+	s := newServer(t, nil)
+	conn, err := dialTimeout(t, s.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("lines", "workers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = piper.Func(func(_ io.Reader, w io.Writer) error {
+		_, err := io.WriteString(w, "one\ntwo\r\nthree")
+		return err
+	}).Publish(context.Background(), conn.Publisher("lines"), nil).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, want := range []string{"one", "two", "three"} {
+		msg, err := sub.Next(ctx)
+		if err != nil || string(msg) != want {
+			t.Fatalf("got %q, %v, want %q", msg, err, want)
+		}
+	}
+
+	if err := conn.Publisher("bad subject").Publish(ctx, []byte("x")); err == nil {
+		t.Error("published to an invalid subject")
+	}
+	if _, err := conn.Subscribe("lines", "bad\nqueue"); err == nil {
+		t.Error("subscribed with an invalid queue")
+	}
+
+	sub.Unsubscribe()
+	if _, err := sub.Next(ctx); err != io.EOF {
+		t.Errorf("got %v after Unsubscribe, want io.EOF", err)
+	}
+
+}
+
+func TestSlowConsumer(t *testing.T) {
+
+	s := newServer(t, nil)
+	conn, err := dialTimeout(t, s.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("flood", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := conn.Publisher("flood")
+	for i := 0; i < nats.DefaultPending+10; i++ {
+		pub.Publish(context.Background(), []byte(strconv.Itoa(i)))
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sub.Dropped() < 10 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if sub.Dropped() != 10 {
+		t.Fatalf("dropped %d messages, want 10", sub.Dropped())
+	}
+
+	ctx := context.Background()
+	if _, err := sub.Next(ctx); err != nats.ErrSlowConsumer {
+		t.Errorf("got %v, want ErrSlowConsumer", err)
+	}
+	if msg, err := sub.Next(ctx); err != nil || string(msg) != "0" {
+		t.Errorf("got %q, %v, want the first message", msg, err)
+	}
+
+	conn.Close()
+	for {
+		_, err := sub.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+}
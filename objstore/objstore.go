@@ -0,0 +1,415 @@
+// Package objstore implements piper.StagingBlobSink and piper.BlobSource on top of the multipart
+// API offered by S3 and most S3 compatible object stores. Wrap the SDK of your store in a Client.
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/noxer/piper"
+	"github.com/pkg/errors"
+)
+
+// Client is the minimal object store API needed by Store
+type Client interface {
+	// CreateMultipartUpload starts a new upload and returns its ID
+	CreateMultipartUpload(ctx context.Context, key string) (string, error)
+	// UploadPart stores a part of the upload. The SHA-256 of the data is passed along so the
+	// store can verify it and the returned ETag identifies the part.
+	UploadPart(ctx context.Context, key, uploadID string, number int, data []byte, sum []byte) (string, error)
+	// CompleteMultipartUpload assembles the uploaded parts into the final object
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) error
+	// AbortMultipartUpload discards the upload and all its parts
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+	// GetObject reads the object starting at offset
+	GetObject(ctx context.Context, key string, offset int64) (io.ReadCloser, error)
+}
+
+// PartLister is implemented by clients which can list the parts of a stored object, e.g. with
+// GetObjectAttributes of S3, it is needed by Store.VerifyDownload
+type PartLister interface {
+	// ListObjectParts returns the parts of the object in order with their sizes and the SHA-256
+	// or the ETag of each
+	ListObjectParts(ctx context.Context, key string) ([]Part, error)
+}
+
+// Part describes an uploaded part of a multipart upload
+type Part struct {
+	Number int
+	ETag   string
+	SHA256 []byte
+	// Size is the length of the part in bytes
+	Size int64
+}
+
+// DefaultPartSize is used when Store.PartSize is not set, it is above the 5MiB minimum of S3
+const DefaultPartSize = 8 << 20
+
+// Store streams blobs to and from an object store. Parts are buffered in memory one at a time.
+type Store struct {
+	Client Client
+
+	// PartSize is the size of the uploaded parts, DefaultPartSize if zero
+	PartSize int
+	// Retries is the number of times a failed request is retried
+	Retries int
+	// Backoff is the delay before the first retry, doubled for every further one (default 100ms)
+	Backoff time.Duration
+	// VerifyETag compares the ETag returned for every part with the MD5 of the part, like S3
+	// returns it for unencrypted uploads.
+	VerifyETag bool
+	// VerifyDownload checks the data read by Get against the parts of the object, the SHA-256 of
+	// every part or the MD5 in its ETag if there is none. The Client must implement PartLister.
+	// A mismatch fails the read completing the part, data read before can't be taken back.
+	VerifyDownload bool
+}
+
+var (
+	_ piper.StagingBlobSink = (*Store)(nil)
+	_ piper.BlobSource      = (*Store)(nil)
+)
+
+// Put uploads everything read from r under key. The upload is aborted if any part fails.
+func (s *Store) Put(ctx context.Context, key string, r io.Reader) error {
+
+	u, err := s.Stage(ctx, key, r)
+	if err != nil {
+		return err
+	}
+	return u.Commit(ctx)
+
+}
+
+// Stage uploads the parts of everything read from r, the object shows up under key once the
+// returned upload is committed. The upload is aborted if any part fails. piper.Chain.Upload stages
+// the object and commits it once the chain succeeded.
+func (s *Store) Stage(ctx context.Context, key string, r io.Reader) (piper.StagedBlob, error) {
+
+	id, err := s.retry(ctx, func() (string, error) {
+		return s.Client.CreateMultipartUpload(ctx, key)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create upload")
+	}
+
+	u := &upload{store: s, key: key, id: id}
+	u.parts, err = s.upload(ctx, key, id, r)
+	if err != nil {
+		u.Abort(ctx)
+		return nil, err
+	}
+	return u, nil
+
+}
+
+// upload is a multipart upload whose parts are uploaded
+type upload struct {
+	store *Store
+	key   string
+	id    string
+	parts []Part
+}
+
+// Commit completes the upload, it is aborted if that fails.
+func (u *upload) Commit(ctx context.Context) error {
+
+	_, err := u.store.retry(ctx, func() (string, error) {
+		return "", u.store.Client.CompleteMultipartUpload(ctx, u.key, u.id, u.parts)
+	})
+	if err != nil {
+		u.Abort(ctx)
+		return errors.Wrap(err, "unable to complete upload")
+	}
+	return nil
+
+}
+
+// Abort discards the upload and its parts.
+func (u *upload) Abort(ctx context.Context) error {
+
+	return u.store.Client.AbortMultipartUpload(context.WithoutCancel(ctx), u.key, u.id)
+
+}
+
+func (s *Store) upload(ctx context.Context, key, id string, r io.Reader) ([]Part, error) {
+
+	size := s.PartSize
+	if size <= 0 {
+		size = DefaultPartSize
+	}
+
+	var parts []Part
+	buf := make([]byte, size)
+	for number := 1; ; number++ {
+
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF && number > 1 {
+			return parts, nil
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, errors.Wrap(err, "unable to read upload data")
+		}
+		last := err != nil
+
+		part, err := s.part(ctx, key, id, number, buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+
+		if last {
+			return parts, nil
+		}
+
+	}
+
+}
+
+func (s *Store) part(ctx context.Context, key, id string, number int, data []byte) (Part, error) {
+
+	sum := sha256.Sum256(data)
+	etag, err := s.retry(ctx, func() (string, error) {
+
+		etag, err := s.Client.UploadPart(ctx, key, id, number, data, sum[:])
+		if err != nil || !s.VerifyETag {
+			return etag, err
+		}
+
+		md := md5.Sum(data)
+		if !strings.EqualFold(strings.Trim(etag, `"`), hex.EncodeToString(md[:])) {
+			return "", errors.Errorf("checksum mismatch, got ETag %s", etag)
+		}
+		return etag, nil
+
+	})
+	if err != nil {
+		return Part{}, errors.Wrapf(err, "unable to upload part %d", number)
+	}
+
+	return Part{Number: number, ETag: etag, SHA256: sum[:], Size: int64(len(data))}, nil
+
+}
+
+// Get returns a reader for the object stored under key. If reading fails midway the object
+// is requested again from the current offset, up to Retries times. See VerifyDownload for
+// checking the data.
+func (s *Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+
+	rr := &resumingReader{ctx: ctx, store: s, key: key}
+	if s.VerifyDownload {
+		lister, ok := s.Client.(PartLister)
+		if !ok {
+			return nil, errors.New("objstore: the client can't list the parts to verify the download")
+		}
+		_, err := s.retry(ctx, func() (string, error) {
+			var err error
+			rr.parts, err = lister.ListObjectParts(ctx, key)
+			return "", err
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to list the parts of %s", key)
+		}
+	}
+	err := rr.open()
+	if err != nil {
+		return nil, err
+	}
+	return rr, nil
+
+}
+
+func (s *Store) retry(ctx context.Context, fn func() (string, error)) (string, error) {
+
+	backoff := s.Backoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+
+	for attempt := 0; ; attempt++ {
+
+		v, err := fn()
+		if err == nil || attempt >= s.Retries {
+			return v, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+
+	}
+
+}
+
+type resumingReader struct {
+	ctx     context.Context
+	store   *Store
+	key     string
+	rc      io.ReadCloser
+	offset  int64
+	retries int
+	// err is the error to handle on the next read, it sticks once rc is closed
+	err error
+
+	// parts are verified if set, part is the index of the current one, read the bytes of it read
+	// so far and sum their checksum
+	parts []Part
+	part  int
+	read  int64
+	sum   hash.Hash
+}
+
+func (r *resumingReader) open() error {
+
+	_, err := r.store.retry(r.ctx, func() (string, error) {
+		rc, err := r.store.Client.GetObject(r.ctx, r.key, r.offset)
+		if err == nil {
+			r.rc = rc
+		}
+		return "", err
+	})
+	return errors.Wrapf(err, "unable to get %s at offset %d", r.key, r.offset)
+
+}
+
+func (r *resumingReader) Read(p []byte) (int, error) {
+
+	for {
+
+		if r.rc == nil {
+			return 0, r.err
+		}
+
+		n, err := 0, r.err
+		r.err = nil
+		if err == nil {
+			n, err = r.rc.Read(p)
+			r.offset += int64(n)
+			if verr := r.verify(p[:n], err == io.EOF); verr != nil {
+				return n, r.fail(verr)
+			}
+			if n > 0 && err != nil && err != io.EOF {
+				// the data is returned first, the error is handled on the next read
+				r.err = err
+				return n, nil
+			}
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		if r.retries >= r.store.Retries || r.ctx.Err() != nil {
+			return n, r.fail(err)
+		}
+
+		r.retries++
+		r.rc.Close()
+		r.rc = nil
+		if err := r.open(); err != nil {
+			r.err = err
+			return 0, err
+		}
+
+	}
+
+}
+
+// fail closes the object, err is returned by every further read.
+func (r *resumingReader) fail(err error) error {
+
+	r.rc.Close()
+	r.rc = nil
+	r.err = err
+	return err
+
+}
+
+// verify adds p to the checksum of the parts, every completed part is checked.
+func (r *resumingReader) verify(p []byte, eof bool) error {
+
+	if r.parts == nil {
+		return nil
+	}
+
+	for {
+
+		for r.part < len(r.parts) && r.read == r.parts[r.part].Size {
+			if err := r.check(r.parts[r.part]); err != nil {
+				return err
+			}
+			r.part++
+			r.read = 0
+			r.sum = nil
+		}
+		if len(p) == 0 {
+			break
+		}
+		if r.part == len(r.parts) {
+			return errors.Errorf("checksum mismatch, %s is larger than its parts", r.key)
+		}
+
+		n := min(int64(len(p)), r.parts[r.part].Size-r.read)
+		r.hash(r.parts[r.part]).Write(p[:n])
+		r.read += n
+		p = p[n:]
+
+	}
+
+	if eof && r.part < len(r.parts) {
+		return errors.Errorf("checksum mismatch, %s ended in part %d", r.key, r.parts[r.part].Number)
+	}
+	return nil
+
+}
+
+// hash returns the checksum of the current part, SHA-256 or the MD5 of its ETag.
+func (r *resumingReader) hash(part Part) hash.Hash {
+
+	if r.sum == nil {
+		if part.SHA256 != nil {
+			r.sum = sha256.New()
+		} else {
+			r.sum = md5.New()
+		}
+	}
+	return r.sum
+
+}
+
+func (r *resumingReader) check(part Part) error {
+
+	sum := r.hash(part).Sum(nil)
+	if part.SHA256 != nil {
+		if !bytes.Equal(sum, part.SHA256) {
+			return errors.Errorf("checksum mismatch in part %d of %s", part.Number, r.key)
+		}
+		return nil
+	}
+
+	etag := strings.Trim(part.ETag, `"`)
+	if etag == "" {
+		return errors.Errorf("part %d of %s has no checksum", part.Number, r.key)
+	}
+	if !strings.EqualFold(etag, hex.EncodeToString(sum)) {
+		return errors.Errorf("checksum mismatch in part %d of %s, got ETag %s", part.Number, r.key, part.ETag)
+	}
+	return nil
+
+}
+
+func (r *resumingReader) Close() error {
+
+	if r.rc == nil {
+		return nil
+	}
+	return r.rc.Close()
+
+}
@@ -0,0 +1,234 @@
+package objstore_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/noxer/piper"
+	"github.com/noxer/piper/objstore"
+)
+
+// fakeClient keeps the objects in memory. failParts and failComplete count the requests to fail,
+// cut is the offset GetObject fails its reader at once.
+type fakeClient struct {
+	mu           sync.Mutex
+	uploads      map[string]map[int][]byte
+	objects      map[string][]byte
+	parts        map[string][]objstore.Part
+	aborted      int
+	failParts    int
+	failComplete int
+	cut          int64
+}
+
+func newFake() *fakeClient {
+
+	return &fakeClient{uploads: map[string]map[int][]byte{}, objects: map[string][]byte{}, parts: map[string][]objstore.Part{}}
+
+}
+
+func (f *fakeClient) CreateMultipartUpload(ctx context.Context, key string) (string, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := fmt.Sprintf("%s-%d", key, len(f.uploads))
+	f.uploads[id] = map[int][]byte{}
+	return id, nil
+
+}
+
+func (f *fakeClient) UploadPart(ctx context.Context, key, id string, number int, data, sum []byte) (string, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failParts > 0 {
+		f.failParts--
+		return "", errors.New("part failed")
+	}
+	f.uploads[id][number] = append([]byte(nil), data...)
+	md := md5.Sum(data)
+	return `"` + hex.EncodeToString(md[:]) + `"`, nil
+
+}
+
+func (f *fakeClient) CompleteMultipartUpload(ctx context.Context, key, id string, parts []objstore.Part) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failComplete > 0 {
+		f.failComplete--
+		return errors.New("complete failed")
+	}
+	var obj []byte
+	for _, p := range parts {
+		obj = append(obj, f.uploads[id][p.Number]...)
+	}
+	f.objects[key] = obj
+	f.parts[key] = parts
+	delete(f.uploads, id)
+	return nil
+
+}
+
+func (f *fakeClient) AbortMultipartUpload(ctx context.Context, key, id string) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.aborted++
+	delete(f.uploads, id)
+	return nil
+
+}
+
+func (f *fakeClient) GetObject(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	obj, ok := f.objects[key]
+	if !ok {
+		return nil, errors.New("no such key")
+	}
+	r := io.Reader(bytes.NewReader(obj[offset:]))
+	if f.cut > offset {
+		r = io.MultiReader(io.LimitReader(r, f.cut-offset), errReader{})
+		f.cut = 0
+	}
+	return io.NopCloser(r), nil
+
+}
+
+func (f *fakeClient) ListObjectParts(ctx context.Context, key string) ([]objstore.Part, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.parts[key], nil
+
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+// source writes data and fails with err
+func source(data string, err error) piper.StageFunc {
+
+	return func(_ io.Reader, w io.Writer) error {
+		if _, werr := io.WriteString(w, data); werr != nil {
+			return werr
+		}
+		return err
+	}
+
+}
+
+func TestUpload(t *testing.T) {
+
+	data := strings.Repeat("0123456789", 10)
+	tests := []struct {
+		name         string
+		chain        func(sink piper.BlobSink) *piper.Chain
+		failParts    int
+		failComplete int
+		retries      int
+		stored       bool
+	}{
+		{"succeeding", func(sink piper.BlobSink) *piper.Chain {
+			return piper.Func(source(data, nil)).Upload(context.Background(), sink, "k")
+		}, 0, 0, 0, true},
+		{"failing upstream", func(sink piper.BlobSink) *piper.Chain {
+			return piper.Func(source(data[:50], errors.New("upstream failed"))).Upload(context.Background(), sink, "k")
+		}, 0, 0, 0, false},
+		{"failing first stage", func(sink piper.BlobSink) *piper.Chain {
+			return piper.Func(source(data[:50], errors.New("upstream failed"))).
+				Func(func(r io.Reader, w io.Writer) error { _, err := io.Copy(w, r); return err }).
+				Upload(context.Background(), sink, "k")
+		}, 0, 0, 0, false},
+		{"retried part", func(sink piper.BlobSink) *piper.Chain {
+			return piper.Func(source(data, nil)).Upload(context.Background(), sink, "k")
+		}, 1, 0, 1, true},
+		{"failing part", func(sink piper.BlobSink) *piper.Chain {
+			return piper.Func(source(data, nil)).Upload(context.Background(), sink, "k")
+		}, 2, 0, 1, false},
+		{"failing complete", func(sink piper.BlobSink) *piper.Chain {
+			return piper.Func(source(data, nil)).Upload(context.Background(), sink, "k")
+		}, 0, 1, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFake()
+			f.failParts, f.failComplete = tt.failParts, tt.failComplete
+			store := &objstore.Store{Client: f, PartSize: 32, Retries: tt.retries, Backoff: 1, VerifyETag: true}
+
+			err := tt.chain(store).Run()
+			obj, stored := f.objects["k"]
+			if stored != tt.stored || (err == nil) != tt.stored {
+				t.Fatalf("stored %v with %v, want %v", stored, err, tt.stored)
+			}
+			if stored && string(obj) != data {
+				t.Errorf("stored %q", obj)
+			}
+			if !stored && f.aborted != 1 {
+				t.Errorf("aborted %d uploads, want 1", f.aborted)
+			}
+			if len(f.uploads) != 0 {
+				t.Errorf("%d uploads left", len(f.uploads))
+			}
+		})
+	}
+
+}
+
+func TestGet(t *testing.T) {
+
+	data := strings.Repeat("0123456789", 10)
+	tests := []struct {
+		name    string
+		cut     int64
+		corrupt bool
+		retries int
+		ok      bool
+	}{
+		{"plain", 0, false, 0, true},
+		{"resumed", 45, false, 1, true},
+		{"not resumed", 45, false, 0, false},
+		{"corrupt", 0, true, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFake()
+			store := &objstore.Store{Client: f, PartSize: 32, Retries: tt.retries, Backoff: 1, VerifyDownload: true}
+			if err := store.Put(context.Background(), "k", strings.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+			if tt.corrupt {
+				f.objects["k"][40] ^= 1
+			}
+			f.cut = tt.cut
+
+			out, err := piper.Download(context.Background(), store, "k").Output()
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v, ok %v", err, tt.ok)
+			}
+			if tt.ok && string(out) != data {
+				t.Errorf("got %q", out)
+			}
+		})
+	}
+
+}
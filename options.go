@@ -0,0 +1,233 @@
+package piper
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrTimeout is the cause of the error returned by Wait if the chain exceeded its timeout, see WithTimeout
+var ErrTimeout = errors.New("piper: chain timed out")
+
+// Option configures a chain, see New and Chain.With
+type Option func(c *Chain)
+
+// Policy decides how a chain reacts to a failing stage
+type Policy int
+
+// Failure policies
+const (
+	// WaitAll lets the other stages run to completion, like a shell
+	WaitAll Policy = iota
+	// KillOnFailure kills all other stages once a stage failed. Stages marked with IgnoreFailure
+	// don't trigger it.
+	KillOnFailure
+)
+
+// New creates an empty chain configured by opts, stages are added with the methods of the chain.
+func New(opts ...Option) *Chain {
+
+	c := &Chain{}
+	c.stages = c.inline[:0]
+	return c.With(opts...)
+
+}
+
+// With applies opts to c. It must be called before the chain is started.
+func (c *Chain) With(opts ...Option) *Chain {
+
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+
+}
+
+// WithStdin sets the input of the first stage.
+func WithStdin(r io.Reader) Option {
+
+	return func(c *Chain) { c.Stdin = r }
+
+}
+
+// WithStdout sets the writer receiving the output of the last stage.
+func WithStdout(w io.Writer) Option {
+
+	return func(c *Chain) { c.Stdout = w }
+
+}
+
+// WithStderr sets the writer receiving the stderr of the last stage.
+func WithStderr(w io.Writer) Option {
+
+	return func(c *Chain) { c.Stderr = w }
+
+}
+
+// WithEnv sets the Env of the chain, the environment of its commands.
+func WithEnv(env ...string) Option {
+
+	return func(c *Chain) { c.Env = env }
+
+}
+
+// WithDir sets the Dir of the chain, the working directory of its commands.
+func WithDir(dir string) Option {
+
+	return func(c *Chain) { c.Dir = dir }
+
+}
+
+// WithLogger sets the Logger of the chain.
+func WithLogger(l *log.Logger) Option {
+
+	return func(c *Chain) { c.Logger = l }
+
+}
+
+// WithPipefail decides whether any failing stage fails the chain, which is the default, or
+// only the last one, like a shell without "set -o pipefail". The failures of the other stages
+// are still recorded in the Result.
+func WithPipefail(on bool) Option {
+
+	return func(c *Chain) { c.noPipefail = !on }
+
+}
+
+// WithPolicy sets how the chain reacts to a failing stage, WaitAll by default.
+func WithPolicy(p Policy) Option {
+
+	return func(c *Chain) { c.policy = p }
+
+}
+
+// WithTimeout kills all stages once the chain ran for d, Wait then returns an error with the cause ErrTimeout.
+func WithTimeout(d time.Duration) Option {
+
+	return func(c *Chain) { c.timeout = d }
+
+}
+
+// WithContext kills all stages once ctx is done, Wait then returns an error with the cause of
+// ctx, e.g. context.Canceled. Function stages created with FuncContext see their context canceled
+// as well. Unlike CommandContext it covers every stage of the chain, and Start fails if ctx is
+// already done.
+func WithContext(ctx context.Context) Option {
+
+	return func(c *Chain) { c.ctx = ctx }
+
+}
+
+// WithKeepOutput lets Output stream the output of the last stage to Stdout and return it as well.
+// At most limit bytes are returned like a LimitedBuffer keeps them, the rest is only streamed.
+func WithKeepOutput(limit int) Option {
+
+	return func(c *Chain) { c.keepOutput = limit }
+
+}
+
+// keptOutput runs the chain and returns the output kept beside Stdout, see WithKeepOutput.
+func (c *Chain) keptOutput() ([]byte, error) {
+
+	if len(c.stages) == 0 {
+		return nil, errors.New("piper: chain has no stages")
+	}
+
+	b := NewLimitedBuffer(c.keepOutput)
+	c.tapStdout(len(c.stages)-1, b)
+
+	err := c.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.Wait()
+	return b.Bytes(), err
+
+}
+
+// startTimer arms the timeout and the context of the chain, see WithTimeout and WithContext.
+func (c *Chain) startTimer() {
+
+	waited := make(chan struct{})
+	c.waited = waited
+	if c.ctx != nil {
+		c.ctxStop = context.AfterFunc(c.ctx, func() {
+			atomic.StoreInt32(&c.canceled, 1)
+			c.Drain()
+			c.cancelStages(waited)
+		})
+	}
+
+	if c.timeout <= 0 {
+		return
+	}
+	c.timer = time.AfterFunc(c.timeout, func() {
+		atomic.StoreInt32(&c.timedOut, 1)
+		c.cancelStages(waited)
+	})
+
+}
+
+// stopTimer disarms the timeout and the context and returns an error if one of them expired.
+func (c *Chain) stopTimer() error {
+
+	if c.waited != nil {
+		close(c.waited)
+		c.waited = nil
+	}
+	for _, stop := range c.ctxStops {
+		stop()
+	}
+	c.ctxStops = nil
+	if c.ctxStop != nil {
+		c.ctxStop()
+		if atomic.LoadInt32(&c.canceled) == 1 {
+			return errors.Wrap(context.Cause(c.ctx), "chain canceled")
+		}
+	}
+
+	if c.timer == nil {
+		return nil
+	}
+	c.timer.Stop()
+	if atomic.LoadInt32(&c.timedOut) == 1 {
+		return errors.Wrapf(ErrTimeout, "chain exceeded its timeout of %v", c.timeout)
+	}
+	return nil
+
+}
+
+// waitStages waits for all stages at once, so a stage blocking on one which isn't waited for yet
+// can't stall the others, and returns their errors, inverted for negated stages. Every stage is
+// reaped even if others failed. With KillOnFailure cause is the index of the stage whose failure
+// killed the others, otherwise -1.
+func (c *Chain) waitStages() (errs []error, cause int) {
+
+	errs = make([]error, len(c.stages))
+	cause = -1
+	var once sync.Once
+	var wg sync.WaitGroup
+	for i, s := range c.stages {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = c.transformError(i, s.waitNegated())
+			c.afterExit(i, s, errs[i])
+			if errs[i] != nil && !s.ignoreFailure && c.policy == KillOnFailure {
+				once.Do(func() {
+					cause = i
+					c.kill()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	return errs, cause
+
+}
@@ -0,0 +1,71 @@
+package piper
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// WithOutputFile writes the output of the last stage to a temporary file next to path, which
+// replaces path once the whole chain succeeded and is removed otherwise. Readers of path never
+// see the output of a failed chain or a half-written file. It can't be combined with Stdout.
+func WithOutputFile(path string, perm os.FileMode) Option {
+
+	return func(c *Chain) { c.outFile = &outFile{path: path, perm: perm} }
+
+}
+
+// outFile is the destination of the output set with WithOutputFile
+type outFile struct {
+	path string
+	perm os.FileMode
+}
+
+// create opens the temporary file receiving the output.
+func (o *outFile) create() (*os.File, error) {
+
+	dir, base := filepath.Split(o.path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.CreateTemp(dir, "."+base+".tmp-*")
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create the output file for %s", o.path)
+	}
+	return f, nil
+
+}
+
+// publish moves the temporary file of the chain to its destination if ok, otherwise it removes it.
+func (c *Chain) publish(ok bool) error {
+
+	o, f := c.outFile, c.outTemp
+	if f == nil {
+		return nil
+	}
+	c.outTemp = nil
+
+	if !ok {
+		f.Close()
+		os.Remove(f.Name())
+		return nil
+	}
+
+	err := f.Chmod(o.perm)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), o.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return errors.Wrapf(err, "unable to write %s", o.path)
+	}
+	return nil
+
+}
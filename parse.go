@@ -0,0 +1,154 @@
+package piper
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParseShell creates a Chain from a pipeline written like a POSIX shell command line, e.g.
+// `grep -i "foo bar" | sort | uniq -c`. Words are split at unquoted blanks and the commands at
+// unquoted pipes, single quotes, double quotes and backslashes work like in a shell. No shell is
+// involved, so everything else a shell would interpret, like variables, variable assignments in
+// front of a command, redirections, globs, negations and command lists, is rejected unless it is
+// quoted. Use Shell for scripts which need a shell and Env for the environment of the commands.
+func ParseShell(pipeline string) (*Chain, error) {
+
+	cmds, err := splitPipeline(pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	c := Command(cmds[0][0], cmds[0][1:]...)
+	for _, cmd := range cmds[1:] {
+		c.Command(cmd[0], cmd[1:]...)
+	}
+	return c, nil
+
+}
+
+// splitPipeline splits s into the words of its commands.
+func splitPipeline(s string) ([][]string, error) {
+
+	var (
+		cmds   [][]string
+		words  []string
+		word   strings.Builder
+		inWord bool
+		// name is set while the word consists of unquoted characters of a variable name
+		name = true
+	)
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+		name = true
+	}
+	endCmd := func(pos int) error {
+		endWord()
+		if len(words) == 0 {
+			return errors.Errorf("piper: empty command at position %d of %q", pos, s)
+		}
+		cmds = append(cmds, words)
+		words = nil
+		return nil
+	}
+
+	for i := 0; i < len(s); i++ {
+
+		ch := s[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			endWord()
+
+		case ch == '|':
+			if i+1 < len(s) && s[i+1] == '|' {
+				return nil, errors.Errorf("piper: unsupported operator || at position %d of %q", i, s)
+			}
+			if err := endCmd(i); err != nil {
+				return nil, err
+			}
+
+		case ch == '\'':
+			name = false
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.Errorf("piper: unterminated single quote at position %d of %q", i, s)
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			inWord = true
+			i += end + 1
+
+		case ch == '"':
+			name = false
+			j, err := doubleQuoted(s, i, &word)
+			if err != nil {
+				return nil, err
+			}
+			inWord = true
+			i = j
+
+		case ch == '\\':
+			if i+1 == len(s) {
+				return nil, errors.Errorf("piper: trailing backslash in %q", s)
+			}
+			i++
+			name = false
+			// an escaped newline continues the line
+			if s[i] != '\n' {
+				word.WriteByte(s[i])
+				inWord = true
+			}
+
+		case strings.IndexByte(";&<>()$`*?[", ch) >= 0 || strings.IndexByte("#~!", ch) >= 0 && !inWord:
+			return nil, errors.Errorf("piper: unsupported shell syntax %q at position %d of %q, quote it to pass it on", ch, i, s)
+
+		case ch == '=' && name && inWord && len(words) == 0:
+			return nil, errors.Errorf("piper: unsupported variable assignment at position %d of %q, set the Env of the chain instead", i, s)
+
+		default:
+			name = name && (ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' && inWord)
+			word.WriteByte(ch)
+			inWord = true
+		}
+
+	}
+
+	if err := endCmd(len(s)); err != nil {
+		return nil, err
+	}
+	return cmds, nil
+
+}
+
+// doubleQuoted writes the content of the double quoted string starting at s[start] to word and
+// returns the position of the closing quote. Variables and command substitutions are rejected.
+func doubleQuoted(s string, start int, word *strings.Builder) (int, error) {
+
+	for i := start + 1; i < len(s); i++ {
+
+		switch s[i] {
+		case '"':
+			return i, nil
+		case '\\':
+			// only these characters are escaped inside double quotes, the backslash stays otherwise
+			if i+1 < len(s) && strings.IndexByte("$`\"\\\n", s[i+1]) >= 0 {
+				i++
+				if s[i] != '\n' {
+					word.WriteByte(s[i])
+				}
+				continue
+			}
+			word.WriteByte('\\')
+		case '$', '`':
+			return 0, errors.Errorf("piper: unsupported shell syntax %q at position %d of %q, escape it to pass it on", s[i], i, s)
+		default:
+			word.WriteByte(s[i])
+		}
+
+	}
+	return 0, errors.Errorf("piper: unterminated double quote at position %d of %q", start, s)
+
+}
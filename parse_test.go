@@ -0,0 +1,93 @@
+package piper_test
+
+import (
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/noxer/piper"
+)
+
+func TestParseShell(t *testing.T) {
+
+	if _, err := exec.LookPath("true"); err != nil {
+		t.Skip("needs true")
+	}
+
+	tests := []struct {
+		name     string
+		pipeline string
+		want     [][]string
+	}{
+		{"words", "true a  b\tc", [][]string{{"a", "b", "c"}}},
+		{"pipes", "true a|true b | true", [][]string{{"a"}, {"b"}, {}}},
+		{"single quotes", `true 'a b' 'it''s' '$x|;'`, [][]string{{"a b", "its", "$x|;"}}},
+		{"double quotes", `true "a b" "\$x" "\"\\" "a\b" ""`, [][]string{{"a b", "$x", `"\`, `a\b`, ""}}},
+		{"backslashes", `true a\ b \| \$x`, [][]string{{"a b", "|", "$x"}}},
+		{"line continuation", "true a \\\n b", [][]string{{"a", "b"}}},
+		{"quoted syntax", `true '#' "~" '!' 'FOO=bar'`, [][]string{{"#", "~", "!", "FOO=bar"}}},
+		{"inside words", "true a#b a~b a!b", [][]string{{"a#b", "a~b", "a!b"}}},
+		{"assignment like arguments", "true FOO=bar --opt=x", [][]string{{"FOO=bar", "--opt=x"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := piper.ParseShell(tt.pipeline)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Run(); err != nil {
+				t.Fatal(err)
+			}
+			stages := c.Result().Stages
+			if len(stages) != len(tt.want) {
+				t.Fatalf("got %d commands, want %d", len(stages), len(tt.want))
+			}
+			for i, s := range stages {
+				if got := s.Args[1:]; !slices.Equal(got, tt.want[i]) {
+					t.Errorf("command #%d: got %q, want %q", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+
+}
+
+func TestParseShellErrors(t *testing.T) {
+
+	tests := []struct {
+		pipeline string
+		err      string
+	}{
+		{"", "empty command"},
+		{"ls |", "empty command"},
+		{"| ls", "empty command"},
+		{"ls || true", "||"},
+		{"ls && true", `'&'`},
+		{"ls; true", `';'`},
+		{"ls > out", `'>'`},
+		{"ls < in", `'<'`},
+		{"echo $HOME", `'$'`},
+		{"echo `id`", "'`'"},
+		{`echo "$HOME"`, `'$'`},
+		{"ls *.go", `'*'`},
+		{"(ls)", `'('`},
+		{"ls # comment", `'#'`},
+		{"ls ~", `'~'`},
+		{"! ls", `'!'`},
+		{"ls | ! grep x", `'!'`},
+		{"FOO=bar ls", "variable assignment"},
+		{"ls | _x1=y grep x", "variable assignment"},
+		{"'ls", "unterminated single quote"},
+		{`"ls`, "unterminated double quote"},
+		{`ls \`, "trailing backslash"},
+	}
+
+	for _, tt := range tests {
+		if _, err := piper.ParseShell(tt.pipeline); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: got %v, want an error containing %s", tt.pipeline, err, tt.err)
+		}
+	}
+
+}
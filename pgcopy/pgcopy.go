@@ -0,0 +1,114 @@
+// Package pgcopy streams chain data into and out of PostgreSQL with COPY FROM STDIN and
+// COPY TO STDOUT, replacing psql at the end or the start of a pipeline.
+package pgcopy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/noxer/piper"
+	"github.com/pkg/errors"
+)
+
+// Conn is the COPY API of a PostgreSQL driver connection. It matches the shape of the COPY
+// methods of most drivers, e.g. pgx's *pgconn.PgConn needs only a small wrapper returning
+// the row count of the command tag.
+type Conn interface {
+	CopyFrom(ctx context.Context, r io.Reader, sql string) (int64, error)
+	CopyTo(ctx context.Context, w io.Writer, sql string) (int64, error)
+}
+
+// Format is the data format used by COPY
+type Format string
+
+// Formats supported by COPY
+const (
+	Text   Format = "text"
+	CSV    Format = "csv"
+	Binary Format = "binary"
+)
+
+// From returns a stage which loads everything it reads with the "COPY ... FROM STDIN" statement sql.
+// If rows is not nil it receives the number of copied rows.
+// Note that the stage can't tell a failed upstream stage from the end of the data, run the
+// load in a transaction and only commit if the chain succeeded.
+func From(ctx context.Context, conn Conn, sql string, rows *int64) piper.StageFunc {
+
+	return func(r io.Reader, _ io.Writer) error {
+
+		n, err := conn.CopyFrom(ctx, r, sql)
+		if rows != nil {
+			*rows = n
+		}
+		return errors.Wrap(err, "unable to copy into database")
+
+	}
+
+}
+
+// To returns a stage which writes the output of the "COPY ... TO STDOUT" statement sql.
+// Use it with piper.Func to start a chain.
+func To(ctx context.Context, conn Conn, sql string) piper.StageFunc {
+
+	return func(_ io.Reader, w io.Writer) error {
+
+		_, err := conn.CopyTo(ctx, w, sql)
+		return errors.Wrap(err, "unable to copy from database")
+
+	}
+
+}
+
+// FromTable returns a stage loading its input into table, see From. A format other than Text,
+// CSV or Binary fails the stage.
+func FromTable(ctx context.Context, conn Conn, table string, format Format, rows *int64) piper.StageFunc {
+
+	if err := format.check(); err != nil {
+		return fail(err)
+	}
+	return From(ctx, conn, fmt.Sprintf("COPY %s FROM STDIN WITH (FORMAT %s)", Ident(table), format), rows)
+
+}
+
+// ToTable returns a stage writing the content of table, see To and FromTable.
+func ToTable(ctx context.Context, conn Conn, table string, format Format) piper.StageFunc {
+
+	if err := format.check(); err != nil {
+		return fail(err)
+	}
+	return To(ctx, conn, fmt.Sprintf("COPY %s TO STDOUT WITH (FORMAT %s)", Ident(table), format))
+
+}
+
+// check validates the format, it is written into the statement as is.
+func (f Format) check() error {
+
+	switch f {
+	case Text, CSV, Binary:
+		return nil
+	}
+	return errors.Errorf("pgcopy: unsupported format %q", string(f))
+
+}
+
+// fail returns a stage failing with err.
+func fail(err error) piper.StageFunc {
+
+	return func(io.Reader, io.Writer) error {
+		return err
+	}
+
+}
+
+// Ident quotes a possibly schema qualified identifier like "schema.table".
+func Ident(name string) string {
+
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = `"` + strings.ReplaceAll(p, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+
+}
@@ -0,0 +1,142 @@
+package pgcopy_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/noxer/piper"
+	"github.com/noxer/piper/pgcopy"
+)
+
+// fakeConn records the statements and stores the copied data as rows separated by newlines
+type fakeConn struct {
+	sql  []string
+	data string
+	err  error
+}
+
+func (c *fakeConn) CopyFrom(_ context.Context, r io.Reader, sql string) (int64, error) {
+
+	c.sql = append(c.sql, sql)
+	b, err := io.ReadAll(r)
+	c.data += string(b)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return int64(bytes.Count(b, []byte("\n"))), err
+
+}
+
+func (c *fakeConn) CopyTo(_ context.Context, w io.Writer, sql string) (int64, error) {
+
+	c.sql = append(c.sql, sql)
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := io.WriteString(w, c.data)
+	return int64(n), err
+
+}
+
+func TestIdent(t *testing.T) {
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"users", `"users"`},
+		{"public.users", `"public"."users"`},
+		{"Mixed Case", `"Mixed Case"`},
+		{`a"b`, `"a""b"`},
+		{`x"; DROP TABLE y; --`, `"x""; DROP TABLE y; --"`},
+		{"", `""`},
+	}
+
+	for _, tt := range tests {
+		if got := pgcopy.Ident(tt.name); got != tt.want {
+			t.Errorf("Ident(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+}
+
+func TestFromTable(t *testing.T) {
+
+	tests := []struct {
+		format pgcopy.Format
+		sql    string
+		ok     bool
+	}{
+		{pgcopy.Text, `COPY "public"."t" FROM STDIN WITH (FORMAT text)`, true},
+		{pgcopy.CSV, `COPY "public"."t" FROM STDIN WITH (FORMAT csv)`, true},
+		{pgcopy.Binary, `COPY "public"."t" FROM STDIN WITH (FORMAT binary)`, true},
+		{"csv, HEADER true", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			conn := &fakeConn{}
+			var rows int64
+			c := piper.Func(pgcopy.FromTable(context.Background(), conn, "public.t", tt.format, &rows))
+			c.Stdin = strings.NewReader("1\ta\n2\tb\n")
+			err := c.Run()
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v, ok %v", err, tt.ok)
+			}
+			if !tt.ok {
+				if len(conn.sql) != 0 {
+					t.Errorf("ran %q", conn.sql)
+				}
+				return
+			}
+			if len(conn.sql) != 1 || conn.sql[0] != tt.sql {
+				t.Errorf("ran %q, want %s", conn.sql, tt.sql)
+			}
+			if conn.data != "1\ta\n2\tb\n" || rows != 2 {
+				t.Errorf("copied %q in %d rows", conn.data, rows)
+			}
+		})
+	}
+
+}
+
+func TestToTable(t *testing.T) {
+
+	conn := &fakeConn{data: "1,a\n2,b\n"}
+	out, err := piper.Func(pgcopy.ToTable(context.Background(), conn, "t", pgcopy.CSV)).Output()
+	if err != nil || string(out) != "1,a\n2,b\n" {
+		t.Errorf("got %q, %v", out, err)
+	}
+	if want := `COPY "t" TO STDOUT WITH (FORMAT csv)`; len(conn.sql) != 1 || conn.sql[0] != want {
+		t.Errorf("ran %q, want %s", conn.sql, want)
+	}
+
+	if err := piper.Func(pgcopy.ToTable(context.Background(), conn, "t", "json")).Run(); err == nil {
+		t.Error("copied with an unsupported format")
+	}
+
+}
+
+func TestCopyErrors(t *testing.T) {
+
+	broken := errors.New("connection reset")
+	conn := &fakeConn{err: broken}
+
+	rows := int64(-1)
+	c := piper.Func(pgcopy.From(context.Background(), conn, "COPY t FROM STDIN", &rows))
+	c.Stdin = strings.NewReader("x\n")
+	if err := c.Run(); errors.Cause(err) != broken || rows != 0 {
+		t.Errorf("From: got %v and %d rows", err, rows)
+	}
+
+	if err := piper.Func(pgcopy.To(context.Background(), conn, "COPY t TO STDOUT")).Run(); errors.Cause(err) != broken {
+		t.Errorf("To: got %v", err)
+	}
+
+}
@@ -3,59 +3,213 @@
 package piper
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"io"
+	"log"
+	"os"
 	"os/exec"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 // Chain holds a chain of commands where all output from a command is piped to the next one
 type Chain struct {
-	cmds []*exec.Cmd
+	stages []*stage
+	// inline backs stages for short chains, saving allocations
+	inline [4]*stage
+
+	// Name identifies the chain in the pprof labels of the goroutines it starts
+	Name string
 
 	Stdin  io.Reader
 	Stdout io.Writer
 	Stderr io.Writer
 
 	Allerr io.Writer
+
+	// Env is the environment of the commands whose exec.Cmd has no Env, nil inherits the
+	// environment of the process. StageEnv adds to it. Dir is the working directory of the
+	// commands without one, see StageDir. Both don't apply to commands started ahead of time by
+	// a Pool.
+	Env []string
+	Dir string
+
+	// StderrFor, if set, returns the writer for the stderr of command #i. It takes precedence
+	// over Allerr, Stderr still takes precedence for the last command. A nil return falls back
+	// to Allerr. If the writer implements io.Closer it is closed after the command exited.
+	StderrFor func(i int, path string) io.Writer
+
+	// CombineAll makes CombinedOutput return the stdout and stderr of every command instead of
+	// only the last one, in the order they were written. Every line is prefixed with the number
+	// and name of the command and the stream it originates from, e.g. "[#1 grep:err] ".
+	CombineAll bool
+
+	// DeadlockTimeout enables the deadlock guard of Wait. If Wait blocks while a pipe returned by
+	// StdinPipe is still open or one returned by StdoutPipe or StderrPipe has unread data, and the
+	// pipe makes no progress for this duration, all commands are killed and Wait returns an error
+	// with the cause ErrDeadlock explaining the misuse.
+	DeadlockTimeout time.Duration
+
+	// StderrExcerpt keeps the last StderrExcerpt bytes of the stderr of every command, they are
+	// attached to the StageError of a failing command. Without it the end of the stderr captured
+	// by Capture, up to DefaultExcerpt bytes, is attached.
+	StderrExcerpt int
+
+	// Logger is handed to function stages through their context, see StageInfo
+	Logger *log.Logger
+
+	// FastStart keeps the work done in the parent to start the commands to a minimum, for chains
+	// of many short-lived commands. Unset streams and ones set to io.Discard are connected to a
+	// shared null device instead of being opened or copied per command, and the environment is
+	// resolved once for all commands. Go already starts commands with vfork on Linux and most of
+	// the latency, about 0.6ms per command on a typical Linux machine, is spent in the kernel, so
+	// this saves 5-10% of it and most allocations of stages without output.
+	FastStart bool
+
+	// Instrument routes every link through the parent process so the bytes moved across it are
+	// counted, see Debug and Result. Links with taps are always routed through the parent.
+	Instrument bool
+
+	settings
+
+	// the observers of the streams and the checks collect the output of one run, Clone leaves
+	// them out
+	inTaps   []io.Writer
+	outTaps  map[int][]io.Writer
+	endTaps  []io.Writer
+	errTaps  map[int][]io.Writer
+	captures []*Capture
+	checks   []func() error
+	scripted *script
+
+	relays         []*relay
+	excerpts       []*tailBuffer
+	watched        []*watchedPipe
+	output         *meter
+	waiting        int32
+	closeAfterWait []io.Closer
+
+	gate       *gate
+	outTemp    *os.File
+	parts      []part
+	cond       condition
+	running    *Chain
+	pgid       int32
+	heldMu     sync.Mutex
+	held       map[int]*os.File
+	pubMu      sync.Mutex
+	publishers []func(bool) error
+	timer      *time.Timer
+	timedOut   int32
+	ctxStop    func() bool
+	ctxStops   []func()
+	canceled   int32
+	chaosKill  *time.Timer
+	runSeed    int64
+	errBufs    []*LimitedBuffer
+	waited     chan struct{}
+	started    time.Time
+	input      *meter
+	traceCtx   context.Context
+	span       Span
+	spans      []Span
+	killed     int32
+
+	result *Result
+}
+
+// settings are the configuration of a chain made by its options and methods which isn't exported.
+// They are copied as a whole by Clone and to the pipelines of a conditional chain, see derive.
+type settings struct {
+	noPipefail bool
+	policy     Policy
+	timeout    time.Duration
+	keepOutput int
+	barrier    bool
+	outFile    *outFile
+	coreDir    string
+	spawnRetry *SpawnRetry
+	ports      *Ports
+	group      bool
+	ctx        context.Context
+	chaos      *Chaos
+	seed       int64
+	seeded     bool
+	errLimit   int
+	cancel     CancelFunc
+	waitDelay  time.Duration
+	combine    CombineOrder
+	framing    bufio.SplitFunc
+	expectWait time.Duration
+	transform  func(int, error) error
+	before     []func(int, *exec.Cmd)
+	after      []func(int, *os.ProcessState, error)
+	tracer     Tracer
+	misuse     error
+}
+
+// newChain creates a chain starting with s.
+func newChain(s *stage) *Chain {
+
+	c := &Chain{}
+	c.inline[0] = s
+	c.stages = c.inline[:1]
+	return c
+
 }
 
 // Command creates a new Chain with the provided command as the first command.
 // If behaves exactly like exec.Command but enables users to append more commands.
 func Command(name string, arg ...string) *Chain {
 
-	return &Chain{
-		cmds: []*exec.Cmd{exec.Command(name, arg...)},
-	}
+	return newChain(commandStage(nil, name, arg))
 
 }
 
 // CommandContext creates a new Chain with the provided command as the first command
 // If behaves exactly like exec.CommandContext but enables users to append more commands.
+// A profile stored in ctx with NewContext is applied to the chain.
 func CommandContext(ctx context.Context, name string, arg ...string) *Chain {
 
-	return &Chain{
-		cmds: []*exec.Cmd{exec.CommandContext(ctx, name, arg...)},
-	}
+	return newChain(commandStage(ctx, name, arg)).withContextProfile(ctx)
 
 }
 
 // Cmd creates a new Chain with the provided command as the first command
 // This function can be used when a more fine grained control over the process is
-// necessary. You should not change the exec.Cmd after is has been added to the chain.
-func Cmd(cmd *exec.Cmd) *Chain {
+// necessary. You should not change the exec.Cmd after is has been added to the chain,
+// opts configure it, see Chain.Configure.
+func Cmd(cmd *exec.Cmd, opts ...StageOption) *Chain {
 
-	return &Chain{
-		cmds: []*exec.Cmd{cmd},
-	}
+	return newChain((&stage{cmd: cmd}).configure(opts))
+
+}
+
+// Func creates a new Chain with the in-process stage fn as the first stage.
+// This is used to start a chain from a Go source instead of a command.
+func Func(fn StageFunc) *Chain {
+
+	return newChain(&stage{fn: fn})
+
+}
+
+// FuncContext creates a new Chain with the in-process stage fn as the first stage, see Chain.FuncContext.
+func FuncContext(ctx context.Context, fn StageContextFunc) *Chain {
+
+	return newChain(&stage{cfn: fn, ctx: ctx})
 
 }
 
 // Command adds the command to the back of the command chain.
 func (c *Chain) Command(name string, arg ...string) *Chain {
 
-	c.cmds = append(c.cmds, exec.Command(name, arg...))
+	c.stages = append(c.stages, commandStage(nil, name, arg))
 	return c
 
 }
@@ -63,14 +217,77 @@ func (c *Chain) Command(name string, arg ...string) *Chain {
 // CommandContext adds the command to the back of the command chain
 func (c *Chain) CommandContext(ctx context.Context, name string, arg ...string) *Chain {
 
-	c.cmds = append(c.cmds, exec.CommandContext(ctx, name, arg...))
+	c.stages = append(c.stages, commandStage(ctx, name, arg))
+	return c
+
+}
+
+// Cmd adds the command to the back of the command chain, configured with opts.
+func (c *Chain) Cmd(cmd *exec.Cmd, opts ...StageOption) *Chain {
+
+	c.stages = append(c.stages, (&stage{cmd: cmd}).configure(opts))
+	return c
+
+}
+
+// Func adds an in-process stage to the back of the command chain. The function runs in its own
+// goroutine, reading the output of the previous stage and writing the input for the next one.
+// Its error is reported by Wait like the exit status of a command, a panic fails the stage with
+// the cause ErrPanic. Its input is closed once it returns, so the previous stage stops.
+func (c *Chain) Func(fn StageFunc) *Chain {
+
+	c.stages = append(c.stages, &stage{fn: fn})
+	return c
+
+}
+
+// FuncContext adds an in-process stage like Func. The function receives a context derived from ctx
+// which carries the StageInfo of the stage, so it can honor the cancellation and deadline of ctx
+// like a command created by CommandContext is killed.
+func (c *Chain) FuncContext(ctx context.Context, fn StageContextFunc) *Chain {
+
+	c.stages = append(c.stages, &stage{cfn: fn, ctx: ctx})
+	return c
+
+}
+
+// IgnoreFailure marks the last added stage so its failure doesn't fail the chain, like "cmd || true"
+// in a shell. The failure is still recorded in the Result.
+func (c *Chain) IgnoreFailure() *Chain {
+
+	c.last().ignoreFailure = true
 	return c
 
 }
 
-func (c *Chain) Cmd(cmd *exec.Cmd) *Chain {
+// Not inverts the success of the last added stage, like "! cmd" in a shell. A successful stage
+// fails with ErrNegated, a failing one succeeds. Its real exit status is still recorded in the Result.
+func (c *Chain) Not() *Chain {
+
+	c.last().negate = !c.last().negate
+	return c
+
+}
 
-	c.cmds = append(c.cmds, cmd)
+// ShellSignals are the signals reset by ResetSignals if none are given
+var ShellSignals = []os.Signal{syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGPIPE, syscall.SIGTERM}
+
+// ResetSignals starts the last added command with the default disposition of sigs, ShellSignals
+// if none are given, like a command started by an interactive shell. By default a command inherits
+// the signals ignored by the parent, e.g. with signal.Ignore or nohup, and some tools misbehave
+// if SIGPIPE or SIGINT are ignored. The signal mask is always inherited. It has no effect on Windows.
+//
+// The dispositions belong to the whole process: the ignored signals of sigs are handled while
+// the command starts, arriving ones are dropped. All other commands started by piper wait for
+// it, commands started with os/exec directly at the same time may start with the switched
+// dispositions. The application must not change the dispositions of sigs, e.g. with
+// signal.Ignore, while such a command starts.
+func (c *Chain) ResetSignals(sigs ...os.Signal) *Chain {
+
+	if len(sigs) == 0 {
+		sigs = ShellSignals
+	}
+	c.last().resetSignals = sigs
 	return c
 
 }
@@ -78,130 +295,695 @@ func (c *Chain) Cmd(cmd *exec.Cmd) *Chain {
 // CombinedOutput executes the chain and returns the combined output
 func (c *Chain) CombinedOutput() ([]byte, error) {
 
-	err := c.link()
-	if err != nil {
-		return nil, err
+	if c.keepsOpen() {
+		return nil, errKeepOpen
+	}
+	if c.Stdout != nil {
+		return nil, errors.New("piper: Stdout already set")
+	}
+	if c.Stderr != nil {
+		return nil, errors.New("piper: Stderr already set")
 	}
 
-	err = c.start()
+	var b bytes.Buffer
+	flush := func() {}
+	if c.CombineAll {
+		flush = c.combineAll(&b)
+	} else {
+		c.Stdout = &b
+		c.Stderr = &b
+	}
+
+	err := c.Start()
 	if err != nil {
 		return nil, err
 	}
 
-	return c.cmds[len(c.cmds)-1].CombinedOutput()
+	err = c.Wait()
+	flush()
+	return b.Bytes(), err
 
 }
 
+// Output runs the chain and returns the output of the last stage. If Stdout is set, it is only
+// allowed with WithKeepOutput, the output is then streamed to Stdout and kept at the same time.
 func (c *Chain) Output() ([]byte, error) {
 
-	err := c.link()
-	if err != nil {
-		return nil, err
+	if c.keepsOpen() {
+		return nil, errKeepOpen
+	}
+	if c.Stdout != nil {
+		if c.keepOutput <= 0 {
+			return nil, errors.New("piper: Stdout already set")
+		}
+		return c.keptOutput()
 	}
 
-	err = c.start()
+	var b bytes.Buffer
+	c.Stdout = &b
+
+	err := c.Start()
 	if err != nil {
 		return nil, err
 	}
 
-	return c.cmds[len(c.cmds)-1].Output()
+	err = c.Wait()
+	return b.Bytes(), err
+
+}
+
+// Run starts the chain and waits for it to complete. Like Wait it waits for every stage and
+// returns the first error encountered, the outcome of every stage is available from Result. A
+// chain with a KeepOpenLink can't be run this way, see Drain.
+func (c *Chain) Run() error {
+
+	if c.keepsOpen() {
+		return errKeepOpen
+	}
+	err := c.Start()
+	if err != nil {
+		return err
+	}
+	return c.Wait()
 
 }
 
 func (c *Chain) Start() error {
 
-	err := c.link()
+	if c.misuse != nil {
+		return c.misuse
+	}
+	if len(c.parts) > 0 {
+		if c.scripted != nil {
+			return errConditional
+		}
+		return c.startParts()
+	}
+	return c.startPipeline()
+
+}
+
+// startPipeline starts the stages of the chain.
+func (c *Chain) startPipeline() error {
+
+	if len(c.stages) == 0 {
+		return errors.New("piper: chain has no stages")
+	}
+	if c.ctx != nil && c.ctx.Err() != nil {
+		c.release()
+		return errors.Wrap(context.Cause(c.ctx), "unable to start chain")
+	}
+	c.pickSeed()
+
+	err := c.prepareScript()
+	if err != nil {
+		c.release()
+		return err
+	}
+	err = c.link()
 	if err != nil {
+		c.stopScript()
+		c.release()
 		return err
 	}
 
+	c.started = time.Now()
+	c.startTrace()
 	err = c.start()
 	if err != nil {
+		c.stopTimer()
+		c.stopScript()
+		c.publish(false)
+		c.endTrace(err)
 		return err
 	}
-
-	return c.cmds[len(c.cmds)-1].Start()
+	c.startTimer()
+	c.startChaos()
+	c.runScript()
+	return nil
 
 }
 
 func (c *Chain) StdinPipe() (io.WriteCloser, error) {
 
-	return c.cmds[0].StdinPipe()
+	if len(c.parts) > 0 {
+		return nil, errConditional
+	}
+	if len(c.stages) == 0 {
+		return nil, errNoStage
+	}
+	w, err := c.stages[0].stdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	return c.watch("StdinPipe", nil, w), nil
 
 }
 
 func (c *Chain) StdoutPipe() (io.ReadCloser, error) {
 
-	return c.cmds[len(c.cmds)-1].StdoutPipe()
+	if len(c.parts) > 0 {
+		return nil, errConditional
+	}
+	if len(c.stages) == 0 {
+		return nil, errNoStage
+	}
+	r, err := c.last().stdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	return c.watch("StdoutPipe", r, nil), nil
 
 }
 
 func (c *Chain) StderrPipe() (io.ReadCloser, error) {
 
-	return c.cmds[len(c.cmds)-1].StderrPipe()
+	if len(c.parts) > 0 {
+		return nil, errConditional
+	}
+	if len(c.stages) == 0 {
+		return nil, errNoStage
+	}
+	r, err := c.last().stderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	return c.watch("StderrPipe", r, nil), nil
 
 }
 
+// Wait waits for every command of the chain to exit and returns the first error encountered.
+// All commands are waited for even if one of them fails, the outcome is available from Result.
 func (c *Chain) Wait() error {
 
-	var err error
-	for i, cmd := range c.cmds {
+	if c.running != nil {
+		return c.waitParts()
+	}
+	if len(c.stages) == 0 {
+		return errors.New("piper: chain has no stages")
+	}
+
+	atomic.StoreInt32(&c.waiting, 1)
+	stop := c.guard()
+
+	var first error
+	var failed []*StageError
+	r := &Result{Stages: make([]StageResult, len(c.stages)), Seed: c.runSeed}
+	errs, cause := c.waitStages()
+	for i, err := range errs {
 
-		err = cmd.Wait()
+		s := c.stages[i]
 		if err != nil {
-			return errors.Wrapf(err, "unable to wait for process #%d (%s)", i, cmd.Path)
+			se := &StageError{Index: i, Path: s.name(), ExitCode: -1, Err: err}
+			if s.cmd != nil {
+				se.Args = s.cmd.Args
+				if s.cmd.ProcessState != nil {
+					se.ExitCode = s.cmd.ProcessState.ExitCode()
+				}
+			}
+			failed = append(failed, se)
+			err = se
+			if first == nil && !s.ignoreFailure && (!c.noPipefail || i == len(c.stages)-1) || i == cause {
+				first = &PipelineError{StageError: se}
+			}
+		}
+
+		r.Stages[i] = StageResult{Err: err, Ignored: s.ignoreFailure, Negated: s.negate, SpawnRetries: s.retries}
+		if s.cmd != nil {
+			r.Stages[i].Path = s.cmd.Path
+			r.Stages[i].Args = s.cmd.Args
+			r.Stages[i].State = s.cmd.ProcessState
+			if s.cmd.ProcessState != nil {
+				r.Stages[i].Exit = exitStatus(s.cmd.ProcessState)
+			}
+		}
+
+	}
+
+	if c.coreDir != "" {
+		c.collectCores(r)
+	}
+
+	c.Drain()
+	for _, rl := range c.relays {
+		rl.wait()
+	}
+	c.waitTerminals()
+	for _, cl := range c.closeAfterWait {
+		cl.Close()
+	}
+	c.closeAfterWait = nil
+
+	for _, cp := range c.captures {
+		cp.record(r)
+	}
+	for _, se := range failed {
+		se.stderr = c.excerpt(se.Index)
+	}
+	if pe, ok := first.(*PipelineError); ok {
+		pe.Failed = failed
+	}
+	for _, check := range c.checks {
+		if err := check(); err != nil && first == nil {
+			first = err
 		}
+	}
 
+	r.Links = make([]LinkResult, len(c.stages)-1)
+	for i := range r.Links {
+		r.Links[i] = LinkResult{From: i, Bytes: -1, Framed: -1}
+	}
+	for _, rl := range c.relays {
+		r.Links[rl.from] = rl.m.checkpoint(rl.from, c.started)
+	}
+	if c.output != nil {
+		out := c.output.checkpoint(len(c.stages)-1, c.started)
+		r.Output = &out
 	}
 
+	c.result = r
+	c.stopChaos()
+	timeout := c.stopTimer()
+	script := c.stopScript()
+	r.Partial = c.partial()
+	err := stop()
+	if err == nil {
+		err = timeout
+	}
+	if err == nil {
+		err = script
+	}
+	if err == nil && first != nil {
+		err = first
+	}
+	if ferr := c.publish(err == nil); ferr != nil {
+		err = ferr
+	}
+	if berr := c.publishBlobs(err == nil); berr != nil {
+		err = berr
+	}
+	c.endTrace(err)
+	return err
+
+}
+
+// ErrNegated is the cause of the error of a stage marked with Not which succeeded
+var ErrNegated = errors.New("piper: negated stage succeeded")
+
+func negate(err error) error {
+
+	if err == nil {
+		return ErrNegated
+	}
 	return nil
 
 }
 
+// Result returns the outcome of the last run of the chain. It is nil until Wait returned.
+func (c *Chain) Result() *Result {
+
+	return c.result
+
+}
+
+// Clone returns a new chain with copies of all commands, ready to be run again.
+// Only the command configuration (path, arguments, environment, working directory and
+// process attributes) is copied, not the I/O of the individual commands. The argument and
+// environment slices are shared with c and must not be modified. The settings of the chain are
+// copied, the observers collecting the output of a run aren't: taps, captures, broadcasts,
+// analyzers, checks like VerifyOutputSHA256 and Expect scripts have to be added to the clone.
+func (c *Chain) Clone() *Chain {
+
+	n := c.derive()
+
+	// the stages and commands are allocated at once, chains are cloned in hot loops
+	if len(c.stages) <= len(n.inline) {
+		n.stages = n.inline[:len(c.stages)]
+	} else {
+		n.stages = make([]*stage, len(c.stages))
+	}
+	stages := make([]stage, len(c.stages))
+	cmds := make([]exec.Cmd, len(c.stages))
+	for i, s := range c.stages {
+		s.clone(&stages[i], &cmds[i])
+		n.stages[i] = &stages[i]
+	}
+	c.cloneParts(n)
+
+	return n
+
+}
+
+// derive returns a chain without stages with the exported fields and the settings of c.
+func (c *Chain) derive() *Chain {
+
+	return &Chain{
+		Name:   c.Name,
+		Stdin:  c.Stdin,
+		Stdout: c.Stdout,
+		Stderr: c.Stderr,
+		Allerr: c.Allerr,
+		Env:    c.Env,
+		Dir:    c.Dir,
+
+		StderrFor:     c.StderrFor,
+		CombineAll:    c.CombineAll,
+		StderrExcerpt: c.StderrExcerpt,
+
+		DeadlockTimeout: c.DeadlockTimeout,
+		Logger:          c.Logger,
+		FastStart:       c.FastStart,
+		Instrument:      c.Instrument,
+
+		settings: c.settings,
+	}
+
+}
+
+// applyDefaults hands the Env and Dir of the chain to the commands without their own.
+func (c *Chain) applyDefaults() {
+
+	for _, s := range c.stages {
+		if s.cmd == nil || s.pool != nil {
+			continue
+		}
+		if s.cmd.Env == nil {
+			s.cmd.Env = c.Env
+		}
+		if s.cmd.Dir == "" {
+			s.cmd.Dir = c.Dir
+		}
+	}
+
+}
+
+// last returns the last added stage. If there is none, e.g. right after And, Start fails with
+// errNoStage and a detached stage is returned, so configuring it has no effect.
+func (c *Chain) last() *stage {
+
+	if len(c.stages) == 0 {
+		c.fail(errNoStage)
+		return &stage{}
+	}
+	return c.stages[len(c.stages)-1]
+
+}
+
+// fail makes Start fail with err, the first error is kept.
+func (c *Chain) fail(err error) {
+
+	if c.misuse == nil {
+		c.misuse = err
+	}
+
+}
+
 func (c *Chain) link() error {
 
-	for i := 0; i < len(c.cmds)-1; i++ {
+	c.applyDefaults()
+	c.expandPorts()
 
-		pipe, err := c.cmds[i].StdoutPipe()
-		if err != nil {
-			return errors.Wrapf(err, "unable to pipe command #%d (%s)", i, c.cmds[i].Path)
+	if c.StderrExcerpt > 0 {
+		c.excerpts = make([]*tailBuffer, len(c.stages))
+		for i, s := range c.stages {
+			if s.cmd != nil {
+				c.excerpts[i] = &tailBuffer{limit: c.StderrExcerpt}
+				c.tapStderr(i, c.excerpts[i])
+			}
+		}
+	}
+	if c.errLimit > 0 {
+		c.bufferStderr()
+	}
+
+	for i, s := range c.stages {
+		if s.streams != StdoutStream && (s.cmd == nil || i == len(c.stages)-1) {
+			return errors.Errorf("piper: the stderr of stage #%d (%s) can't be piped", i, s.name())
 		}
-		c.cmds[i+1].Stdin = pipe
+	}
 
-		if c.Allerr != nil {
-			c.cmds[i].Stderr = c.Allerr
+	for i := 0; i < len(c.stages)-1; i++ {
+
+		r, w, err := c.pipe(i)
+		if err != nil {
+			return errors.Wrapf(err, "unable to pipe command #%d (%s)", i, c.stages[i].name())
 		}
+		if s := c.stages[i]; s.streams != StdoutStream {
+			if s.streams == BothStreams {
+				s.setStdout(w)
+			}
+			s.errNext = w
+		} else {
+			s.setStdout(w)
+		}
+		switch s := c.stages[i]; {
+		case s.link == KeepOpenLink:
+			c.hold(i, w)
+		case s.errNext != nil && len(c.errTaps[i]) > 0:
+			// exec copies the tapped stderr to the link until the command exited
+			s.closeAfterWait = append(s.closeAfterWait, w)
+		default:
+			s.own(w)
+		}
+		c.stages[i+1].setStdin(r)
+		c.stages[i+1].own(r)
+
+	}
 
+	for i, s := range c.stages {
+		errw := s.errNext
+		if errw == nil {
+			errw = c.stderr(i)
+		}
+		if w := tee(errw, c.errTaps[i]); w != nil {
+			s.setStderr(w)
+		}
 	}
 
 	if c.Stdin != nil {
-		c.cmds[0].Stdin = c.Stdin
+		in := c.Stdin
+		if len(c.inTaps) > 0 {
+			in = io.TeeReader(in, tee(nil, c.inTaps))
+		}
+		in = c.countInput(in)
+		if c.barrier {
+			c.gate = newGate(in)
+			in = c.gate
+		}
+		c.stages[0].setStdin(in)
 	}
-	if c.Stdout != nil {
-		c.cmds[len(c.cmds)-1].Stdout = c.Stdout
+	stdout := c.Stdout
+	if c.outFile != nil {
+		if stdout != nil || c.last().hasStdout() {
+			return errors.New("piper: Stdout is set, the output can't be written to a file")
+		}
+		f, err := c.outFile.create()
+		if err != nil {
+			return err
+		}
+		c.outTemp = f
+		stdout = f
 	}
-	if c.Stderr != nil {
-		c.cmds[len(c.cmds)-1].Stderr = c.Stderr
-	} else if c.Allerr != nil {
-		c.cmds[len(c.cmds)-1].Stderr = c.Allerr
+
+	last := len(c.stages) - 1
+	taps := c.outTaps[last]
+	if len(c.endTaps) > 0 {
+		taps = append(taps[:len(taps):len(taps)], c.endTaps...)
+	}
+	if w := tee(stdout, taps); w != nil && (stdout != nil || !c.last().hasStdout()) {
+		if c.Instrument || c.ctx != nil || c.timeout > 0 {
+			c.output = &meter{w: w, framer: framer{split: c.framing}}
+			w = c.output
+		}
+		c.last().setStdout(w)
 	}
 
-	return nil
+	return c.attachTerminals()
+
+}
+
+// pipe creates the link between stage i and i+1. Links with taps are relayed
+// through the parent process, others connect the stages directly.
+func (c *Chain) pipe(i int) (r, w *os.File, err error) {
+
+	taps := c.outTaps[i]
+	if c.stages[i].link == PtyLink {
+		rl, w, r, err := newPtyRelay(i, taps)
+		if err != nil {
+			return nil, nil, err
+		}
+		if c.chaos != nil {
+			rl.in = c.chaos.reader(c.runSeed, i, rl.in)
+		}
+		rl.m.split = c.framing
+		c.relays = append(c.relays, rl)
+		return r, w, nil
+	}
+	if kind := c.stages[i].link; kind != PipeLink {
+		if kind != SocketLink && kind != PacketLink && kind != KeepOpenLink {
+			return nil, nil, errors.Errorf("piper: unable to link stages with %v", kind)
+		}
+		if len(taps) > 0 {
+			return nil, nil, errors.Errorf("piper: %v links can't be tapped", kind)
+		}
+		if kind == KeepOpenLink {
+			return os.Pipe()
+		}
+		w, r, err := socketPair(kind)
+		return r, w, err
+	}
+	if len(taps) == 0 && !c.Instrument && c.chaos == nil {
+		return os.Pipe()
+	}
+
+	rl, w, r, err := newRelay(i, taps)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.chaos != nil {
+		rl.in = c.chaos.reader(c.runSeed, i, rl.src)
+	}
+	rl.m.split = c.framing
+	c.relays = append(c.relays, rl)
+	return r, w, nil
+
+}
+
+// rollback kills and reaps the stages started before stage failed, which failed to start, and
+// closes the pipe ends of the others, so nothing of the chain is left behind.
+func (c *Chain) rollback(failed int) {
+
+	for _, s := range c.stages[failed:] {
+		s.closeOwned()
+		if s.warm != nil {
+			s.warm.release()
+		}
+	}
+	c.Drain()
+	for _, s := range c.stages[:failed] {
+		s.kill()
+	}
+	for _, s := range c.stages[:failed] {
+		s.wait()
+	}
+	for _, rl := range c.relays {
+		rl.wait()
+	}
+	c.waitTerminals()
+	c.publishBlobs(false)
+
+}
+
+// release kills the instances of a Pool adopted by the chain, which failed to start.
+func (c *Chain) release() {
+
+	for _, s := range c.stages {
+		if s.warm != nil {
+			s.warm.release()
+		}
+	}
+
+}
+
+// kill kills all running commands of the chain.
+func (c *Chain) kill() {
+
+	atomic.StoreInt32(&c.killed, 1)
+	for _, s := range c.stages {
+		s.kill()
+	}
+
+}
+
+// stderr returns the writer for the stderr of stage i or nil if none is configured.
+func (c *Chain) stderr(i int) io.Writer {
+
+	s := c.stages[i]
+	if i == len(c.stages)-1 && c.Stderr != nil {
+		return c.Stderr
+	}
+
+	if c.StderrFor != nil && s.cmd != nil {
+		if w := c.StderrFor(i, s.cmd.Path); w != nil {
+			if cl, ok := w.(io.Closer); ok {
+				s.closeAfterWait = append(s.closeAfterWait, cl)
+			}
+			return w
+		}
+	}
+
+	return c.Allerr
 
 }
 
 func (c *Chain) start() error {
 
-	var err error
-	for i := 0; i < len(c.cmds)-1; i++ {
+	if c.FastStart {
+		if err := c.prepareFast(); err != nil {
+			c.rollback(0)
+			return errors.Wrap(err, "unable to open the null device")
+		}
+	}
+	if c.coreDir != "" {
+		if err := enableCores(); err != nil {
+			c.rollback(0)
+			return errors.Wrap(err, "unable to enable core dumps")
+		}
+	}
+
+	for _, rl := range c.relays {
+		c.labeled(rl.from, "relay", rl.start)
+	}
+	for i, s := range c.stages {
+		if s.term != nil {
+			c.labeled(i, "terminal", s.term.start)
+		}
+	}
+
+	for i, s := range c.stages {
 
-		err = c.cmds[i].Start()
+		if s.cfn != nil {
+			s.runCtx = c.stageContext(i, s)
+		}
+		s.retry = c.spawnRetries()
+		if c.group && s.cmd != nil && s.warm == nil && s.termSize == nil {
+			c.setGroup(s)
+		}
+		if s.cmd != nil && s.warm == nil {
+			s.applyEnv()
+			s.guardArgs()
+			c.prepareCancel(i, s)
+		}
+		if s.cmd != nil && s.pool == nil {
+			for _, fn := range c.before {
+				fn(i, s.cmd)
+			}
+		}
+
+		c.startSpan(i, s)
+		var err error
+		c.labeled(i, s.role(), func() {
+			err = s.start()
+		})
 		if err != nil {
-			errors.Wrapf(err, "unable to start command #%d (%s)", i, c.cmds[i].Path)
+			c.gate.release(err)
+			c.rollback(i)
+			return c.startError(i, err)
+		}
+		if c.group && s.cmd != nil && s.warm == nil && s.termSize == nil && atomic.LoadInt32(&c.pgid) == 0 {
+			atomic.StoreInt32(&c.pgid, int32(s.cmd.Process.Pid))
+		}
+		if s.listen != "" {
+			if err := c.waitListening(s); err != nil {
+				c.gate.release(err)
+				c.rollback(i + 1)
+				return c.startError(i, errors.Wrap(err, "not listening"))
+			}
 		}
 
 	}
 
+	c.gate.release(nil)
 	return nil
 
 }
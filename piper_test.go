@@ -0,0 +1,116 @@
+package piper_test
+
+import (
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noxer/piper"
+	"github.com/noxer/piper/pipertest"
+)
+
+func TestConformance(t *testing.T) {
+
+	pipertest.Conformance(t, pipertest.Piper)
+
+}
+
+func TestNoLeaks(t *testing.T) {
+
+	for _, name := range []string{"sh", "cat", "seq", "head"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("needs %s", name)
+		}
+	}
+
+	t.Run("failing stage", func(t *testing.T) {
+		pipertest.VerifyNoLeaks(t)
+		if err := piper.Command("seq", "10000").Command("cat").Command("sh", "-c", "exit 3").Run(); err == nil {
+			t.Error("no error")
+		}
+	})
+
+	t.Run("function closing early", func(t *testing.T) {
+		pipertest.VerifyNoLeaks(t)
+		// seq fails with a broken pipe
+		o, _ := piper.Command("seq", "100000").
+			Func(func(r io.Reader, w io.Writer) error { _, err := io.CopyN(w, r, 6); return err }).
+			Command("head", "-n", "1").Output()
+		if string(o) != "1\n" {
+			t.Errorf("got %q", o)
+		}
+	})
+
+	t.Run("failing start", func(t *testing.T) {
+		pipertest.VerifyNoLeaks(t)
+		c := piper.Command("cat").Command("cat").Command("piper-does-not-exist")
+		c.Stdin = strings.NewReader("x")
+		err := c.Run()
+		if _, ok := err.(*piper.StartError); !ok {
+			t.Errorf("got %v, want a StartError", err)
+		}
+	})
+
+}
+
+func TestDerivedSettings(t *testing.T) {
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("needs sh")
+	}
+
+	// newChain returns a chain whose output depends on its settings and which counts the hooks
+	newChain := func(hooks *int) *piper.Chain {
+		c := piper.Command("sh", "-c", `echo "$VAR"`).
+			With(piper.WithTimeout(time.Minute)).
+			BeforeStart(func(int, *exec.Cmd) { *hooks++ })
+		c.Env = []string{"VAR=set"}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		chain func(hooks *int, tap io.Writer) *piper.Chain
+		want  string
+		hooks int
+		taps  bool
+	}{
+		{"original", func(hooks *int, tap io.Writer) *piper.Chain {
+			return newChain(hooks).TapAfter(0, tap)
+		}, "set\n", 1, true},
+		{"clone", func(hooks *int, tap io.Writer) *piper.Chain {
+			return newChain(hooks).TapAfter(0, tap).Clone()
+		}, "set\n", 1, false},
+		{"conditional", func(hooks *int, tap io.Writer) *piper.Chain {
+			return newChain(hooks).And().Command("sh", "-c", `echo "$VAR"`).TapAfter(0, tap)
+		}, "set\nset\n", 2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hooks := 0
+			var tap strings.Builder
+			out, err := tt.chain(&hooks, &tap).Output()
+			if err != nil || string(out) != tt.want {
+				t.Errorf("got %q, %v, want %q", out, err, tt.want)
+			}
+			if hooks != tt.hooks {
+				t.Errorf("ran %d hooks, want %d", hooks, tt.hooks)
+			}
+			// the taps collect the output of the chain they were added to
+			if (tap.String() == "set\n") != tt.taps {
+				t.Errorf("tapped %q", tap.String())
+			}
+		})
+	}
+
+	c := newChain(new(int))
+	c.CombineAll = true
+	out, err := c.Clone().CombinedOutput()
+	if want := "[#0 sh:out] set\n"; err != nil || string(out) != want {
+		t.Errorf("clone with CombineAll: got %q, %v, want %q", out, err, want)
+	}
+
+}
@@ -0,0 +1,202 @@
+// Package pipertest helps testing code built on piper. Conformance checks the guarantees of
+// chains, so alternative implementations and changes to piper can be validated against the same
+// behavior:
+//
+//   - the output of the last stage is the output of the chain
+//   - Start starts the stages in order, they run at the same time, and a stage which can't be
+//     started keeps the stages after it from starting
+//   - the end of the input travels through every stage, so all stages exit once the input ended
+//   - upstream stages terminate when a downstream stage exits early
+//   - a failing stage fails the chain, no matter where it is
+//   - a stage which can't be started fails Start
+//   - once Wait returned, no file descriptors of the chain are left open
+package pipertest
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noxer/piper"
+)
+
+// Timeout is the time a single check of Conformance may take
+var Timeout = 10 * time.Second
+
+// Spec describes the chain a Factory has to create
+type Spec struct {
+	// Commands holds the name and the arguments of every command, in order
+	Commands [][]string
+	// Stdin is the input of the first command, Stdout receives the output of the last one, both may be nil
+	Stdin  io.Reader
+	Stdout io.Writer
+}
+
+// Chain is the part of a piper.Chain checked by Conformance
+type Chain interface {
+	Start() error
+	Wait() error
+}
+
+// Factory creates a chain described by a Spec
+type Factory func(s Spec) Chain
+
+// Piper is the Factory creating piper chains.
+func Piper(s Spec) Chain {
+
+	c := piper.New(piper.WithStdin(s.Stdin), piper.WithStdout(s.Stdout))
+	for _, cmd := range s.Commands {
+		c.Command(cmd[0], cmd[1:]...)
+	}
+	return c
+
+}
+
+// Conformance runs the checks of the package documentation against the chains created by factory.
+// It uses common Unix commands like sh, cat and tr and is skipped on Windows.
+func Conformance(t *testing.T, factory Factory) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("pipertest: the conformance suite needs a Unix environment")
+	}
+	for _, name := range []string{"sh", "cat", "tr", "yes", "head", "true", "false"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("pipertest: %s is not installed", name)
+		}
+	}
+
+	t.Run("Output", func(t *testing.T) {
+		out, err := run(t, factory, Spec{Commands: [][]string{{"echo", "hello"}, {"tr", "a-z", "A-Z"}}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if out != "HELLO\n" {
+			t.Fatalf("got output %q, expected %q", out, "HELLO\n")
+		}
+	})
+
+	t.Run("StartOrder", func(t *testing.T) {
+		// every stage waits for the marker of the other one, so they have to run at the same time
+		dir := t.TempDir()
+		handshake := `touch "$0"; while [ ! -e "$1" ]; do sleep 0.01; done; `
+		a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+		out, err := run(t, factory, Spec{Commands: [][]string{
+			{"sh", "-c", handshake + `echo "$0"`, a, b},
+			{"sh", "-c", handshake + `cat; echo "$0"`, b, a},
+		}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := a + "\n" + b + "\n"; out != want {
+			t.Fatalf("got output %q, expected %q", out, want)
+		}
+
+		late := filepath.Join(dir, "late")
+		c := factory(Spec{Commands: [][]string{{"cat"}, {"/nonexistent/pipertest"}, {"sh", "-c", `touch "$0"`, late}}, Stdin: strings.NewReader("")})
+		if err := c.Start(); err == nil {
+			c.Wait()
+			t.Fatal("starting a missing command didn't fail")
+		}
+		time.Sleep(100 * time.Millisecond)
+		if _, err := os.Stat(late); err == nil {
+			t.Fatal("the stage after the missing command was started")
+		}
+	})
+
+	t.Run("EOFPropagation", func(t *testing.T) {
+		in := strings.Repeat("a line of input\n", 10000)
+		out, err := run(t, factory, Spec{
+			Commands: [][]string{{"cat"}, {"cat"}, {"cat"}},
+			Stdin:    strings.NewReader(in),
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if out != in {
+			t.Fatalf("got %d bytes of output, expected the %d bytes of input", len(out), len(in))
+		}
+	})
+
+	t.Run("EarlyExit", func(t *testing.T) {
+		// the failure of yes, killed by SIGPIPE, is up to the failure policy
+		out, _ := run(t, factory, Spec{Commands: [][]string{{"yes"}, {"head", "-n", "1"}}})
+		if out != "y\n" {
+			t.Fatalf("got output %q, expected %q", out, "y\n")
+		}
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		for _, cmds := range [][][]string{
+			{{"false"}, {"cat"}},
+			{{"echo"}, {"false"}, {"cat"}},
+			{{"echo"}, {"false"}},
+		} {
+			if _, err := run(t, factory, Spec{Commands: cmds}); err == nil {
+				t.Errorf("%v didn't fail", cmds)
+			}
+		}
+		if _, err := run(t, factory, Spec{Commands: [][]string{{"true"}, {"true"}}}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("StartFailure", func(t *testing.T) {
+		c := factory(Spec{Commands: [][]string{{"cat"}, {"/nonexistent/pipertest"}}, Stdin: strings.NewReader("")})
+		if err := c.Start(); err == nil {
+			c.Wait()
+			t.Fatal("starting a missing command didn't fail")
+		}
+	})
+
+	t.Run("Cleanup", func(t *testing.T) {
+		if _, err := os.ReadDir("/proc/self/fd"); err != nil {
+			t.Skip("pipertest: open files can't be counted without /proc")
+		}
+		before := openFiles()
+		for i := 0; i < 10; i++ {
+			run(t, factory, Spec{Commands: [][]string{{"echo", "x"}, {"cat"}, {"false"}}, Stdin: strings.NewReader("x")})
+		}
+		if after := openFiles(); after > before {
+			t.Fatalf("%d file descriptors were left open", after-before)
+		}
+	})
+
+}
+
+// run runs the chain described by s and returns its output. It fails the test if the chain
+// doesn't finish within Timeout.
+func run(t *testing.T, factory Factory, s Spec) (string, error) {
+
+	t.Helper()
+
+	var out bytes.Buffer
+	s.Stdout = &out
+	c := factory(s)
+	if err := c.Start(); err != nil {
+		return "", err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.Wait() }()
+	select {
+	case err := <-done:
+		return out.String(), err
+	case <-time.After(Timeout):
+		t.Fatalf("%v didn't finish within %v", s.Commands, Timeout)
+		return "", nil
+	}
+
+}
+
+func openFiles() int {
+
+	fds, _ := os.ReadDir("/proc/self/fd")
+	return len(fds)
+
+}
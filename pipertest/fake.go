@@ -0,0 +1,120 @@
+package pipertest
+
+import (
+	"sync"
+
+	"github.com/noxer/piper"
+	"github.com/pkg/errors"
+)
+
+// ErrKilled is the error of Fake.Wait if the fake was killed while it hung
+var ErrKilled = errors.New("pipertest: fake killed")
+
+// Fake is a piper.Runner which runs no processes. It reports the configured outcome, so code
+// depending on a piper.Runner can be tested without the commands. A Fake can be run once.
+type Fake struct {
+	// Stdout is returned by Output, StartErr by Start and Err by Wait and Output
+	Stdout   []byte
+	StartErr error
+	Err      error
+	// Hang makes Wait block until the fake is killed, it then returns ErrKilled
+	Hang bool
+
+	mu      sync.Mutex
+	started bool
+	waited  bool
+	killed  bool
+	kill    chan struct{}
+}
+
+var _ piper.Runner = (*Fake)(nil)
+
+// Start starts the fake, it fails with StartErr.
+func (f *Fake) Start() error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.started {
+		return errors.New("pipertest: fake already started")
+	}
+	if f.StartErr != nil {
+		return f.StartErr
+	}
+	f.started = true
+	f.kill = make(chan struct{})
+	return nil
+
+}
+
+// Wait returns Err once the fake was started, see Hang.
+func (f *Fake) Wait() error {
+
+	f.mu.Lock()
+	if !f.started {
+		f.mu.Unlock()
+		return errors.New("pipertest: fake not started")
+	}
+	if f.waited {
+		f.mu.Unlock()
+		return errors.New("pipertest: Wait was already called")
+	}
+	f.waited = true
+	hang, kill := f.Hang, f.kill
+	f.mu.Unlock()
+
+	if hang {
+		<-kill
+		return ErrKilled
+	}
+	return f.Err
+
+}
+
+// Output runs the fake and returns Stdout and the error of Wait.
+func (f *Fake) Output() ([]byte, error) {
+
+	if err := f.Start(); err != nil {
+		return nil, err
+	}
+	err := f.Wait()
+	return f.Stdout, err
+
+}
+
+// Kill kills the started fake, a hanging Wait returns.
+func (f *Fake) Kill() error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.started {
+		return errors.New("pipertest: fake not started")
+	}
+	if !f.killed {
+		f.killed = true
+		close(f.kill)
+	}
+	return nil
+
+}
+
+// Started reports whether the fake was started.
+func (f *Fake) Started() bool {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.started
+
+}
+
+// Killed reports whether the fake was killed.
+func (f *Fake) Killed() bool {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.killed
+
+}
@@ -0,0 +1,164 @@
+package pipertest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// LeakTimeout is how long VerifyNoLeaks waits for the resources of a test to be released
+var LeakTimeout = 5 * time.Second
+
+// VerifyNoLeaks fails t if the test leaves file descriptors, goroutines or child processes
+// behind. It takes a snapshot when called and compares it to the state at the end of the test,
+// giving background cleanups LeakTimeout to finish. File descriptors and child processes are
+// only tracked on Linux. Tests using it must not run in parallel.
+func VerifyNoLeaks(t testing.TB) {
+
+	t.Helper()
+
+	before := takeSnapshot()
+	t.Cleanup(func() {
+
+		deadline := time.Now().Add(LeakTimeout)
+		for {
+			leaks := takeSnapshot().leaks(before)
+			if len(leaks) == 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("pipertest: the test leaked:\n%s", strings.Join(leaks, "\n"))
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+	})
+
+}
+
+// snapshot holds the resources of the process, keyed by an identifier
+type snapshot struct {
+	fds        map[string]string
+	goroutines map[string]string
+	children   map[string]string
+}
+
+func takeSnapshot() snapshot {
+
+	return snapshot{fds: openFDs(), goroutines: goroutines(), children: children()}
+
+}
+
+// leaks describes the resources of s missing from before.
+func (s snapshot) leaks(before snapshot) []string {
+
+	var l []string
+	for _, kind := range []struct {
+		name      string
+		now, then map[string]string
+	}{
+		{"file descriptor", s.fds, before.fds},
+		{"child process", s.children, before.children},
+		{"goroutine", s.goroutines, before.goroutines},
+	} {
+		for id, desc := range kind.now {
+			if _, ok := kind.then[id]; !ok {
+				l = append(l, fmt.Sprintf("%s %s: %s", kind.name, id, desc))
+			}
+		}
+	}
+	sort.Strings(l)
+	return l
+
+}
+
+// openFDs maps the open file descriptors to their targets.
+func openFDs() map[string]string {
+
+	m := map[string]string{}
+	ents, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return m
+	}
+	for _, e := range ents {
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", e.Name()))
+		if err != nil || target == os.DevNull {
+			// the descriptor of the directory listing itself, and the null device piper keeps
+			// open for Chain.FastStart
+			continue
+		}
+		m[e.Name()] = target
+	}
+	return m
+
+}
+
+// goroutines maps the IDs of the goroutines to their stacks. Goroutines the runtime starts on
+// demand and keeps, like the one delivering signals, are left out.
+func goroutines() map[string]string {
+
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	m := map[string]string{}
+	for _, g := range bytes.Split(buf, []byte("\n\n")) {
+		header, stack, _ := strings.Cut(string(g), "\n")
+		id, ok := strings.CutPrefix(header, "goroutine ")
+		if !ok || strings.Contains(stack, "os/signal.loop") || strings.Contains(stack, "pipertest.goroutines") {
+			continue
+		}
+		id, _, _ = strings.Cut(id, " ")
+		m[id] = header + "\n" + stack
+	}
+	return m
+
+}
+
+// children maps the PIDs of the child processes, including zombies, to their names.
+func children() map[string]string {
+
+	m := map[string]string{}
+	ents, err := os.ReadDir("/proc")
+	if err != nil {
+		return m
+	}
+	self := strconv.Itoa(os.Getpid())
+	for _, e := range ents {
+
+		if _, err := strconv.Atoi(e.Name()); err != nil {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join("/proc", e.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// pid (comm) state ppid ..., the name may contain spaces and parentheses
+		i := bytes.LastIndexByte(b, ')')
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(string(b[i+1:]))
+		if len(fields) < 2 || fields[1] != self {
+			continue
+		}
+		m[e.Name()] = string(b[bytes.IndexByte(b, '(')+1:i]) + " (state " + fields[0] + ")"
+
+	}
+	return m
+
+}
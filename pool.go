@@ -0,0 +1,233 @@
+package piper
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+)
+
+// Pool keeps instances of a slow starting command, e.g. an interpreter, started ahead of time.
+// The instances wait for their input on stdin until a chain adopts one, which saves the startup
+// time of the command. The pool starts a replacement for every adopted instance.
+type Pool struct {
+	newCmd func() *exec.Cmd
+	idle   chan *warmProc
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewPool keeps size instances of the command started, see exec.Command.
+func NewPool(size int, name string, arg ...string) *Pool {
+
+	return NewPoolCmd(size, func() *exec.Cmd {
+		return exec.Command(name, arg...)
+	})
+
+}
+
+// NewPoolCmd keeps size instances of the commands returned by newCmd started. The Stdin, Stdout
+// and Stderr of the commands must not be set.
+func NewPoolCmd(size int, newCmd func() *exec.Cmd) *Pool {
+
+	p := &Pool{newCmd: newCmd, idle: make(chan *warmProc, size)}
+	for i := 0; i < size; i++ {
+		go p.spawn()
+	}
+	return p
+
+}
+
+// Command creates a new Chain whose first stage is an instance of the pool. If no started instance
+// is available, a new one is started when the chain is started. Only the Stdin, Stdout and the
+// stderr of the chain apply to the instance, it was configured when it was started. The Env and
+// Dir of the chain, Configure and BeforeStart don't apply to it, whether it was started ahead of
+// time or not. The instance is killed if the chain fails to start or is never started, the latter
+// once the chain is garbage collected.
+func (p *Pool) Command() *Chain {
+
+	select {
+	case w := <-p.idle:
+		go p.spawn()
+		runtime.SetFinalizer(w, (*warmProc).release)
+		return newChain(&stage{cmd: w.cmd, warm: w, pool: p})
+	default:
+		return newChain(&stage{cmd: p.newCmd(), pool: p})
+	}
+
+}
+
+// Close kills the instances waiting for a chain. Chains created by Command are unaffected.
+func (p *Pool) Close() error {
+
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	for {
+		select {
+		case w := <-p.idle:
+			w.release()
+		default:
+			return nil
+		}
+	}
+
+}
+
+// spawn starts an instance and adds it to the idle ones. Instances which fail to start are
+// dropped, chains then fall back to starting the command themselves.
+func (p *Pool) spawn() {
+
+	w, err := startWarm(p.newCmd())
+	if err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.closed {
+		select {
+		case p.idle <- w:
+			return
+		default:
+		}
+	}
+	go w.release()
+
+}
+
+// warmProc is a started command waiting to be adopted by a chain. The output of the process is
+// copied to the writers of the chain and the input of the chain to the process.
+type warmProc struct {
+	cmd *exec.Cmd
+
+	// the parent ends of the standard streams of the process
+	stdin  *os.File
+	stdout *os.File
+	stderr *os.File
+
+	in     io.Reader
+	out    io.Writer
+	errw   io.Writer
+	piped  [3]bool
+	copies sync.WaitGroup
+
+	released sync.Once
+}
+
+func startWarm(cmd *exec.Cmd) (*warmProc, error) {
+
+	w := &warmProc{cmd: cmd}
+	var child [3]*os.File
+	var err error
+
+	closeAll := func() {
+		for _, f := range append(child[:], w.stdin, w.stdout, w.stderr) {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}
+
+	if child[0], w.stdin, err = os.Pipe(); err != nil {
+		closeAll()
+		return nil, err
+	}
+	if w.stdout, child[1], err = os.Pipe(); err != nil {
+		closeAll()
+		return nil, err
+	}
+	if w.stderr, child[2], err = os.Pipe(); err != nil {
+		closeAll()
+		return nil, err
+	}
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = child[0], child[1], child[2]
+	err = startCmd(cmd, nil)
+	for _, f := range child {
+		f.Close()
+	}
+	if err != nil {
+		w.closeParent()
+		return nil, err
+	}
+	return w, nil
+
+}
+
+// release kills the process of an instance which wasn't started by a chain.
+func (w *warmProc) release() {
+
+	w.released.Do(func() {
+		runtime.SetFinalizer(w, nil)
+		w.cmd.Process.Kill()
+		w.cmd.Wait()
+		w.closeParent()
+	})
+
+}
+
+func (w *warmProc) closeParent() {
+
+	w.stdin.Close()
+	w.stdout.Close()
+	w.stderr.Close()
+
+}
+
+// start connects the process to the chain, owned is closed once the output was copied.
+func (w *warmProc) start(owned func()) {
+
+	runtime.SetFinalizer(w, nil)
+
+	if !w.piped[0] {
+		go func() {
+			if w.in != nil {
+				// a write error means the process stopped reading, like for any command
+				io.Copy(w.stdin, w.in)
+			}
+			w.stdin.Close()
+		}()
+	}
+
+	if !w.piped[1] {
+		w.copies.Add(1)
+		go func() {
+			defer w.copies.Done()
+			w.copy(w.out, w.stdout)
+			owned()
+		}()
+	} else {
+		owned()
+	}
+
+	if !w.piped[2] {
+		w.copies.Add(1)
+		go func() {
+			defer w.copies.Done()
+			w.copy(w.errw, w.stderr)
+		}()
+	}
+
+}
+
+func (w *warmProc) copy(dst io.Writer, src *os.File) {
+
+	if dst == nil {
+		dst = io.Discard
+	}
+	io.Copy(dst, src)
+	src.Close()
+
+}
+
+// wait waits for the output of the process to be copied.
+func (w *warmProc) wait() {
+
+	w.copies.Wait()
+
+}
@@ -0,0 +1,167 @@
+package piper_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/noxer/piper"
+)
+
+// pidPool starts instances of cat which record their pid in the file pids
+func pidPool(t *testing.T, size int) (*piper.Pool, string) {
+
+	t.Helper()
+
+	if _, err := exec.LookPath("sh"); err != nil || runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	pids := filepath.Join(t.TempDir(), "pids")
+	p := piper.NewPool(size, "sh", "-c", `echo $$ >> "$0"; exec cat`, pids)
+	t.Cleanup(func() { p.Close() })
+
+	// wait for the instances to be started
+	deadline := time.Now().Add(5 * time.Second)
+	for len(readPids(pids)) < size && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	return p, pids
+
+}
+
+func readPids(file string) []int {
+
+	b, _ := os.ReadFile(file)
+	var pids []int
+	for _, f := range strings.Fields(string(b)) {
+		if pid, err := strconv.Atoi(f); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+
+}
+
+// alive returns the pids of the file which are still running
+func alive(file string) []int {
+
+	var running []int
+	for _, pid := range readPids(file) {
+		proc, err := os.FindProcess(pid)
+		if err == nil && proc.Signal(syscall.Signal(0)) == nil {
+			running = append(running, pid)
+		}
+	}
+	return running
+
+}
+
+func TestPoolRelease(t *testing.T) {
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name  string
+		chain func(p *piper.Pool) *piper.Chain
+	}{
+		{"failing later stage", func(p *piper.Pool) *piper.Chain {
+			return p.Command().Command("piper-no-such-command")
+		}},
+		{"failing link", func(p *piper.Pool) *piper.Chain {
+			return p.Command().PipeStreams(piper.BothStreams)
+		}},
+		{"canceled context", func(p *piper.Pool) *piper.Chain {
+			return p.Command().With(piper.WithContext(canceled))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, pids := pidPool(t, 1)
+			c := tt.chain(p)
+			p.Close()
+
+			if err := c.Start(); err == nil {
+				c.Wait()
+				t.Fatal("chain started")
+			}
+			waitDead(t, pids)
+		})
+	}
+
+}
+
+func TestPoolNeverStarted(t *testing.T) {
+
+	p, pids := pidPool(t, 1)
+	p.Command()
+	p.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(alive(pids)) > 0 && time.Now().Before(deadline) {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	waitDead(t, pids)
+
+}
+
+// waitDead fails unless all instances of the pool are gone
+func waitDead(t *testing.T, pids string) {
+
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(alive(pids)) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if running := alive(pids); len(running) > 0 {
+		t.Errorf("instances %v still running", running)
+	}
+
+}
+
+func TestPoolConfig(t *testing.T) {
+
+	if _, err := exec.LookPath("sh"); err != nil || runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+
+	dir := t.TempDir()
+	for _, size := range []int{0, 1} {
+		p := piper.NewPoolCmd(size, func() *exec.Cmd {
+			cmd := exec.Command("sh", "-c", `cat; echo "$POOL_VAR"; pwd`)
+			cmd.Env = append(os.Environ(), "POOL_VAR=pool")
+			cmd.Dir = dir
+			return cmd
+		})
+		defer p.Close()
+		time.Sleep(50 * time.Millisecond)
+
+		hooks := 0
+		c := p.Command().Configure(piper.StageEnv("POOL_VAR=stage"), piper.StageDir("/")).
+			BeforeStart(func(int, *exec.Cmd) { hooks++ })
+		c.Env = []string{"POOL_VAR=chain"}
+		c.Dir = "/"
+		c.Stdin = strings.NewReader("in\n")
+
+		out, err := c.Output()
+		real, _ := filepath.EvalSymlinks(dir)
+		if want := "in\npool\n" + real + "\n"; err != nil || string(out) != want {
+			t.Errorf("size %d: got %q, %v, want %q", size, out, err, want)
+		}
+		if hooks != 0 {
+			t.Errorf("size %d: ran %d hooks", size, hooks)
+		}
+	}
+
+}
@@ -0,0 +1,162 @@
+package piper
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Ports holds free TCP ports reserved for the stages of chains, e.g. a temporary server stage and
+// a client stage querying it. Arguments and environment variables of the commands refer to them
+// with placeholders like "{port:web}", see WithPorts.
+type Ports struct {
+	ports map[string]int
+
+	once      sync.Once
+	listeners []net.Listener
+}
+
+// ReservePorts reserves a free port on the loopback interface for every name. The ports are held
+// until a chain using them starts, then another process could take them before the stage binds them.
+func ReservePorts(names ...string) (*Ports, error) {
+
+	p := &Ports{ports: map[string]int{}}
+	for _, name := range names {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			p.release()
+			return nil, errors.Wrapf(err, "unable to reserve a port for %s", name)
+		}
+		p.listeners = append(p.listeners, l)
+		p.ports[name] = l.Addr().(*net.TCPAddr).Port
+	}
+	return p, nil
+
+}
+
+// Port returns the port reserved for name, zero if there is none.
+func (p *Ports) Port(name string) int {
+
+	return p.ports[name]
+
+}
+
+// Expand replaces the placeholders "{port:name}" in s with the ports.
+func (p *Ports) Expand(s string) string {
+
+	if !strings.Contains(s, "{port:") {
+		return s
+	}
+	for name, port := range p.ports {
+		s = strings.ReplaceAll(s, "{port:"+name+"}", strconv.Itoa(port))
+	}
+	return s
+
+}
+
+// WaitListen waits until something accepts connections on the port of name.
+func (p *Ports) WaitListen(name string, timeout time.Duration) error {
+
+	port, ok := p.ports[name]
+	if !ok {
+		return errors.Errorf("piper: no port reserved for %s", name)
+	}
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Until(deadline))
+		if err == nil {
+			return conn.Close()
+		}
+		if time.Now().After(deadline) {
+			return errors.Errorf("piper: nothing listens on port %d (%s) after %v", port, name, timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+}
+
+// release closes the listeners holding the ports, so the stages can bind them.
+func (p *Ports) release() {
+
+	p.once.Do(func() {
+		for _, l := range p.listeners {
+			l.Close()
+		}
+	})
+
+}
+
+// WithPorts replaces the placeholders of p in the arguments and the environment of the commands
+// when the chain starts.
+func WithPorts(p *Ports) Option {
+
+	return func(c *Chain) { c.ports = p }
+
+}
+
+// Listening makes Start wait until the last added command accepts connections on the port of
+// name, before the next stage is started. Start fails if it doesn't within timeout. It requires
+// WithPorts.
+func (c *Chain) Listening(name string, timeout time.Duration) *Chain {
+
+	s := c.last()
+	s.listen = name
+	s.listenTimeout = timeout
+	return c
+
+}
+
+// expandPorts replaces the port placeholders of the commands. The arguments and the environment
+// are copied before, they are shared with the clones of the chain.
+func (c *Chain) expandPorts() {
+
+	if c.ports == nil {
+		return
+	}
+	c.ports.release()
+
+	for _, s := range c.stages {
+		if s.cmd == nil {
+			continue
+		}
+		s.cmd.Args = expandAll(c.ports, s.cmd.Args)
+		s.cmd.Env = expandAll(c.ports, s.cmd.Env)
+	}
+
+}
+
+// expandAll returns l with the placeholders replaced, l itself if it has none.
+func expandAll(p *Ports, l []string) []string {
+
+	var out []string
+	for i, s := range l {
+		e := p.Expand(s)
+		if e != s && out == nil {
+			out = append([]string(nil), l...)
+		}
+		if out != nil {
+			out[i] = e
+		}
+	}
+	if out == nil {
+		return l
+	}
+	return out
+
+}
+
+// waitListening waits for stage s to listen, see Listening.
+func (c *Chain) waitListening(s *stage) error {
+
+	if c.ports == nil {
+		return errors.New("piper: Listening requires WithPorts")
+	}
+	return c.ports.WaitListen(s.listen, s.listenTimeout)
+
+}
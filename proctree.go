@@ -0,0 +1,78 @@
+package piper
+
+import (
+	"sync/atomic"
+)
+
+// Process is a process of the tree of a stage, see Chain.ProcessTree
+type Process struct {
+	PID  int
+	PPID int
+	// Name is the name of the executable as reported by the OS
+	Name     string
+	Children []*Process
+}
+
+// Descendants returns the PIDs of all children and grandchildren of p, parents first.
+func (p *Process) Descendants() []int {
+
+	var pids []int
+	for _, ch := range p.Children {
+		pids = append(pids, ch.PID)
+		pids = append(pids, ch.Descendants()...)
+	}
+	return pids
+
+}
+
+// ProcessTree returns the process of every running command of the chain with the processes it
+// spawned, e.g. the compilers invoked by make, so cleanup and resource accounting can include
+// them. The entry of a function stage or a command which isn't running is nil. It is supported
+// on Linux, through /proc, and on Windows.
+func (c *Chain) ProcessTree() ([]*Process, error) {
+
+	procs, err := listProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	children := map[int][]*Process{}
+	for _, p := range procs {
+		children[p.PPID] = append(children[p.PPID], p)
+	}
+
+	tree := make([]*Process, len(c.stages))
+	for i, s := range c.stages {
+
+		pid := int(atomic.LoadInt32(&s.pid))
+		if s.cmd == nil || pid == 0 || atomic.LoadInt32(&s.status) != stageRunning {
+			continue
+		}
+		for _, p := range procs {
+			if p.PID == pid {
+				tree[i] = p
+				break
+			}
+		}
+		if tree[i] != nil {
+			adopt(tree[i], children, map[int]bool{})
+		}
+
+	}
+	return tree, nil
+
+}
+
+// adopt attaches the children of p and their descendants, seen guards against PID reuse cycles.
+func adopt(p *Process, children map[int][]*Process, seen map[int]bool) {
+
+	seen[p.PID] = true
+	for _, ch := range children[p.PID] {
+		if seen[ch.PID] {
+			continue
+		}
+		p.Children = append(p.Children, ch)
+		adopt(ch, children, seen)
+	}
+
+}
@@ -0,0 +1,47 @@
+package piper
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// listProcesses reads the processes of the system from /proc.
+func listProcesses() ([]*Process, error) {
+
+	ents, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list the processes")
+	}
+
+	var procs []*Process
+	for _, e := range ents {
+
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		// processes may exit while they are listed
+		b, err := os.ReadFile("/proc/" + e.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		// pid (comm) state ppid ..., the name may contain spaces and parentheses
+		open, end := bytes.IndexByte(b, '('), bytes.LastIndexByte(b, ')')
+		if open < 0 || end < open {
+			continue
+		}
+		fields := strings.Fields(string(b[end+1:]))
+		if len(fields) < 2 {
+			continue
+		}
+		ppid, _ := strconv.Atoi(fields[1])
+		procs = append(procs, &Process{PID: pid, PPID: ppid, Name: string(b[open+1 : end])})
+
+	}
+	return procs, nil
+
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+
+package piper
+
+import (
+	"github.com/pkg/errors"
+)
+
+// listProcesses is only supported on Linux and Windows.
+func listProcesses() ([]*Process, error) {
+
+	return nil, errors.New("piper: process trees are not supported on this platform")
+
+}
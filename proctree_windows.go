@@ -0,0 +1,34 @@
+package piper
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// listProcesses takes a toolhelp snapshot of the processes of the system.
+func listProcesses() ([]*Process, error) {
+
+	snap, err := syscall.CreateToolhelp32Snapshot(syscall.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list the processes")
+	}
+	defer syscall.CloseHandle(snap)
+
+	var e syscall.ProcessEntry32
+	e.Size = uint32(unsafe.Sizeof(e))
+	var procs []*Process
+	for err = syscall.Process32First(snap, &e); err == nil; err = syscall.Process32Next(snap, &e) {
+		procs = append(procs, &Process{
+			PID:  int(e.ProcessID),
+			PPID: int(e.ParentProcessID),
+			Name: syscall.UTF16ToString(e.ExeFile[:]),
+		})
+	}
+	if err != syscall.ERROR_NO_MORE_FILES {
+		return nil, errors.Wrap(err, "unable to list the processes")
+	}
+	return procs, nil
+
+}
@@ -0,0 +1,117 @@
+package piper
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+)
+
+// Profile holds defaults shared by many chains, e.g. a hardened configuration of a service. The
+// chains created from it inherit its settings and can still override them. A Profile can be used
+// concurrently as long as it isn't modified, the chains share its Env slice.
+type Profile struct {
+	// Name is the Name of the chain, e.g. the ID of the request it serves, unless it is empty
+	Name string
+	// Env and Dir are the Env and Dir of the chain, see WithEnv and WithDir
+	Env []string
+	Dir string
+	// Stderr, Allerr and StderrExcerpt set the fields of the chain of the same name
+	Stderr        io.Writer
+	Allerr        io.Writer
+	StderrExcerpt int
+	// Timeout is the timeout of the chain, see WithTimeout
+	Timeout time.Duration
+	Logger  *log.Logger
+	Policy  Policy
+	// Options are applied after the fields, e.g. for settings without a field
+	Options []Option
+}
+
+// profileKey is the context key of the profile stored by NewContext
+type profileKey struct{}
+
+// NewContext returns a copy of parent carrying p, e.g. with the logger and the policy of a
+// request. CommandContext and CommandContextArgs apply it to the chains they create.
+func NewContext(parent context.Context, p Profile) context.Context {
+
+	return context.WithValue(parent, profileKey{}, p)
+
+}
+
+// FromContext returns the profile stored in ctx by NewContext.
+func FromContext(ctx context.Context) (Profile, bool) {
+
+	p, ok := ctx.Value(profileKey{}).(Profile)
+	return p, ok
+
+}
+
+// withContextProfile applies the profile stored in ctx to c, if there is one.
+func (c *Chain) withContextProfile(ctx context.Context) *Chain {
+
+	if p, ok := FromContext(ctx); ok {
+		c.With(p.Option())
+	}
+	return c
+
+}
+
+// Option returns an Option applying the profile to a chain.
+func (p Profile) Option() Option {
+
+	return func(c *Chain) {
+		if p.Name != "" {
+			c.Name = p.Name
+		}
+		c.Env = p.Env
+		c.Dir = p.Dir
+		c.Stderr = p.Stderr
+		c.Allerr = p.Allerr
+		c.StderrExcerpt = p.StderrExcerpt
+		c.timeout = p.Timeout
+		c.Logger = p.Logger
+		c.policy = p.Policy
+		c.With(p.Options...)
+	}
+
+}
+
+// New creates an empty chain with the settings of the profile, opts are applied after them.
+func (p Profile) New(opts ...Option) *Chain {
+
+	return New(append([]Option{p.Option()}, opts...)...)
+
+}
+
+// Command creates a new Chain with the settings of the profile and the provided command as the
+// first command, see Command.
+func (p Profile) Command(name string, arg ...string) *Chain {
+
+	return p.New().Command(name, arg...)
+
+}
+
+// CommandContext creates a new Chain with the settings of the profile and the provided command
+// as the first command, see CommandContext.
+func (p Profile) CommandContext(ctx context.Context, name string, arg ...string) *Chain {
+
+	return p.New().CommandContext(ctx, name, arg...)
+
+}
+
+// Shell creates a new Chain with the settings of the profile running script with the platform
+// shell, see Shell.
+func (p Profile) Shell(script string, opts ...ShellOption) *Chain {
+
+	return p.New().Shell(script, opts...)
+
+}
+
+// Func creates a new Chain with the settings of the profile and the in-process stage fn as the
+// first stage, see Func.
+func (p Profile) Func(fn StageFunc) *Chain {
+
+	return p.New().Func(fn)
+
+}
@@ -0,0 +1,54 @@
+package piper
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// newPtyRelay creates a PtyLink from stage from to the next one. The stage writes to the terminal
+// side of a pseudo terminal, the relay copies its output to a pipe read by the next stage. The
+// relay turns the hangup of the terminal into EOF, which a command reading the pty directly
+// would see as an error.
+func newPtyRelay(from int, taps []io.Writer) (r *relay, upstream, downstream *os.File, err error) {
+
+	master, upstream, err := openPty()
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "unable to open a pty")
+	}
+	downstream, dst, err := os.Pipe()
+	if err != nil {
+		master.Close()
+		upstream.Close()
+		return nil, nil, nil, err
+	}
+
+	r = &relay{
+		from: from,
+		src:  master,
+		dst:  dst,
+		in:   ptyReader{master},
+		m:    &meter{w: io.MultiWriter(append([]io.Writer{dst}, taps...)...)},
+		done: make(chan struct{}),
+	}
+	return r, upstream, downstream, nil
+
+}
+
+// ptyReader reads from the controlling side of a pty, the hangup after the terminal side was
+// closed by all processes reads as EOF.
+type ptyReader struct {
+	f *os.File
+}
+
+func (p ptyReader) Read(b []byte) (int, error) {
+
+	n, err := p.f.Read(b)
+	if pe, ok := err.(*os.PathError); ok && pe.Err == syscall.EIO {
+		err = io.EOF
+	}
+	return n, err
+
+}
@@ -0,0 +1,121 @@
+package piper
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// opost enables the output processing of a terminal, e.g. turning "\n" into "\r\n"
+const opost = 0x1
+
+// openPty opens a pseudo terminal. The terminal side is raw, so the output passes unchanged.
+func openPty() (master, slave *os.File, err error) {
+
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var n, unlock uint32
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, nil, os.NewSyscallError("ioctl TIOCSPTLCK", err)
+	}
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, nil, os.NewSyscallError("ioctl TIOCGPTN", err)
+	}
+
+	slave, err = os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	var t syscall.Termios
+	err = ioctl(slave, syscall.TCGETS, unsafe.Pointer(&t))
+	if err == nil {
+		t.Oflag &^= opost
+		err = ioctl(slave, syscall.TCSETS, unsafe.Pointer(&t))
+	}
+	if err != nil {
+		master.Close()
+		slave.Close()
+		return nil, nil, os.NewSyscallError("ioctl TCSETS", err)
+	}
+	return master, slave, nil
+
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+
+}
+
+// openTerminal opens a pseudo terminal of size for a command, see Chain.Terminal. It returns the
+// end of file character of the terminal.
+func openTerminal(size WindowSize) (master, slave *os.File, eof byte, err error) {
+
+	master, slave, err = openPty()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var t syscall.Termios
+	err = ioctl(slave, syscall.TCGETS, unsafe.Pointer(&t))
+	if err == nil {
+		t.Lflag &^= syscall.ECHO
+		err = ioctl(slave, syscall.TCSETS, unsafe.Pointer(&t))
+	}
+	if err == nil {
+		err = setWindowSize(master, size)
+	}
+	if err != nil {
+		master.Close()
+		slave.Close()
+		return nil, nil, 0, os.NewSyscallError("ioctl", err)
+	}
+	return master, slave, t.Cc[syscall.VEOF], nil
+
+}
+
+// setWindowSize sets the size of the terminal of the pty master.
+func setWindowSize(master *os.File, size WindowSize) error {
+
+	ws := [4]uint16{size.Rows, size.Cols}
+	return ioctl(master, syscall.TIOCSWINSZ, unsafe.Pointer(&ws))
+
+}
+
+// setControllingTerminal starts cmd in a new session with its stdin as the controlling terminal.
+func setControllingTerminal(cmd *exec.Cmd) {
+
+	attr := &syscall.SysProcAttr{}
+	if cmd.SysProcAttr != nil {
+		// the attributes are shared with the clones of the chain
+		*attr = *cmd.SysProcAttr
+	}
+	attr.Setsid = true
+	attr.Setctty = true
+	attr.Ctty = 0
+	cmd.SysProcAttr = attr
+
+}
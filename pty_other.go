@@ -0,0 +1,34 @@
+//go:build !linux
+
+package piper
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// openPty is only supported on Linux.
+func openPty() (master, slave *os.File, err error) {
+
+	return nil, nil, errors.New("piper: pty links are not supported on this platform")
+
+}
+
+// openTerminal is only supported on Linux.
+func openTerminal(size WindowSize) (master, slave *os.File, eof byte, err error) {
+
+	return nil, nil, 0, errors.New("piper: terminals are not supported on this platform")
+
+}
+
+// setWindowSize is only supported on Linux.
+func setWindowSize(master *os.File, size WindowSize) error {
+
+	return errors.New("piper: terminals are not supported on this platform")
+
+}
+
+// setControllingTerminal does nothing, terminals are only supported on Linux.
+func setControllingTerminal(cmd *exec.Cmd) {}
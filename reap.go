@@ -0,0 +1,73 @@
+package piper
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrStuck is the cause of the error of WaitTimeout if stages kept running after the kill
+var ErrStuck = errors.New("piper: stages still running after the kill")
+
+// Cleanup describes the stages WaitTimeout had to clean up
+type Cleanup struct {
+	// Killed holds the indices of the commands killed because they didn't exit in time
+	Killed []int
+	// Stuck holds the indices of the stages still running after the kill. Functions can't be
+	// killed, they are left running and Wait keeps running in the background.
+	Stuck []int
+}
+
+// Clean reports whether all stages exited in time.
+func (cl *Cleanup) Clean() bool {
+
+	return len(cl.Killed) == 0 && len(cl.Stuck) == 0
+
+}
+
+// WaitTimeout works like Wait but kills the commands which didn't exit within d and reaps them,
+// so a stuck command never leaks. It returns the error of Wait and what had to be cleaned up. If
+// stages are still running d after the kill, it gives up and returns an error with the cause
+// ErrStuck.
+func (c *Chain) WaitTimeout(d time.Duration) (*Cleanup, error) {
+
+	done := make(chan error, 1)
+	c.labeled(-1, "wait", func() {
+		go func() { done <- c.Wait() }()
+	})
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	cl := &Cleanup{}
+	select {
+	case err := <-done:
+		return cl, err
+	case <-t.C:
+	}
+
+	for i, s := range c.stages {
+		if atomic.LoadInt32(&s.status) != stageRunning || s.cmd == nil {
+			continue
+		}
+		if s.kill() == nil {
+			cl.Killed = append(cl.Killed, i)
+		}
+	}
+
+	t.Reset(d)
+	select {
+	case err := <-done:
+		return cl, err
+	case <-t.C:
+	}
+
+	for i, s := range c.stages {
+		if atomic.LoadInt32(&s.status) == stageRunning {
+			cl.Stuck = append(cl.Stuck, i)
+		}
+	}
+	return cl, errors.Wrapf(ErrStuck, "stages %v didn't exit %v after the kill", cl.Stuck, d)
+
+}
@@ -0,0 +1,80 @@
+package piper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// RecordSink publishes single records, e.g. to a message bus. Drivers are in sub-packages.
+type RecordSink interface {
+	Publish(ctx context.Context, record []byte) error
+}
+
+// RecordSource delivers single records, e.g. from a subscription.
+// Next returns io.EOF when there are no more records.
+type RecordSource interface {
+	Next(ctx context.Context) ([]byte, error)
+}
+
+// Publish adds a stage to the back of the chain which publishes every record it reads to sink.
+// Records are split with split, nil splits the stream into lines. Records are limited to DefaultMaxRecord bytes.
+func (c *Chain) Publish(ctx context.Context, sink RecordSink, split bufio.SplitFunc) *Chain {
+
+	if split == nil {
+		split = bufio.ScanLines
+	}
+
+	return c.Func(func(r io.Reader, _ io.Writer) error {
+
+		sc := NewScanner(r, split, 0)
+		for sc.Scan() {
+
+			err := sink.Publish(ctx, sc.Bytes())
+			if err != nil {
+				return errors.Wrap(err, "unable to publish record")
+			}
+
+		}
+
+		return errors.Wrap(sc.Err(), "unable to read record")
+
+	})
+
+}
+
+// Subscribe creates a new Chain whose first stage writes every record of src, each followed by a newline.
+// The stage ends when src returns io.EOF or ctx is done. A record containing a newline would be split
+// into several lines downstream, it fails the stage instead.
+func Subscribe(ctx context.Context, src RecordSource) *Chain {
+
+	return Func(func(_ io.Reader, w io.Writer) error {
+
+		var buf []byte
+		for {
+
+			rec, err := src.Next(ctx)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return errors.Wrap(err, "unable to receive record")
+			}
+			if bytes.IndexByte(rec, '\n') >= 0 {
+				return errors.Errorf("piper: the record %.32q contains a newline", rec)
+			}
+
+			buf = append(append(buf[:0], rec...), '\n')
+			_, err = w.Write(buf)
+			if err != nil {
+				return err
+			}
+
+		}
+
+	})
+
+}
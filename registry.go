@@ -0,0 +1,114 @@
+package piper
+
+import (
+	"context"
+	"sync"
+)
+
+// CommandFactory creates the in-process implementation of a virtual command from its arguments
+type CommandFactory func(args []string) StageContextFunc
+
+// Registry maps the names of virtual commands to in-process implementations, e.g. "gzip" to a
+// stage using compress/gzip, and holds aliases for commands. It is safe for concurrent use.
+type Registry struct {
+	mu        sync.RWMutex
+	factories map[string]CommandFactory
+	aliases   map[string][]string
+}
+
+// DefaultRegistry is consulted by Command and CommandContext before the command is looked up in
+// PATH, so pipelines built from configuration transparently use the registered implementations
+// and aliases.
+var DefaultRegistry = &Registry{}
+
+// Register registers f as the virtual command name in DefaultRegistry.
+func Register(name string, f CommandFactory) {
+
+	DefaultRegistry.Register(name, f)
+
+}
+
+// Alias registers the alias name in DefaultRegistry.
+func Alias(name string, command ...string) {
+
+	DefaultRegistry.Alias(name, command...)
+
+}
+
+// Register registers f as the virtual command name, a nil f removes it.
+func (r *Registry) Register(name string, f CommandFactory) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f == nil {
+		delete(r.factories, name)
+		return
+	}
+	if r.factories == nil {
+		r.factories = map[string]CommandFactory{}
+	}
+	r.factories[name] = f
+
+}
+
+// Lookup returns the factory of the virtual command name.
+func (r *Registry) Lookup(name string) (CommandFactory, bool) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	f, ok := r.factories[name]
+	return f, ok
+
+}
+
+// Alias makes name stand for command, the arguments of a chain are appended to it. This
+// centralizes the flags hardening a tool, e.g.
+//
+//	piper.Alias("psql", "psql", "--no-psqlrc", "-v", "ON_ERROR_STOP=1")
+//
+// Aliases are expanded once, the command may be a virtual command. An empty command removes the alias.
+func (r *Registry) Alias(name string, command ...string) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(command) == 0 {
+		delete(r.aliases, name)
+		return
+	}
+	if r.aliases == nil {
+		r.aliases = map[string][]string{}
+	}
+	r.aliases[name] = append([]string(nil), command...)
+
+}
+
+// expand returns the command and arguments the alias name stands for, or name and arg if it is none.
+func (r *Registry) expand(name string, arg []string) (string, []string) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	a, ok := r.aliases[name]
+	if !ok {
+		return name, arg
+	}
+	return a[0], append(a[1:len(a):len(a)], arg...)
+
+}
+
+// commandStage creates the stage of Command and CommandContext, ctx is nil for Command.
+func commandStage(ctx context.Context, name string, arg []string) *stage {
+
+	name, arg = DefaultRegistry.expand(name, arg)
+	if f, ok := DefaultRegistry.Lookup(name); ok {
+		return &stage{cfn: f(arg), ctx: ctx, virtual: name}
+	}
+	if ctx == nil {
+		return &stage{cmd: command(name, arg...)}
+	}
+	return &stage{cmd: commandContext(ctx, name, arg...), ctx: ctx}
+
+}
@@ -0,0 +1,90 @@
+package piper
+
+import (
+	"strings"
+)
+
+// String renders the chain as a POSIX shell command line, e.g. "ls -al | grep 'a b'", for logs,
+// dry runs and reproducing a failure outside of Go. Arguments are quoted with QuoteArg. The
+// variables of StageEnv, StageDir, IgnoreFailure, Not, the streams selected with PipeStreams and
+// the conditions of And and Or are rendered as well, the environment and working directory of the
+// chain aren't. Function stages have no shell equivalent, they are rendered as "<func>".
+func (c *Chain) String() string {
+
+	var b strings.Builder
+	for i, pt := range c.parts {
+		if i > 0 {
+			b.WriteString(pt.cond.operator())
+		}
+		renderPipeline(&b, pt.stages)
+	}
+	if len(c.parts) > 0 {
+		b.WriteString(c.cond.operator())
+	}
+	renderPipeline(&b, c.stages)
+	return b.String()
+
+}
+
+// operator returns the shell operator of the condition joining two pipelines.
+func (cond condition) operator() string {
+
+	if cond == condOr {
+		return " || "
+	}
+	return " && "
+
+}
+
+func renderPipeline(b *strings.Builder, stages []*stage) {
+
+	for i, s := range stages {
+		b.WriteString(s.render())
+		if i == len(stages)-1 {
+			break
+		}
+		switch s.streams {
+		case StderrStream:
+			b.WriteString(" 2>&1 >/dev/null")
+		case BothStreams:
+			b.WriteString(" 2>&1")
+		}
+		b.WriteString(" | ")
+	}
+
+}
+
+// render returns the stage as a shell command, see Chain.String.
+func (s *stage) render() string {
+
+	var words []string
+	if s.negate {
+		words = append(words, "!")
+	}
+	for _, kv := range s.env {
+		// the name stays unquoted, a quoted assignment is taken for a command
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			words = append(words, k+"="+QuoteArg(v))
+		}
+	}
+	switch {
+	case s.cmd != nil:
+		for _, arg := range s.cmd.Args {
+			words = append(words, QuoteArg(arg))
+		}
+	case s.virtual != "":
+		words = append(words, QuoteArg(s.virtual))
+	default:
+		words = append(words, "<func>")
+	}
+
+	cmd := strings.Join(words, " ")
+	if s.cmd != nil && s.cmd.Dir != "" {
+		cmd = "(cd " + QuoteArg(s.cmd.Dir) + " && " + cmd + ")"
+	}
+	if s.ignoreFailure {
+		cmd = "{ " + cmd + " || true; }"
+	}
+	return cmd
+
+}
@@ -0,0 +1,165 @@
+package piper
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Result describes the outcome of a run of a chain
+type Result struct {
+	Stages []StageResult
+
+	// Links holds a checkpoint for every link between two stages, Output one for the output of
+	// the last stage. Links which didn't pass through the parent process report -1 bytes and
+	// Output is only recorded if the chain is instrumented, see Chain.Instrument, or has a
+	// context or a timeout.
+	Links  []LinkResult
+	Output *LinkResult
+
+	// Partial is set if the run was cut short because its context was done, its timeout expired
+	// or its stages were killed, e.g. by KillOnFailure, Kill or Shutdown. The output may end in
+	// the middle of a record then, Framed of Output tells where the last complete one ended.
+	Partial bool
+
+	// Seed is the seed the random decisions of the run were drawn from, see WithSeed
+	Seed int64
+}
+
+// LinkResult records how much data passed a link. This tells whether partial output of a cancelled
+// or failed chain is usable.
+type LinkResult struct {
+	// From is the stage writing to the link
+	From  int
+	Bytes int64
+	// Framed is the number of bytes up to the end of the last complete record, the bytes after it
+	// are a truncated record. Records are lines unless the chain has a framing, see WithFraming.
+	// Complete is set if the data ended with a complete record.
+	Framed   int64
+	Complete bool
+	// FirstByte and LastByte are the times the first and the last byte passed the link, relative
+	// to the start of the chain. They are zero if no data passed. FirstByte of Result.Output is
+	// the end-to-end latency of the chain.
+	FirstByte time.Duration
+	LastByte  time.Duration
+}
+
+// StageResult describes the outcome of a single command of a chain
+type StageResult struct {
+	Path  string
+	Args  []string
+	State *os.ProcessState
+	// Exit tells how the process terminated, it is nil for function stages and commands which
+	// didn't run
+	Exit *ExitStatus
+	Err  error
+	// Core is the path of the core dump collected by WithCoreDumps, CoreErr tells why a dumped
+	// core couldn't be collected
+	Core    string
+	CoreErr error
+	// Ignored is set for stages marked with IgnoreFailure, their Err doesn't fail the chain
+	Ignored bool
+	// Negated is set for stages marked with Not, Err holds the inverted outcome
+	Negated bool
+	// SpawnRetries counts the retries needed to start the command, see WithSpawnRetry
+	SpawnRetries int
+
+	// StdoutDropped and StderrDropped count the bytes a Capture dropped because of its limits
+	StdoutDropped int64
+	StderrDropped int64
+}
+
+// Success reports whether every command of the chain exited successfully, ignoring the
+// failures of stages marked with IgnoreFailure.
+func (r *Result) Success() bool {
+
+	for _, s := range r.Stages {
+		if s.Err != nil && !s.Ignored {
+			return false
+		}
+	}
+
+	return true
+
+}
+
+// ExitCodeMismatch is the error of Result.ExpectExitCodes, it lists the stages which deviated
+type ExitCodeMismatch struct {
+	// Expected and Actual hold the exit codes of all stages
+	Expected []int
+	Actual   []int
+	// Deviations holds the stages whose exit code differs, in order
+	Deviations []ExitCodeDeviation
+}
+
+// ExitCodeDeviation describes a stage whose exit code differs from the expected one
+type ExitCodeDeviation struct {
+	// Index is the position of the stage, Path the path of the command or "func"
+	Index int
+	Path  string
+	// Expected is -1 for stages beyond the expected codes
+	Expected int
+	Actual   int
+}
+
+// Error lists the deviations, one per line.
+func (e *ExitCodeMismatch) Error() string {
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "piper: exit codes %v, expected %v", e.Actual, e.Expected)
+	if len(e.Expected) != len(e.Actual) {
+		fmt.Fprintf(&b, "\n  %d stages, expected %d", len(e.Actual), len(e.Expected))
+	}
+	for _, d := range e.Deviations {
+		fmt.Fprintf(&b, "\n  stage #%d (%s): exit code %d, expected %d", d.Index, d.Path, d.Actual, d.Expected)
+	}
+	return b.String()
+
+}
+
+// ExitCodes returns the exit code of every stage. Function stages report 0 on success and 1 on
+// failure, stages which didn't exit -1. Negated stages report the code of the process.
+func (r *Result) ExitCodes() []int {
+
+	codes := make([]int, len(r.Stages))
+	for i, s := range r.Stages {
+		switch {
+		case s.State != nil:
+			codes[i] = s.State.ExitCode()
+		case s.Path != "":
+			codes[i] = -1
+		case (s.Err != nil) != s.Negated:
+			codes[i] = 1
+		}
+	}
+	return codes
+
+}
+
+// ExpectExitCodes compares the exit codes of the stages, see ExitCodes, with codes, e.g.
+// ExpectExitCodes(0, 0, 1) for a chain whose last stage is expected to fail like a grep without
+// matches. It returns nil if they match and an *ExitCodeMismatch otherwise.
+func (r *Result) ExpectExitCodes(codes ...int) error {
+
+	actual := r.ExitCodes()
+	e := &ExitCodeMismatch{Expected: codes, Actual: actual}
+	for i, code := range actual {
+		if i < len(codes) && codes[i] == code {
+			continue
+		}
+		d := ExitCodeDeviation{Index: i, Path: r.Stages[i].Path, Expected: -1, Actual: code}
+		if d.Path == "" {
+			d.Path = "func"
+		}
+		if i < len(codes) {
+			d.Expected = codes[i]
+		}
+		e.Deviations = append(e.Deviations, d)
+	}
+	if len(e.Deviations) == 0 && len(codes) == len(actual) {
+		return nil
+	}
+	return e
+
+}
@@ -0,0 +1,357 @@
+// Package rotate provides a file writer for Stdout or Allerr of long running chains which rotates
+// by size or age, optionally compresses rotated files and reopens its file on a signal, so an
+// external logrotate setup is not required.
+package rotate
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// stamp is the layout of the timestamp suffix of rotated files
+const stamp = "20060102-150405.000000"
+
+// File is an io.WriteCloser writing to Path. The zero value (with Path set) never rotates.
+type File struct {
+	// Path is the file written to, rotated files get a timestamp suffix. Files rotated within the
+	// same timestamp get a sequence number as well, e.g. "out.log.20060102-150405.000000-1".
+	Path string
+	// MaxSize rotates the file before a write would make it larger, zero disables size rotation
+	MaxSize int64
+	// Interval rotates the file once it is older, zero disables time rotation
+	Interval time.Duration
+	// MaxBackups is the number of rotated files kept, zero keeps all of them
+	MaxBackups int
+	// Compress gzips rotated files in the background
+	Compress bool
+	// Perm is used to create the file, 0644 if zero
+	Perm os.FileMode
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+	closed bool
+	wg     sync.WaitGroup
+
+	// pending holds the rotated files being compressed, they are left alone by prune
+	pmu     sync.Mutex
+	pending map[string]bool
+}
+
+// Write writes p to the current file, rotating it first if necessary. It fails with os.ErrClosed
+// once the file was closed.
+func (f *File) Write(p []byte) (int, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.f == nil {
+		err := f.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if f.due(int64(len(p))) {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.f.Write(p)
+	f.size += int64(n)
+	return n, err
+
+}
+
+// Rotate closes the current file, moves it aside and starts a new one. It does nothing once the
+// file was closed.
+func (f *File) Rotate() error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil
+	}
+	return f.rotate()
+
+}
+
+// Reopen closes and reopens Path, e.g. after it was moved by an external tool. It does nothing
+// once the file was closed, so a late signal of ReopenOn doesn't open it again.
+func (f *File) Reopen() error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil
+	}
+	f.close()
+	return f.open()
+
+}
+
+// ReopenOn reopens the file whenever one of the signals (usually syscall.SIGHUP) is received
+// until stop is called.
+func (f *File) ReopenOn(sigs ...os.Signal) (stop func()) {
+
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, sigs...)
+
+	go func() {
+		for {
+			select {
+			case <-ch:
+				f.Reopen()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+
+}
+
+// Close closes the file and waits for running compressions.
+func (f *File) Close() error {
+
+	f.mu.Lock()
+	f.closed = true
+	err := f.close()
+	f.mu.Unlock()
+
+	f.wg.Wait()
+	return err
+
+}
+
+func (f *File) due(n int64) bool {
+
+	if f.MaxSize > 0 && f.size > 0 && f.size+n > f.MaxSize {
+		return true
+	}
+	return f.Interval > 0 && time.Since(f.opened) >= f.Interval
+
+}
+
+func (f *File) open() error {
+
+	perm := f.Perm
+	if perm == 0 {
+		perm = 0644
+	}
+
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, perm)
+	if err != nil {
+		return errors.Wrap(err, "unable to open log file")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Wrap(err, "unable to open log file")
+	}
+
+	f.f = file
+	f.size = info.Size()
+	f.opened = time.Now()
+	return nil
+
+}
+
+func (f *File) close() error {
+
+	if f.f == nil {
+		return nil
+	}
+	err := f.f.Close()
+	f.f = nil
+	return err
+
+}
+
+func (f *File) rotate() error {
+
+	err := f.close()
+	if err != nil {
+		return err
+	}
+
+	rotated := f.Path + "." + time.Now().Format(stamp)
+	for seq, name := 1, rotated; ; seq++ {
+		if !exists(name) && !exists(name+".gz") {
+			rotated = name
+			break
+		}
+		name = rotated + "-" + strconv.Itoa(seq)
+	}
+	err = os.Rename(f.Path, rotated)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "unable to rotate log file")
+	}
+
+	if err == nil && f.Compress {
+		f.compressing(rotated, true)
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			compress(rotated)
+			f.compressing(rotated, false)
+			f.prune()
+		}()
+	} else {
+		f.prune()
+	}
+
+	return f.open()
+
+}
+
+func exists(path string) bool {
+
+	_, err := os.Lstat(path)
+	return err == nil
+
+}
+
+// compressing marks the rotated file path as being compressed or done.
+func (f *File) compressing(path string, busy bool) {
+
+	f.pmu.Lock()
+	defer f.pmu.Unlock()
+
+	if f.pending == nil {
+		f.pending = map[string]bool{}
+	}
+	if busy {
+		f.pending[path] = true
+	} else {
+		delete(f.pending, path)
+	}
+
+}
+
+// prune removes the oldest rotated files beyond MaxBackups. Only the files named like rotated
+// ones count, files still being compressed are skipped.
+func (f *File) prune() {
+
+	if f.MaxBackups <= 0 {
+		return
+	}
+
+	dir, base := filepath.Split(f.Path)
+	entries, _ := os.ReadDir(filepath.Clean(dir))
+
+	f.pmu.Lock()
+	var backups []backup
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if b, ok := rotatedName(base, e.Name()); ok && !f.pending[path] {
+			b.path = path
+			backups = append(backups, b)
+		}
+	}
+	f.pmu.Unlock()
+
+	// the fixed width timestamps sort in time order, the sequence orders files of the same one
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].ts != backups[j].ts {
+			return backups[i].ts < backups[j].ts
+		}
+		return backups[i].seq < backups[j].seq
+	})
+	if len(backups) <= f.MaxBackups {
+		return
+	}
+	for _, b := range backups[:len(backups)-f.MaxBackups] {
+		os.Remove(b.path)
+	}
+
+}
+
+// backup is a rotated file
+type backup struct {
+	path string
+	ts   string
+	seq  int
+}
+
+// rotatedName reports whether name is a rotated file of base, compressed or not.
+func rotatedName(base, name string) (backup, bool) {
+
+	ts, ok := strings.CutPrefix(name, base+".")
+	if !ok {
+		return backup{}, false
+	}
+	ts = strings.TrimSuffix(ts, ".gz")
+	b := backup{ts: ts}
+	if len(ts) > len(stamp) {
+		seq, err := strconv.Atoi(ts[len(stamp)+1:])
+		if ts[len(stamp)] != '-' || err != nil || seq <= 0 {
+			return backup{}, false
+		}
+		b.ts, b.seq = ts[:len(stamp)], seq
+	}
+	_, err := time.Parse(stamp, b.ts)
+	return b, err == nil && len(b.ts) == len(stamp)
+
+}
+
+func compress(path string) error {
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// the archive gets its name once it is complete, so prune never sees it half-written
+	tmp := path + ".gz.tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Remove(path)
+
+}
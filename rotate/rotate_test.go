@@ -0,0 +1,153 @@
+package rotate_test
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/noxer/piper/rotate"
+)
+
+// contents returns the content of the rotated files of path in rotation order and the one of path
+func contents(t *testing.T, path string) (backups []string, current string) {
+
+	t.Helper()
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		name := filepath.Join(filepath.Dir(path), e.Name())
+		if name == path {
+			b, _ := os.ReadFile(name)
+			current = string(b)
+			continue
+		}
+		r, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rd io.Reader = r
+		if strings.HasSuffix(name, ".gz") {
+			if rd, err = gzip.NewReader(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		b, err := io.ReadAll(rd)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		backups = append(backups, string(b))
+	}
+
+	// the tests write content in sort order, so it tells the order of the rotations
+	sort.Strings(backups)
+	return backups, current
+
+}
+
+func TestRotate(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		file    *rotate.File
+		writes  []string
+		backups []string
+		current string
+	}{
+		{"no rotation", &rotate.File{}, []string{"a1", "a2", "a3"}, nil, "a1a2a3"},
+		{"size", &rotate.File{MaxSize: 5}, []string{"a1", "a2", "a3", "a4", "a5"}, []string{"a1a2", "a3a4"}, "a5"},
+		{"large write", &rotate.File{MaxSize: 2}, []string{"a1", "a2long", "a3"}, []string{"a1", "a2long"}, "a3"},
+		{"max backups", &rotate.File{MaxSize: 2, MaxBackups: 2}, []string{"a1", "a2", "a3", "a4", "a5"}, []string{"a3", "a4"}, "a5"},
+		{"compressed", &rotate.File{MaxSize: 2, Compress: true}, []string{"a1", "a2", "a3"}, []string{"a1", "a2"}, "a3"},
+		{"compressed max backups", &rotate.File{MaxSize: 2, MaxBackups: 1, Compress: true}, []string{"a1", "a2", "a3", "a4"}, []string{"a3"}, "a4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.file
+			f.Path = filepath.Join(t.TempDir(), "out.log")
+			for _, w := range tt.writes {
+				if _, err := io.WriteString(f, w); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			backups, current := contents(t, f.Path)
+			if strings.Join(backups, ",") != strings.Join(tt.backups, ",") || current != tt.current {
+				t.Errorf("got %q and %q, want %q and %q", backups, current, tt.backups, tt.current)
+			}
+		})
+	}
+
+}
+
+func TestRotateSameTimestamp(t *testing.T) {
+
+	f := &rotate.File{Path: filepath.Join(t.TempDir(), "out.log"), MaxBackups: 50}
+	defer f.Close()
+
+	// rotations within the same timestamp get sequence numbers instead of replacing each other
+	var want []string
+	for i := 0; i < 200; i++ {
+		data := fmt.Sprintf("%03d", i)
+		io.WriteString(f, data)
+		if err := f.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, data)
+	}
+
+	backups, _ := contents(t, f.Path)
+	if got, want := strings.Join(backups, ","), strings.Join(want[len(want)-50:], ","); got != want {
+		t.Errorf("kept %q, want the last 50 rotations %q", got, want)
+	}
+
+}
+
+func TestReopen(t *testing.T) {
+
+	dir := t.TempDir()
+	f := &rotate.File{Path: filepath.Join(dir, "out.log")}
+	io.WriteString(f, "before")
+
+	// an external tool moves the file aside
+	if err := os.Rename(f.Path, filepath.Join(dir, "moved")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(f, "after")
+	if b, _ := os.ReadFile(f.Path); string(b) != "after" {
+		t.Errorf("got %q after Reopen", b)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(f.Path)
+	if err := f.Reopen(); err != nil {
+		t.Errorf("Reopen after Close: %v", err)
+	}
+	if err := f.Rotate(); err != nil {
+		t.Errorf("Rotate after Close: %v", err)
+	}
+	if _, err := os.Stat(f.Path); err == nil {
+		t.Error("the file was opened again after Close")
+	}
+	if _, err := f.Write([]byte("x")); err != os.ErrClosed {
+		t.Errorf("Write after Close: got %v, want os.ErrClosed", err)
+	}
+
+}
@@ -0,0 +1,126 @@
+package piper
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// ContentType is the kind of data detected by Sniff
+type ContentType string
+
+// Content types detected by Sniff
+const (
+	TypeGzip   ContentType = "gzip"
+	TypeBzip2  ContentType = "bzip2"
+	TypeXz     ContentType = "xz"
+	TypeZstd   ContentType = "zstd"
+	TypeZip    ContentType = "zip"
+	TypeTar    ContentType = "tar"
+	TypeJSON   ContentType = "json"
+	TypeText   ContentType = "text"
+	TypeBinary ContentType = "binary"
+)
+
+// SniffLen is the number of bytes inspected by Route
+const SniffLen = 512
+
+var magics = []struct {
+	magic []byte
+	typ   ContentType
+}{
+	{[]byte{0x1f, 0x8b}, TypeGzip},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, TypeXz},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, TypeZstd},
+	{[]byte("PK\x03\x04"), TypeZip},
+}
+
+// Sniff detects the type of data starting with head, it needs up to SniffLen bytes.
+func Sniff(head []byte) ContentType {
+
+	for _, m := range magics {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.typ
+		}
+	}
+	if isBzip2(head) {
+		return TypeBzip2
+	}
+	if len(head) >= 262 && bytes.Equal(head[257:262], []byte("ustar")) {
+		return TypeTar
+	}
+
+	// the sample may end in the middle of a multi-byte character
+	text := head
+	for i := 0; i < utf8.UTFMax && len(text) > 0 && !utf8.Valid(text); i++ {
+		text = text[:len(text)-1]
+	}
+	if !utf8.Valid(text) || bytes.IndexByte(head, 0) >= 0 {
+		return TypeBinary
+	}
+
+	if t := bytes.TrimLeft(head, " \t\r\n"); len(t) > 0 && (t[0] == '{' || t[0] == '[') {
+		return TypeJSON
+	}
+	return TypeText
+
+}
+
+// isBzip2 reports whether head starts a bzip2 stream: "BZh", the block size digit and the magic
+// of the first block or, for empty streams, of the end of the stream.
+func isBzip2(head []byte) bool {
+
+	if len(head) < 10 || !bytes.HasPrefix(head, []byte("BZh")) || head[3] < '1' || head[3] > '9' {
+		return false
+	}
+	block := head[4:10]
+	return bytes.Equal(block, []byte("1AY&SY")) || bytes.Equal(block, []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90})
+
+}
+
+// Route adds a stage to the back of the chain which inspects the start of its input and passes the
+// whole stream through a copy of the chain registered for the detected type. Types without a route
+// use fallback, if it is nil the data is passed on unchanged.
+func (c *Chain) Route(routes map[ContentType]*Chain, fallback *Chain) *Chain {
+
+	return c.Func(func(r io.Reader, w io.Writer) error {
+
+		br := bufio.NewReaderSize(r, SniffLen)
+		head, err := br.Peek(SniffLen)
+		if err != nil && err != io.EOF {
+			return errors.Wrap(err, "unable to inspect input")
+		}
+
+		typ := Sniff(head)
+		sub := routes[typ]
+		if sub == nil {
+			sub = fallback
+		}
+		if sub == nil {
+			_, err = io.Copy(w, br)
+			return err
+		}
+
+		return errors.Wrapf(runWith(sub, br, w), "route %s failed", typ)
+
+	})
+
+}
+
+// runWith runs a copy of template reading from r and writing to w.
+func runWith(template *Chain, r io.Reader, w io.Writer) error {
+
+	sub := template.Clone()
+	sub.Stdin = r
+	sub.Stdout = w
+
+	err := sub.Start()
+	if err != nil {
+		return err
+	}
+	return sub.Wait()
+
+}
@@ -0,0 +1,12 @@
+package piper
+
+// Runner is the part of a Chain most callers use, so code running chains can depend on it and be
+// tested with a fake like pipertest.Fake instead of running processes.
+type Runner interface {
+	Start() error
+	Wait() error
+	Output() ([]byte, error)
+	Kill() error
+}
+
+var _ Runner = (*Chain)(nil)
@@ -0,0 +1,86 @@
+package piper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxRecord is the largest record accepted by NewScanner if max is zero
+const DefaultMaxRecord = 1 << 20
+
+// ErrRecordTooLarge is the cause of the error of ScanVarint for records exceeding the limit
+var ErrRecordTooLarge = errors.New("piper: record too large")
+
+// NewScanner returns a scanner splitting r into records with split, e.g. bufio.ScanLines, ScanNUL
+// or ScanVarint. Records larger than max bytes, DefaultMaxRecord if zero, stop the scanner with
+// bufio.ErrTooLong instead of exhausting memory.
+func NewScanner(r io.Reader, split bufio.SplitFunc, max int) *bufio.Scanner {
+
+	if max <= 0 {
+		max = DefaultMaxRecord
+	}
+
+	sc := bufio.NewScanner(r)
+	// room for the delimiter or size prefix of a record of max bytes
+	sc.Buffer(nil, max+binary.MaxVarintLen64)
+	sc.Split(split)
+	return sc
+
+}
+
+// ScanNUL is a bufio.SplitFunc splitting at NUL bytes, e.g. the output of "find -print0". A final
+// record without a NUL is returned as well.
+func ScanNUL(data []byte, atEOF bool) (advance int, token []byte, err error) {
+
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+
+}
+
+// ScanVarint returns a bufio.SplitFunc for records preceded by their size as a varint, like
+// length delimited protobuf messages. A record larger than max bytes fails with the cause
+// ErrRecordTooLarge as soon as its size was read, a truncated record fails with io.ErrUnexpectedEOF.
+func ScanVarint(max int) bufio.SplitFunc {
+
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+
+		if len(data) == 0 {
+			return 0, nil, nil
+		}
+
+		n, k := binary.Uvarint(data)
+		switch {
+		case k < 0:
+			return 0, nil, errors.New("piper: invalid record size")
+		case k == 0 && atEOF:
+			return 0, nil, errors.Wrap(io.ErrUnexpectedEOF, "truncated record size")
+		case k == 0:
+			return 0, nil, nil
+		case n > uint64(max):
+			return 0, nil, errors.Wrapf(ErrRecordTooLarge, "record of %d bytes exceeds %d bytes", n, max)
+		}
+
+		end := k + int(n)
+		if len(data) < end {
+			if atEOF {
+				return 0, nil, errors.Wrap(io.ErrUnexpectedEOF, "truncated record")
+			}
+			return 0, nil, nil
+		}
+		return end, data[k:end], nil
+
+	}
+
+}
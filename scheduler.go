@@ -0,0 +1,498 @@
+package piper
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Causes of the errors returned by a Scheduler
+var (
+	// ErrQuota is returned if a tenant exceeded its Quota
+	ErrQuota = errors.New("piper: quota exceeded")
+	// ErrPreempted is returned if a chain was killed to make room for one with a higher priority
+	ErrPreempted = errors.New("piper: chain preempted")
+)
+
+// Preemption decides what happens to running chains when a chain with a higher priority waits
+type Preemption int
+
+// Preemption policies
+const (
+	// NoPreemption lets the running chains finish
+	NoPreemption Preemption = iota
+	// PausePreempted stops the commands of the running chain with the lowest priority (SIGSTOP)
+	// and continues them once a slot is free again. Function stages keep running. Pausing isn't
+	// supported on Windows, chains aren't preempted there.
+	PausePreempted
+	// KillPreempted kills the running chain with the lowest priority, it fails with ErrPreempted
+	KillPreempted
+)
+
+// RunOptions configures how a chain is scheduled, see RunWith
+type RunOptions struct {
+	// Tenant is the owner of the chain, see Quota
+	Tenant string
+	// Priority orders the chains waiting for a slot, higher ones run first. Chains of the same
+	// priority run in the order they were submitted.
+	Priority int
+}
+
+// Scheduler runs chains while bounding how many of them run at the same time. Chains exceeding the
+// limit wait in the order of their priority. The zero value runs any number of chains.
+type Scheduler struct {
+	// Limit is the maximum number of chains running at the same time, unlimited if zero
+	Limit int
+
+	// Preempt is applied to the running chains if a chain waits which has a higher priority than
+	// some of them, see RunOptions
+	Preempt Preemption
+
+	// Quotas returns the quota of a tenant, see RunAs. Tenants are unlimited if nil.
+	Quotas func(tenant string) Quota
+
+	mu      sync.Mutex
+	running int
+	queue   []*ticket
+	active  []*ticket
+	seq     uint64
+	tenants map[string]*tenant
+}
+
+// Quota limits the chains of a single tenant of a Scheduler. Zero values don't limit.
+type Quota struct {
+	// Concurrency is the number of chains of the tenant running or waiting at the same time
+	Concurrency int
+	// Duration is the time a chain may run, it is killed once it ran longer. The time it was paused
+	// by PausePreempted doesn't count.
+	Duration time.Duration
+	// OutputBytes is the total number of bytes written to the Stdout of all chains of the tenant.
+	// A chain exceeding it is killed, further chains are rejected until ResetUsage is called.
+	OutputBytes int64
+}
+
+// tenant is the usage of a tenant of a Scheduler
+type tenant struct {
+	active int
+	output int64
+}
+
+// ticket is a chain waiting for or holding a slot of a Scheduler, it is guarded by Scheduler.mu
+type ticket struct {
+	c     *Chain
+	prio  int
+	seq   uint64
+	ready chan struct{}
+
+	started   bool
+	paused    bool
+	preempted bool
+
+	// budget is the time left of the duration quota, timer kills the chain once it ran out. since
+	// is the time the timer was last started.
+	budget time.Duration
+	timer  *time.Timer
+	since  time.Time
+}
+
+// Run waits for a free slot, then starts c and waits for it. If ctx is done before c finished,
+// it is removed from the queue or its commands are killed and the error has the cause ctx.Err().
+func (s *Scheduler) Run(ctx context.Context, c *Chain) error {
+
+	return s.RunAs(ctx, "", c)
+
+}
+
+// RunAs runs c like Run on behalf of tenant, subject to its Quota. Chains exceeding the
+// concurrency or output quota of the tenant are rejected before they are started, chains
+// exceeding the duration or output quota while running are killed. The errors have the cause ErrQuota.
+func (s *Scheduler) RunAs(ctx context.Context, tenant string, c *Chain) error {
+
+	return s.RunWith(ctx, c, RunOptions{Tenant: tenant})
+
+}
+
+// RunWith runs c like RunAs with the tenant and priority of opts. A chain preempted by the
+// KillPreempted policy fails with the cause ErrPreempted.
+func (s *Scheduler) RunWith(ctx context.Context, c *Chain, opts RunOptions) error {
+
+	tenant := opts.Tenant
+	var q Quota
+	if s.Quotas != nil {
+		q = s.Quotas(tenant)
+	}
+
+	err := s.admit(tenant, q)
+	if err != nil {
+		return err
+	}
+	defer s.leave(tenant)
+
+	t := &ticket{c: c, prio: opts.Priority, budget: q.Duration}
+	err = s.acquire(ctx, t)
+	if err != nil {
+		return errors.Wrap(err, "unable to schedule chain")
+	}
+	defer s.release(t)
+
+	// exceeded is set to the reason the chain was killed for
+	var exceeded atomic.Value
+	over := func(reason string) {
+		if exceeded.CompareAndSwap(nil, reason) {
+			c.kill()
+		}
+	}
+	if q.OutputBytes > 0 {
+		// the tap is removed again, so running the chain once more counts its output once
+		last, w := len(c.stages)-1, &quotaWriter{s: s, tenant: tenant, limit: q.OutputBytes, over: over}
+		c.tapStdout(last, w)
+		defer c.untapStdout(last, w)
+	}
+
+	err = c.Start()
+	if err != nil {
+		return err
+	}
+	s.started(t, func() { over("chain exceeded its maximum duration of " + q.Duration.String()) })
+
+	stop := context.AfterFunc(ctx, c.kill)
+
+	err = c.Wait()
+	if reason, ok := exceeded.Load().(string); ok {
+		stop()
+		return errors.Wrapf(ErrQuota, "tenant %q: %s", tenant, reason)
+	}
+	if s.wasPreempted(t) {
+		stop()
+		return errors.Wrapf(ErrPreempted, "chain of priority %d killed", t.prio)
+	}
+	if !stop() && err != nil {
+		return errors.Wrap(ctx.Err(), "chain canceled")
+	}
+	return err
+
+}
+
+// Usage returns the number of running or waiting chains of tenant and the bytes they wrote so far.
+func (s *Scheduler) Usage(tenant string) (active int, output int64) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.tenants[tenant]; ok {
+		return t.active, t.output
+	}
+	return 0, 0
+
+}
+
+// ResetUsage resets the output counted for tenant, e.g. at the start of a billing period.
+func (s *Scheduler) ResetUsage(tenant string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.tenants[tenant]; ok {
+		t.output = 0
+	}
+
+}
+
+// Running returns the number of chains currently running.
+func (s *Scheduler) Running() int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.running
+
+}
+
+// Queued returns the number of chains waiting for a slot, including paused ones.
+func (s *Scheduler) Queued() int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.queue)
+
+}
+
+// admit checks the quota of the tenant and counts the chain as active.
+func (s *Scheduler) admit(name string, q Quota) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tenants == nil {
+		s.tenants = map[string]*tenant{}
+	}
+	t, ok := s.tenants[name]
+	if !ok {
+		t = &tenant{}
+		s.tenants[name] = t
+	}
+
+	if q.Concurrency > 0 && t.active >= q.Concurrency {
+		return errors.Wrapf(ErrQuota, "tenant %q already runs %d chains", name, t.active)
+	}
+	if q.OutputBytes > 0 && t.output >= q.OutputBytes {
+		return errors.Wrapf(ErrQuota, "tenant %q used up its output of %d bytes", name, q.OutputBytes)
+	}
+
+	t.active++
+	return nil
+
+}
+
+func (s *Scheduler) leave(name string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.tenants[name]
+	t.active--
+	if t.active == 0 && t.output == 0 {
+		delete(s.tenants, name)
+	}
+
+}
+
+// addOutput counts n bytes of output of tenant and reports whether it is within limit.
+func (s *Scheduler) addOutput(name string, n int, limit int64) bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.tenants[name]
+	t.output += int64(n)
+	return t.output <= limit
+
+}
+
+// quotaWriter counts the output of a chain against the quota of its tenant
+type quotaWriter struct {
+	s      *Scheduler
+	tenant string
+	limit  int64
+	over   func(reason string)
+}
+
+func (w *quotaWriter) Write(p []byte) (int, error) {
+
+	if !w.s.addOutput(w.tenant, len(p), w.limit) {
+		w.over("output exceeds the quota of " + strconv.FormatInt(w.limit, 10) + " bytes")
+	}
+	return len(p), nil
+
+}
+
+func (s *Scheduler) acquire(ctx context.Context, t *ticket) error {
+
+	s.mu.Lock()
+	s.seq++
+	t.seq = s.seq
+	if s.free() && (len(s.queue) == 0 || s.queue[0].prio < t.prio) {
+		s.grant(t)
+		s.mu.Unlock()
+		return nil
+	}
+
+	t.ready = make(chan struct{})
+	s.enqueue(t)
+	s.preempt(t.prio)
+	s.mu.Unlock()
+
+	select {
+	case <-t.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-t.ready:
+		// the slot was granted concurrently, pass it on
+		s.drop(t)
+	default:
+		s.remove(t)
+	}
+	return ctx.Err()
+
+}
+
+// started marks the chain of t as running so it may be preempted, which happens right away if a
+// chain with a higher priority queued up in the meantime. expire is called once the chain used up
+// its duration quota.
+func (s *Scheduler) started(t *ticket, expire func()) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t.started = true
+	if t.budget > 0 {
+		t.since = time.Now()
+		t.timer = time.AfterFunc(t.budget, expire)
+	}
+	if len(s.queue) > 0 {
+		s.preempt(s.queue[0].prio)
+	}
+
+}
+
+func (s *Scheduler) wasPreempted(t *ticket) bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return t.preempted
+
+}
+
+func (s *Scheduler) release(t *ticket) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	if t.paused {
+		// the chain ended while it was paused, e.g. because it was canceled
+		s.remove(t)
+		return
+	}
+	s.drop(t)
+
+}
+
+// free reports whether another chain may run, s.mu must be held.
+func (s *Scheduler) free() bool {
+
+	return s.Limit <= 0 || s.running < s.Limit
+
+}
+
+// grant hands a slot to t, s.mu must be held.
+func (s *Scheduler) grant(t *ticket) {
+
+	s.running++
+	s.active = append(s.active, t)
+
+}
+
+// drop takes the slot from t and passes it on, s.mu must be held.
+func (s *Scheduler) drop(t *ticket) {
+
+	for i, a := range s.active {
+		if a == t {
+			s.active = append(s.active[:i], s.active[i+1:]...)
+			break
+		}
+	}
+	s.running--
+	s.dispatch()
+
+}
+
+// enqueue inserts t by priority and submission, s.mu must be held.
+func (s *Scheduler) enqueue(t *ticket) {
+
+	i := sort.Search(len(s.queue), func(i int) bool {
+		q := s.queue[i]
+		return q.prio < t.prio || q.prio == t.prio && q.seq > t.seq
+	})
+	s.queue = append(s.queue, nil)
+	copy(s.queue[i+1:], s.queue[i:])
+	s.queue[i] = t
+
+}
+
+// dispatch grants free slots to the queued chains and continues paused ones, s.mu must be held.
+func (s *Scheduler) dispatch() {
+
+	for len(s.queue) > 0 && s.free() {
+		t := s.queue[0]
+		s.queue = s.queue[1:]
+		s.grant(t)
+		if t.paused {
+			t.paused = false
+			t.c.resume()
+			if t.budget > 0 {
+				t.since = time.Now()
+				t.timer.Reset(t.budget)
+			}
+			continue
+		}
+		close(t.ready)
+	}
+
+}
+
+// preempt makes room for a chain of priority prio according to the policy, s.mu must be held.
+func (s *Scheduler) preempt(prio int) {
+
+	if s.Preempt == NoPreemption || s.free() {
+		return
+	}
+
+	// the victim is the running chain with the lowest priority which started last
+	var victim *ticket
+	for _, a := range s.active {
+		if !a.started || a.preempted || a.prio >= prio {
+			continue
+		}
+		if victim == nil || a.prio < victim.prio || a.prio == victim.prio && a.seq > victim.seq {
+			victim = a
+		}
+	}
+	if victim == nil {
+		return
+	}
+
+	switch s.Preempt {
+	case PausePreempted:
+		if victim.c.pause() != nil {
+			victim.c.resume()
+			return
+		}
+		victim.paused = true
+		if victim.timer != nil && victim.timer.Stop() {
+			victim.budget -= time.Since(victim.since)
+		} else {
+			victim.budget = 0
+		}
+		for i, a := range s.active {
+			if a == victim {
+				s.active = append(s.active[:i], s.active[i+1:]...)
+				break
+			}
+		}
+		s.running--
+		s.enqueue(victim)
+		s.dispatch()
+
+	case KillPreempted:
+		victim.preempted = true
+		victim.c.kill()
+	}
+
+}
+
+func (s *Scheduler) remove(t *ticket) {
+
+	for i, q := range s.queue {
+		if q == t {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return
+		}
+	}
+
+}
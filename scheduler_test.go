@@ -0,0 +1,201 @@
+package piper_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/noxer/piper"
+)
+
+// writer returns a stage writing s
+func writer(s string) piper.StageFunc {
+
+	return func(_ io.Reader, w io.Writer) error {
+		_, err := io.WriteString(w, s)
+		return err
+	}
+
+}
+
+// blocker returns a chain waiting for release to be closed
+func blocker(release chan struct{}) *piper.Chain {
+
+	return piper.Func(func(io.Reader, io.Writer) error {
+		<-release
+		return nil
+	})
+
+}
+
+// eventually fails the test unless cond holds within a few seconds
+func eventually(t *testing.T, cond func() bool) {
+
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+}
+
+func TestSchedulerLimit(t *testing.T) {
+
+	s := &piper.Scheduler{Limit: 2}
+	var running, max int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.Run(context.Background(), piper.Func(func(io.Reader, io.Writer) error {
+				n := atomic.AddInt32(&running, 1)
+				for m := atomic.LoadInt32(&max); n > m && !atomic.CompareAndSwapInt32(&max, m, n); m = atomic.LoadInt32(&max) {
+				}
+				time.Sleep(20 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				return nil
+			}))
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if max != 2 {
+		t.Errorf("ran %d chains at the same time, want 2", max)
+	}
+
+}
+
+func TestSchedulerPriority(t *testing.T) {
+
+	s := &piper.Scheduler{Limit: 1}
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- s.Run(context.Background(), blocker(release)) }()
+	eventually(t, func() bool { return s.Running() == 1 })
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i, prio := range []int{1, 3, 2, 3} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.RunWith(context.Background(), piper.Func(func(io.Reader, io.Writer) error {
+				mu.Lock()
+				order = append(order, prio)
+				mu.Unlock()
+				return nil
+			}), piper.RunOptions{Priority: prio})
+		}()
+		eventually(t, func() bool { return s.Queued() == i+1 })
+	}
+
+	// a canceled chain leaves the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() { canceled <- s.Run(ctx, piper.Func(writer("x"))) }()
+	eventually(t, func() bool { return s.Queued() == 5 })
+	cancel()
+	if err := <-canceled; errors.Cause(err) != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+
+	close(release)
+	wg.Wait()
+	<-done
+	if got := fmt.Sprint(order); got != "[3 3 2 1]" {
+		t.Errorf("ran priorities %s, want [3 3 2 1]", got)
+	}
+
+}
+
+func TestSchedulerQuota(t *testing.T) {
+
+	quotas := map[string]piper.Quota{
+		"busy":   {Concurrency: 1},
+		"chatty": {OutputBytes: 10},
+	}
+	s := &piper.Scheduler{Quotas: func(tenant string) piper.Quota { return quotas[tenant] }}
+
+	// concurrency
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- s.RunAs(context.Background(), "busy", blocker(release)) }()
+	eventually(t, func() bool { active, _ := s.Usage("busy"); return active == 1 })
+	if err := s.RunAs(context.Background(), "busy", piper.Func(writer("x"))); errors.Cause(err) != piper.ErrQuota {
+		t.Errorf("second chain of busy: got %v, want ErrQuota", err)
+	}
+	if err := s.RunAs(context.Background(), "other", piper.Func(writer("x"))); err != nil {
+		t.Errorf("chain of another tenant: %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+
+	// output
+	if err := s.RunAs(context.Background(), "chatty", piper.Func(writer("0123456789abc"))); errors.Cause(err) != piper.ErrQuota {
+		t.Errorf("exceeding the output: got %v, want ErrQuota", err)
+	}
+	if err := s.RunAs(context.Background(), "chatty", piper.Func(writer("x"))); errors.Cause(err) != piper.ErrQuota {
+		t.Errorf("used up output: got %v, want ErrQuota", err)
+	}
+	s.ResetUsage("chatty")
+	if err := s.RunAs(context.Background(), "chatty", piper.Func(writer("abcd"))); err != nil {
+		t.Errorf("after ResetUsage: %v", err)
+	}
+	if _, output := s.Usage("chatty"); output != 4 {
+		t.Errorf("counted %d bytes, want 4", output)
+	}
+
+}
+
+func TestSchedulerDuration(t *testing.T) {
+
+	if _, err := exec.LookPath("sleep"); err != nil || runtime.GOOS == "windows" {
+		t.Skip("needs sleep and SIGSTOP")
+	}
+
+	quotas := func(tenant string) piper.Quota {
+		if tenant == "low" {
+			return piper.Quota{Duration: 300 * time.Millisecond}
+		}
+		return piper.Quota{}
+	}
+
+	s := &piper.Scheduler{Quotas: quotas}
+	if err := s.RunAs(context.Background(), "low", piper.Command("sleep", "5")); errors.Cause(err) != piper.ErrQuota {
+		t.Errorf("got %v, want ErrQuota", err)
+	}
+
+	// the time the chain is paused doesn't count
+	s = &piper.Scheduler{Limit: 1, Preempt: piper.PausePreempted, Quotas: quotas}
+	low := make(chan error, 1)
+	go func() { low <- s.RunAs(context.Background(), "low", piper.Command("sleep", "0.2")) }()
+	eventually(t, func() bool { return s.Running() == 1 })
+	time.Sleep(20 * time.Millisecond)
+
+	if err := s.RunWith(context.Background(), piper.Command("sleep", "0.5"), piper.RunOptions{Priority: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-low; err != nil {
+		t.Errorf("paused chain: %v", err)
+	}
+
+}
@@ -0,0 +1,51 @@
+package piper
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// SelfEnv is set in the environment of the commands created by Self, see IsSelf
+const SelfEnv = "PIPER_SELF"
+
+// Self creates a new Chain with a re-invocation of the current executable as the first command,
+// e.g. for a worker subprocess or privilege separation. The command runs with SelfEnv set, so
+// main can tell it apart with IsSelf and dispatch on the arguments before doing anything else.
+// The executable is located with os.Executable, Start fails if it can't be found.
+func Self(arg ...string) *Chain {
+
+	return newChain(selfStage(arg))
+
+}
+
+// Self adds a re-invocation of the current executable to the back of the command chain, see the
+// function Self.
+func (c *Chain) Self(arg ...string) *Chain {
+
+	c.stages = append(c.stages, selfStage(arg))
+	return c
+
+}
+
+// IsSelf reports whether the process was started by a command created with Self.
+func IsSelf() bool {
+
+	return os.Getenv(SelfEnv) != ""
+
+}
+
+func selfStage(arg []string) *stage {
+
+	s := &stage{env: []string{SelfEnv + "=1"}}
+	path, err := selfPath()
+	if err != nil {
+		s.cmd = &exec.Cmd{Path: os.Args[0], Args: append([]string{os.Args[0]}, arg...),
+			Err: errors.Wrap(err, "unable to locate the current executable")}
+		return s
+	}
+	s.cmd = exec.Command(path, arg...)
+	return s
+
+}
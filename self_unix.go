@@ -0,0 +1,23 @@
+//go:build !windows
+
+package piper
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// selfPath returns the path of the current executable. A symlink it was started through is
+// resolved, so the command keeps working if the link is changed while the process runs.
+func selfPath() (string, error) {
+
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved, nil
+	}
+	return path, nil
+
+}
@@ -0,0 +1,26 @@
+package piper
+
+import (
+	"os"
+	"strings"
+)
+
+// maxPath is the limit of paths not using the \\?\ prefix on Windows
+const maxPath = 260
+
+// selfPath returns the path of the current executable. Windows reports it with the \\?\ prefix
+// at times, which programs reading their own path from the command line trip over, so it is
+// dropped unless the path is too long to do without. Symlinks aren't resolved, EvalSymlinks
+// fails for executables on mapped network drives.
+func selfPath() (string, error) {
+
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if trimmed, ok := strings.CutPrefix(path, `\\?\`); ok && len(trimmed) < maxPath && !strings.HasPrefix(trimmed, `UNC\`) {
+		return trimmed, nil
+	}
+	return path, nil
+
+}
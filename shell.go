@@ -0,0 +1,114 @@
+package piper
+
+import (
+	"context"
+	"strings"
+)
+
+// ShellOption configures the shell used by Shell
+type ShellOption func(*shellConfig)
+
+type shellConfig struct {
+	ctx  context.Context
+	path string
+	args []string
+}
+
+// ShellPath runs the script with the shell at path, args are passed before the script.
+// E.g. ShellPath("/bin/bash", "-o", "pipefail", "-c").
+func ShellPath(path string, args ...string) ShellOption {
+
+	return func(cfg *shellConfig) {
+		cfg.path = path
+		cfg.args = args
+	}
+
+}
+
+// ShellContext runs the shell like exec.CommandContext.
+func ShellContext(ctx context.Context) ShellOption {
+
+	return func(cfg *shellConfig) {
+		cfg.ctx = ctx
+	}
+
+}
+
+// Shell creates a new Chain running script with the platform shell, "/bin/sh -c" on Unix and
+// "cmd.exe /C" on Windows. Everything else in piper runs commands without a shell, use this only
+// where shell features are really needed and quote untrusted values with QuoteArg or QuoteWindows.
+func Shell(script string, opts ...ShellOption) *Chain {
+
+	return newChain(shellStage(script, opts))
+
+}
+
+// Shell adds a script run by the platform shell to the back of the command chain, see Shell.
+func (c *Chain) Shell(script string, opts ...ShellOption) *Chain {
+
+	c.stages = append(c.stages, shellStage(script, opts))
+	return c
+
+}
+
+func shellStage(script string, opts []ShellOption) *stage {
+
+	var cfg shellConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &stage{cmd: shellCommand(cfg, script), ctx: cfg.ctx}
+
+}
+
+// QuoteArg quotes s for a POSIX shell so it is passed on as a single literal argument.
+func QuoteArg(s string) string {
+
+	if s == "" {
+		return "''"
+	}
+	if strings.IndexFunc(s, func(r rune) bool { return !shellSafe(r) }) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+
+}
+
+func shellSafe(r rune) bool {
+
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_@%+=:,./-", r)
+
+}
+
+// QuoteWindows quotes s so it is parsed as a single argument by CommandLineToArgvW and the
+// C runtime of most Windows programs. It doesn't protect against cmd.exe metacharacters.
+func QuoteWindows(s string) string {
+
+	if s != "" && !strings.ContainsAny(s, " \t\n\v\"") {
+		return s
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for _, r := range s {
+
+		switch r {
+		case '\\':
+			slashes++
+			b.WriteRune(r)
+			continue
+		case '"':
+			// backslashes in front of a quote must be escaped, then the quote itself
+			b.WriteString(strings.Repeat(`\`, slashes+1))
+		}
+		slashes = 0
+		b.WriteRune(r)
+
+	}
+	b.WriteString(strings.Repeat(`\`, slashes))
+	b.WriteByte('"')
+
+	return b.String()
+
+}
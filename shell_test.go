@@ -0,0 +1,112 @@
+package piper_test
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+	"testing"
+
+	"github.com/noxer/piper"
+)
+
+func TestQuoteArg(t *testing.T) {
+
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"", "''"},
+		{"plain", "plain"},
+		{"a/b.c-d_e,f:g=h@i%j+k", "a/b.c-d_e,f:g=h@i%j+k"},
+		{"a b", "'a b'"},
+		{"it's", `'it'\''s'`},
+		{"$HOME", "'$HOME'"},
+		{"a;b|c&d", "'a;b|c&d'"},
+		{"`id`", "'`id`'"},
+		{`"\`, `'"\'`},
+		{"a\nb", "'a\nb'"},
+		{"*", "'*'"},
+		{"~", "'~'"},
+		{"ü", "'ü'"},
+	}
+
+	sh := false
+	if _, err := exec.LookPath("sh"); err == nil && runtime.GOOS != "windows" {
+		sh = true
+	}
+	for _, tt := range tests {
+		got := piper.QuoteArg(tt.arg)
+		if got != tt.want {
+			t.Errorf("QuoteArg(%q) = %q, want %q", tt.arg, got, tt.want)
+		}
+		if !sh {
+			continue
+		}
+		// the shell gets back the original argument
+		out, err := piper.Shell("printf %s " + got).Output()
+		if err != nil || string(out) != tt.arg {
+			t.Errorf("sh got %q, %v for %q", out, err, tt.arg)
+		}
+	}
+
+}
+
+func TestQuoteWindows(t *testing.T) {
+
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"", `""`},
+		{"plain", "plain"},
+		{`C:\dir\file`, `C:\dir\file`},
+		{"a b", `"a b"`},
+		{"a\tb", "\"a\tb\""},
+		{`a"b`, `"a\"b"`},
+		{`a\"b`, `"a\\\"b"`},
+		{`a\\b c`, `"a\\b c"`},
+		{`dir\ x\`, `"dir\ x\\"`},
+		{`\\`, `\\`},
+	}
+
+	for _, tt := range tests {
+		if got := piper.QuoteWindows(tt.arg); got != tt.want {
+			t.Errorf("QuoteWindows(%q) = %s, want %s", tt.arg, got, tt.want)
+		}
+	}
+
+}
+
+func TestShell(t *testing.T) {
+
+	if _, err := exec.LookPath("sh"); err != nil || runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name  string
+		chain *piper.Chain
+		want  string
+		ok    bool
+	}{
+		{"pipeline", piper.Shell("echo a b | tr ' ' '\\n'"), "a\nb\n", true},
+		{"appended", piper.Func(writer("in\n")).Shell("cat; echo out"), "in\nout\n", true},
+		{"exit status", piper.Shell("echo x; exit 3"), "x\n", false},
+		{"path", piper.Shell("echo $0", piper.ShellPath("sh", "-c")), "sh\n", true},
+		{"path arguments", piper.Shell("false | true", piper.ShellPath("sh", "-e", "-c")), "", true},
+		{"context", piper.Shell("echo x", piper.ShellContext(canceled)), "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.chain.Output()
+			if (err == nil) != tt.ok || string(out) != tt.want {
+				t.Errorf("got %q, %v, want %q, ok %v", out, err, tt.want, tt.ok)
+			}
+		})
+	}
+
+}
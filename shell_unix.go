@@ -0,0 +1,22 @@
+//go:build !windows
+
+package piper
+
+import (
+	"os/exec"
+)
+
+func shellCommand(cfg shellConfig, script string) *exec.Cmd {
+
+	path, args := cfg.path, cfg.args
+	if path == "" {
+		path, args = "/bin/sh", []string{"-c"}
+	}
+	args = append(append([]string(nil), args...), script)
+
+	if cfg.ctx != nil {
+		return exec.CommandContext(cfg.ctx, path, args...)
+	}
+	return exec.Command(path, args...)
+
+}
@@ -0,0 +1,36 @@
+package piper
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// shellCommand passes the script verbatim because cmd.exe doesn't follow the
+// quoting rules os/exec applies to arguments.
+func shellCommand(cfg shellConfig, script string) *exec.Cmd {
+
+	path, args := cfg.path, cfg.args
+	if path == "" {
+		path = os.Getenv("COMSPEC")
+		if path == "" {
+			path = "cmd.exe"
+		}
+		args = []string{"/S", "/C"}
+	}
+
+	var cmd *exec.Cmd
+	if cfg.ctx != nil {
+		cmd = exec.CommandContext(cfg.ctx, path)
+	} else {
+		cmd = exec.Command(path)
+	}
+
+	cmd.Args = append(append(cmd.Args[:1:1], args...), script)
+	line := append([]string{QuoteWindows(path)}, args...)
+	line = append(line, `"`+script+`"`)
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: strings.Join(line, " ")}
+	return cmd
+
+}
@@ -0,0 +1,36 @@
+package piper
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Shutdown stops a started chain gracefully. It sends SIGTERM to the stages, see Signal, drains
+// the keep-open links and waits for the stages to exit. If ctx is done before, the commands are
+// killed. Either way the stages are reaped and the error of Wait is returned, so Wait must not be
+// called again. The grace period is the deadline of ctx, without one the processes are never
+// killed. Functions can't be signaled, they have to return on their own once their input or
+// output is closed. On Windows the processes are killed right away.
+func (c *Chain) Shutdown(ctx context.Context) error {
+
+	done := make(chan error, 1)
+	c.labeled(-1, "wait", func() {
+		go func() { done <- c.Wait() }()
+	})
+
+	atomic.StoreInt32(&c.killed, 1)
+	if err := c.signal(terminateSignal); err != nil {
+		c.Kill()
+	}
+	c.Drain()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	c.Kill()
+	return <-done
+
+}
@@ -0,0 +1,106 @@
+//go:build !windows
+
+package piper
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// terminateSignal asks the processes to exit, see Chain.Shutdown
+var terminateSignal os.Signal = syscall.SIGTERM
+
+// dispositionMu is held exclusively by the starts changing the signal dispositions of the
+// process and shared by all other starts of piper, so no other command inherits them
+var dispositionMu sync.RWMutex
+
+// startCmd starts cmd with the default disposition of the signals reset. A child inherits the
+// ignored signals of the parent, all others are reset to their default on exec. So the signals
+// are handled instead of ignored while cmd starts and ignored again afterwards. The other starts
+// of piper wait meanwhile, see Chain.ResetSignals.
+func startCmd(cmd *exec.Cmd, reset []os.Signal) error {
+
+	if len(reset) == 0 {
+		dispositionMu.RLock()
+		defer dispositionMu.RUnlock()
+		return cmd.Start()
+	}
+
+	dispositionMu.Lock()
+	defer dispositionMu.Unlock()
+
+	var ignored []os.Signal
+	for _, sig := range reset {
+		if signal.Ignored(sig) {
+			ignored = append(ignored, sig)
+		}
+	}
+	if len(ignored) == 0 {
+		return cmd.Start()
+	}
+
+	ch := make(chan os.Signal, len(ignored))
+	signal.Notify(ch, ignored...)
+	err := cmd.Start()
+	signal.Stop(ch)
+	signal.Ignore(ignored...)
+	return err
+
+}
+
+// pause stops the processes of all started commands.
+func (c *Chain) pause() error {
+
+	return c.signal(syscall.SIGSTOP)
+
+}
+
+// resume continues the processes stopped by pause.
+func (c *Chain) resume() error {
+
+	return c.signal(syscall.SIGCONT)
+
+}
+
+func (c *Chain) signal(sig os.Signal) error {
+
+	if pgid := int(atomic.LoadInt32(&c.pgid)); pgid != 0 {
+		if ssig, ok := sig.(syscall.Signal); ok {
+			err := syscall.Kill(-pgid, ssig)
+			if err != nil && err != syscall.ESRCH {
+				return err
+			}
+			return nil
+		}
+	}
+
+	for _, s := range c.stages {
+		if s.cmd == nil || s.cmd.Process == nil {
+			continue
+		}
+		if err := s.cmd.Process.Signal(sig); err != nil && err != os.ErrProcessDone {
+			return err
+		}
+	}
+	return nil
+
+}
+
+// setGroup puts the command of s into the process group of the chain, the first command
+// becomes its leader, see WithProcessGroup.
+func (c *Chain) setGroup(s *stage) {
+
+	attr := &syscall.SysProcAttr{}
+	if s.cmd.SysProcAttr != nil {
+		// the attributes are shared with the clones of the chain
+		*attr = *s.cmd.SysProcAttr
+	}
+	attr.Setpgid = true
+	attr.Pgid = int(atomic.LoadInt32(&c.pgid))
+	s.cmd.SysProcAttr = attr
+
+}
@@ -0,0 +1,48 @@
+package piper
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// terminateSignal kills the processes, Windows can't ask them to exit
+var terminateSignal = os.Kill
+
+// startCmd starts cmd, Windows has no signal dispositions to reset.
+func startCmd(cmd *exec.Cmd, reset []os.Signal) error {
+
+	return cmd.Start()
+
+}
+
+// pause isn't possible on Windows, processes can't be stopped.
+func (c *Chain) pause() error {
+
+	return errors.New("piper: pausing commands is not supported on windows")
+
+}
+
+func (c *Chain) resume() error {
+
+	return nil
+
+}
+
+func (c *Chain) signal(sig os.Signal) error {
+
+	for _, s := range c.stages {
+		if s.cmd == nil || s.cmd.Process == nil {
+			continue
+		}
+		if err := s.cmd.Process.Signal(sig); err != nil && err != os.ErrProcessDone {
+			return err
+		}
+	}
+	return nil
+
+}
+
+// setGroup does nothing, process groups are a Unix feature.
+func (c *Chain) setGroup(s *stage) {}
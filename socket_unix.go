@@ -0,0 +1,30 @@
+//go:build !windows
+
+package piper
+
+import (
+	"os"
+	"syscall"
+)
+
+// socketPair creates a connected pair of Unix sockets for kind.
+func socketPair(kind LinkKind) (a, b *os.File, err error) {
+
+	typ := syscall.SOCK_STREAM
+	if kind == PacketLink {
+		typ = syscall.SOCK_SEQPACKET
+	}
+
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, typ, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, nil, os.NewSyscallError("socketpair", err)
+	}
+	return os.NewFile(uintptr(fds[0]), "socket"), os.NewFile(uintptr(fds[1]), "socket"), nil
+
+}
@@ -0,0 +1,14 @@
+package piper
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// socketPair isn't supported on Windows.
+func socketPair(kind LinkKind) (a, b *os.File, err error) {
+
+	return nil, nil, errors.New("piper: socket links are not supported on windows")
+
+}
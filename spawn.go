@@ -0,0 +1,83 @@
+package piper
+
+import (
+	"os/exec"
+	"time"
+)
+
+// SpawnRetry configures the retries of commands which fail to start with a transient error, like
+// ETXTBSY right after the binary was written or EAGAIN from fork under load
+type SpawnRetry struct {
+	// Retries is the maximum number of retries per command, zero disables them
+	Retries int
+	// Backoff is the delay before the first retry, it doubles with every retry
+	Backoff time.Duration
+}
+
+// DefaultSpawnRetry is used by chains without WithSpawnRetry
+var DefaultSpawnRetry = SpawnRetry{Retries: 3, Backoff: 10 * time.Millisecond}
+
+// WithSpawnRetry sets how often commands failing to start with a transient error are retried.
+// The retries are recorded in StageResult.SpawnRetries.
+func WithSpawnRetry(r SpawnRetry) Option {
+
+	return func(c *Chain) { c.spawnRetry = &r }
+
+}
+
+// spawnRetries returns the retry configuration of the chain.
+func (c *Chain) spawnRetries() SpawnRetry {
+
+	if c.spawnRetry == nil {
+		return DefaultSpawnRetry
+	}
+	return *c.spawnRetry
+
+}
+
+// spawn starts the command of the stage, retrying transient errors. A command whose pipes were
+// created by exec, see StdinPipe, can't be retried since exec closes them when Start fails.
+func (s *stage) spawn() error {
+
+	err := startCmd(s.cmd, s.resetSignals)
+	delay := s.retry.Backoff
+	for s.retries < s.retry.Retries && !s.piped && transient(err) {
+
+		time.Sleep(delay)
+		delay *= 2
+		s.retries++
+
+		// a Cmd can only be started once
+		s.cmd = s.recreate()
+		err = startCmd(s.cmd, s.resetSignals)
+
+	}
+	return err
+
+}
+
+// recreate returns an unstarted copy of the command of the stage.
+func (s *stage) recreate() *exec.Cmd {
+
+	var cmd *exec.Cmd
+	if s.ctx != nil {
+		cmd = exec.CommandContext(s.ctx, s.cmd.Path)
+	} else {
+		cmd = &exec.Cmd{Path: s.cmd.Path}
+	}
+
+	cmd.Args = s.cmd.Args
+	cmd.Env = s.cmd.Env
+	cmd.Dir = s.cmd.Dir
+	cmd.Stdin = s.cmd.Stdin
+	cmd.Stdout = s.cmd.Stdout
+	cmd.Stderr = s.cmd.Stderr
+	cmd.ExtraFiles = s.cmd.ExtraFiles
+	cmd.SysProcAttr = s.cmd.SysProcAttr
+	cmd.WaitDelay = s.cmd.WaitDelay
+	if s.cancel != nil {
+		cmd.Cancel = s.cancel
+	}
+	return cmd
+
+}
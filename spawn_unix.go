@@ -0,0 +1,19 @@
+//go:build !windows
+
+package piper
+
+import (
+	"os"
+	"syscall"
+)
+
+// transient reports whether err of starting a command is worth a retry.
+func transient(err error) bool {
+
+	pe, ok := err.(*os.PathError)
+	if !ok {
+		return false
+	}
+	return pe.Err == syscall.ETXTBSY || pe.Err == syscall.EAGAIN
+
+}
@@ -0,0 +1,21 @@
+package piper
+
+import (
+	"os"
+	"syscall"
+)
+
+// errSharingViolation is ERROR_SHARING_VIOLATION, which is missing from syscall
+const errSharingViolation = syscall.Errno(32)
+
+// transient reports whether err of starting a command is worth a retry. A binary still open
+// for writing fails with a sharing violation on Windows.
+func transient(err error) bool {
+
+	pe, ok := err.(*os.PathError)
+	if !ok {
+		return false
+	}
+	return pe.Err == errSharingViolation
+
+}
@@ -0,0 +1,122 @@
+package piper
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// ChunkPlaceholder is replaced by the chunk index in the arguments of the commands run by Split
+const ChunkPlaceholder = "{chunk}"
+
+// ChunkEnv is the environment variable holding the chunk index for the commands run by Split
+const ChunkEnv = "PIPER_CHUNK"
+
+// Split adds a stage to the back of the chain which cuts its input into chunks of size bytes and
+// runs a copy of sub for every chunk, like split(1) followed by a loop. Up to parallelism chunks
+// are processed at the same time (and held in memory), the outputs are written in chunk order.
+// The first failing chunk stops the stage.
+func (c *Chain) Split(size, parallelism int, sub *Chain) *Chain {
+
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	return c.Func(func(r io.Reader, w io.Writer) error {
+
+		type chunkResult struct {
+			out []byte
+			err error
+		}
+
+		var failed int32
+		order := make(chan chan chunkResult, parallelism-1)
+		written := make(chan error, 1)
+		go func() {
+			var err error
+			for res := range order {
+				out := <-res
+				if err == nil {
+					err = out.err
+				}
+				if err == nil {
+					_, err = w.Write(out.out)
+				}
+				if err != nil {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+			written <- err
+		}()
+
+		var rerr error
+		for i := 0; atomic.LoadInt32(&failed) == 0; i++ {
+
+			buf := make([]byte, size)
+			n, err := io.ReadFull(r, buf)
+			if n > 0 {
+				res := make(chan chunkResult, 1)
+				order <- res
+				go func(i int, data []byte) {
+					out, err := runChunk(sub, i, data)
+					res <- chunkResult{out: out, err: errors.Wrapf(err, "chunk #%d failed", i)}
+				}(i, buf[:n])
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				rerr = err
+				break
+			}
+
+		}
+
+		close(order)
+		err := <-written
+		if rerr != nil {
+			return rerr
+		}
+		return err
+
+	})
+
+}
+
+// runChunk runs a copy of sub with the chunk index i in its arguments and environment.
+func runChunk(sub *Chain, i int, data []byte) ([]byte, error) {
+
+	index := strconv.Itoa(i)
+	c := sub.Clone()
+	for _, s := range c.stages {
+
+		if s.cmd == nil {
+			continue
+		}
+		// the arguments and the environment of the clone are shared with sub, see stage.clone
+		args := make([]string, len(s.cmd.Args))
+		for j, arg := range s.cmd.Args {
+			if j > 0 {
+				arg = strings.ReplaceAll(arg, ChunkPlaceholder, index)
+			}
+			args[j] = arg
+		}
+		s.cmd.Args = args
+		env := s.cmd.Env
+		if env == nil {
+			env = os.Environ()
+		}
+		s.cmd.Env = append(env[:len(env):len(env)], ChunkEnv+"="+index)
+
+	}
+
+	var out bytes.Buffer
+	err := runWith(c, bytes.NewReader(data), &out)
+	return out.Bytes(), err
+
+}
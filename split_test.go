@@ -0,0 +1,31 @@
+package piper_test
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/noxer/piper"
+)
+
+func TestSplitChunkIndex(t *testing.T) {
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("needs sh")
+	}
+
+	for _, parallelism := range []int{1, 3} {
+
+		c := piper.New().Split(4, parallelism, piper.Command("sh", "-c", `echo "chunk-{chunk} $PIPER_CHUNK"`))
+		c.Stdin = strings.NewReader("aaaabbbbcccc")
+		out, err := c.Output()
+		if err != nil {
+			t.Fatalf("parallelism %d: %v", parallelism, err)
+		}
+		if want := "chunk-0 0\nchunk-1 1\nchunk-2 2\n"; string(out) != want {
+			t.Errorf("parallelism %d: got %q, want %q", parallelism, out, want)
+		}
+
+	}
+
+}
@@ -0,0 +1,418 @@
+package piper
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StageFunc is an in-process stage of a chain. It reads the output of the previous stage from r
+// and writes its own output to w. Returning an error fails the stage.
+type StageFunc func(r io.Reader, w io.Writer) error
+
+// ErrPanic is the cause of the error of a function stage which panicked
+var ErrPanic = errors.New("piper: function stage panicked")
+
+// StageContextFunc is a StageFunc receiving a context, see Chain.FuncContext.
+type StageContextFunc func(ctx context.Context, r io.Reader, w io.Writer) error
+
+// stage is a single element of a chain, either an external command or an in-process function
+type stage struct {
+	cmd *exec.Cmd
+	ctx context.Context
+	fn  StageFunc
+	cfn StageContextFunc
+	// runCtx is passed to cfn, it is derived from ctx when the chain starts
+	runCtx context.Context
+	// pool is set if cmd is an instance of a Pool, warm if it was started ahead of time
+	pool *Pool
+	warm *warmProc
+	// virtual is the name of the registered command implemented by cfn
+	virtual string
+	// retry configures the retries of a failing start, retries counts them. piped is set if
+	// exec created pipes for the command, which prevents retries.
+	retry   SpawnRetry
+	retries int
+	piped   bool
+	// cancel is the Cancel of the command set by the chain, it survives retries, see WithCancel
+	cancel func() error
+	// listen is the name of the port the command must listen on before the next stage starts
+	listen        string
+	listenTimeout time.Duration
+	// link is the kind of the connection to the next stage, streams the streams feeding it
+	link    LinkKind
+	streams Streams
+	// errNext is the link to the next stage if stderr feeds it
+	errNext io.Writer
+	// env is added to the environment of the command, see StageEnv
+	env []string
+	// untrusted holds the indexes of the arguments from an untrusted source, see ArgList.Untrusted,
+	// sep the index of the end of options marker added by piper, 0 if there is none, and noSep
+	// is set by StageSeparator
+	untrusted []int
+	sep       int
+	noSep     bool
+	// termSize is set if the command runs on a terminal, term is the terminal while it runs
+	termSize *WindowSize
+	term     *terminal
+
+	ignoreFailure bool
+	negate        bool
+	// resetSignals are set to their default disposition when the command starts
+	resetSignals []os.Signal
+
+	stdin   io.Reader
+	stdout  io.Writer
+	closers []io.Closer
+	// closeAfterWait holds writers handed to a command which are closed once it exited
+	closeAfterWait []io.Closer
+	done           chan error
+	waited         bool
+
+	// status, pid and code can be read while the chain runs, see Debug. began and ended are the
+	// times the stage started and exited in Unix nanoseconds, see Stats.
+	status int32
+	pid    int32
+	code   int32
+	began  int64
+	ended  int64
+}
+
+// Values of stage.status
+const (
+	stageIdle int32 = iota
+	stageRunning
+	stageExited
+)
+
+// name returns a human readable name of the stage for error messages.
+func (s *stage) name() string {
+
+	if s.cmd != nil {
+		return s.cmd.Path
+	}
+	if s.virtual != "" {
+		return s.virtual
+	}
+	return "func"
+
+}
+
+// role names the goroutines of the stage in pprof labels.
+func (s *stage) role() string {
+
+	if s.cmd != nil {
+		return "command"
+	}
+	return "func"
+
+}
+
+func (s *stage) setStdin(r io.Reader) {
+
+	if s.warm != nil {
+		s.warm.in = r
+		return
+	}
+	if s.cmd != nil {
+		s.cmd.Stdin = r
+		return
+	}
+	s.stdin = r
+
+}
+
+func (s *stage) setStdout(w io.Writer) {
+
+	if s.warm != nil {
+		s.warm.out = w
+		return
+	}
+	if s.cmd != nil {
+		s.cmd.Stdout = w
+		return
+	}
+	s.stdout = w
+
+}
+
+// hasStdout reports whether the stdout of the stage was already set up, e.g. by StdoutPipe.
+func (s *stage) hasStdout() bool {
+
+	if s.warm != nil {
+		return s.warm.out != nil || s.warm.piped[1]
+	}
+	if s.cmd != nil {
+		return s.cmd.Stdout != nil
+	}
+	return s.stdout != nil
+
+}
+
+func (s *stage) setStderr(w io.Writer) {
+
+	if s.warm != nil {
+		s.warm.errw = w
+		return
+	}
+	if s.cmd != nil {
+		s.cmd.Stderr = w
+	}
+
+}
+
+// own hands a pipe end to the stage. Commands close it after they started (the child holds its
+// own copy), functions close it when they return.
+func (s *stage) own(c io.Closer) {
+
+	s.closers = append(s.closers, c)
+
+}
+
+func (s *stage) closeOwned() {
+
+	for _, c := range s.closers {
+		c.Close()
+	}
+	s.closers = nil
+
+}
+
+func (s *stage) stdinPipe() (io.WriteCloser, error) {
+
+	if s.warm != nil {
+		s.warm.piped[0] = true
+		return s.warm.stdin, nil
+	}
+	if s.cmd != nil && s.termSize == nil {
+		s.piped = true
+		return s.cmd.StdinPipe()
+	}
+
+	// the pipes of a command running on a terminal are copied by the terminal
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	s.setStdin(r)
+	s.own(r)
+	return w, nil
+
+}
+
+func (s *stage) stdoutPipe() (io.ReadCloser, error) {
+
+	if s.warm != nil {
+		s.warm.piped[1] = true
+		return s.warm.stdout, nil
+	}
+	if s.cmd != nil && s.termSize == nil {
+		s.piped = true
+		return s.cmd.StdoutPipe()
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	s.setStdout(w)
+	s.own(w)
+	return r, nil
+
+}
+
+func (s *stage) stderrPipe() (io.ReadCloser, error) {
+
+	if s.warm != nil {
+		s.warm.piped[2] = true
+		return s.warm.stderr, nil
+	}
+	if s.termSize != nil {
+		return nil, errors.New("piper: the stderr of a command on a terminal is its stdout")
+	}
+	if s.cmd != nil {
+		s.piped = true
+		return s.cmd.StderrPipe()
+	}
+	return nil, errors.New("piper: function stages have no stderr")
+
+}
+
+func (s *stage) start() error {
+
+	if s.warm != nil {
+		atomic.StoreInt32(&s.pid, int32(s.cmd.Process.Pid))
+		s.running()
+		s.warm.start(s.closeOwned)
+		return nil
+	}
+	if s.cmd != nil {
+		err := s.spawn()
+		s.closeOwned()
+		if err == nil {
+			atomic.StoreInt32(&s.pid, int32(s.cmd.Process.Pid))
+			s.running()
+		}
+		return err
+	}
+
+	s.done = make(chan error, 1)
+	s.running()
+	go s.run()
+	return nil
+
+}
+
+func (s *stage) run() {
+
+	r := s.stdin
+	if r == nil {
+		r = strings.NewReader("")
+	}
+	w := s.stdout
+	if w == nil {
+		w = io.Discard
+	}
+
+	err := s.call(r, w)
+	s.closeOwned()
+	s.exited(err)
+	s.done <- err
+
+}
+
+// call runs the function of the stage, a panic fails the stage with the cause ErrPanic.
+func (s *stage) call(r io.Reader, w io.Writer) (err error) {
+
+	defer func() {
+		if v := recover(); v != nil {
+			err = errors.Wrapf(ErrPanic, "%v", v)
+		}
+	}()
+
+	if s.cfn != nil {
+		return s.cfn(s.runCtx, r, w)
+	}
+	return s.fn(r, w)
+
+}
+
+func (s *stage) wait() error {
+
+	if s.cmd != nil {
+		err := s.cmd.Wait()
+		if s.warm != nil {
+			s.warm.wait()
+		}
+		if s.cmd.ProcessState != nil {
+			s.exited(err)
+		}
+		for _, c := range s.closeAfterWait {
+			c.Close()
+		}
+		s.closeAfterWait = nil
+		return err
+	}
+
+	if s.done == nil {
+		return errors.New("piper: not started")
+	}
+	if s.waited {
+		return errors.New("piper: Wait was already called")
+	}
+	s.waited = true
+	return <-s.done
+
+}
+
+// waitNegated waits for the stage and inverts the result if the stage is negated.
+func (s *stage) waitNegated() error {
+
+	err := s.wait()
+	if s.negate {
+		return negate(err)
+	}
+	return err
+
+}
+
+// running records the start of the stage.
+func (s *stage) running() {
+
+	atomic.StoreInt64(&s.began, time.Now().UnixNano())
+	atomic.StoreInt32(&s.status, stageRunning)
+
+}
+
+// exited records the exit of the stage.
+func (s *stage) exited(err error) {
+
+	code := 0
+	if s.cmd != nil {
+		code = s.cmd.ProcessState.ExitCode()
+	} else if err != nil {
+		code = 1
+	}
+	atomic.StoreInt32(&s.code, int32(code))
+	atomic.StoreInt64(&s.ended, time.Now().UnixNano())
+	atomic.StoreInt32(&s.status, stageExited)
+
+}
+
+// kill kills the process of a started command, functions can't be killed.
+func (s *stage) kill() error {
+
+	if s.cmd == nil || s.cmd.Process == nil {
+		return nil
+	}
+	return s.cmd.Process.Kill()
+
+}
+
+// clone creates a fresh copy of the stage which can be started independently. The copy is
+// written to n, cmd is used for the command unless the stage has a context. The arguments and
+// the environment are shared, exec never modifies them.
+func (s *stage) clone(n *stage, cmd *exec.Cmd) {
+
+	*n = stage{fn: s.fn, cfn: s.cfn, ctx: s.ctx, ignoreFailure: s.ignoreFailure, negate: s.negate,
+		resetSignals: s.resetSignals, virtual: s.virtual, listen: s.listen, listenTimeout: s.listenTimeout, link: s.link,
+		streams: s.streams, env: s.env, untrusted: s.untrusted, sep: s.sep, noSep: s.noSep, termSize: s.termSize,
+		pool: s.pool}
+	if s.cmd == nil {
+		return
+	}
+
+	if s.ctx != nil {
+		cmd = exec.CommandContext(s.ctx, s.cmd.Path)
+	} else {
+		cmd.Path = s.cmd.Path
+	}
+
+	cmd.Args = s.cmd.Args
+	cmd.Env = s.cmd.Env
+	cmd.Dir = s.cmd.Dir
+	cmd.ExtraFiles = s.cmd.ExtraFiles
+	cmd.SysProcAttr = s.cmd.SysProcAttr
+	cmd.Err = s.cmd.Err
+	n.cmd = cmd
+
+}
+
+// disown removes v from the pipe ends owned by the stage and returns it if it was owned.
+func (s *stage) disown(v any) io.Closer {
+
+	for i, c := range s.closers {
+		if c == v {
+			s.closers = append(s.closers[:i:i], s.closers[i+1:]...)
+			return c
+		}
+	}
+	return nil
+
+}
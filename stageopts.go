@@ -0,0 +1,92 @@
+package piper
+
+import (
+	"os"
+	"syscall"
+)
+
+// StageOption configures the command of a stage, see Chain.Configure. Cmd and CommandArgs take
+// them when the command is added, Command can't, its variadic parameter takes the arguments.
+type StageOption func(*stage)
+
+// StageEnv adds the variables env, in the form "KEY=value", to the environment the command
+// inherits. They take precedence over inherited variables of the same name.
+func StageEnv(env ...string) StageOption {
+
+	return func(s *stage) {
+		s.env = append(s.env[:len(s.env):len(s.env)], env...)
+	}
+
+}
+
+// StageDir runs the command in dir.
+func StageDir(dir string) StageOption {
+
+	return func(s *stage) {
+		if s.cmd != nil {
+			s.cmd.Dir = dir
+		}
+	}
+
+}
+
+// StageSysProcAttr sets the OS specific attributes of the command, see exec.Cmd.SysProcAttr.
+// WithProcessGroup still puts the command into the process group of the chain.
+func StageSysProcAttr(attr *syscall.SysProcAttr) StageOption {
+
+	return func(s *stage) {
+		if s.cmd != nil {
+			s.cmd.SysProcAttr = attr
+		}
+	}
+
+}
+
+// StageSeparator sets whether the end of options marker "--" is inserted in front of the first
+// untrusted argument of the command, see ArgList.Untrusted, which it is by default. The arguments
+// after it aren't taken for flags then, even if they start with "-". Commands which don't know the
+// marker are run with StageSeparator(false), an untrusted argument starting with "-" fails their
+// start unless ArgList.Separator precedes it.
+func StageSeparator(insert bool) StageOption {
+
+	return func(s *stage) { s.noSep = !insert }
+
+}
+
+// Configure applies opts to the last added stage, so its command can be customized without
+// touching the exec.Cmd, e.g. c.Command("sort").Configure(piper.StageDir("/tmp")). The options
+// have no effect on function stages and the instances of a Pool. It must be called before Start.
+func (c *Chain) Configure(opts ...StageOption) *Chain {
+
+	c.last().configure(opts)
+	return c
+
+}
+
+// configure applies opts to the stage unless it runs an instance of a Pool.
+func (s *stage) configure(opts []StageOption) *stage {
+
+	if s.pool != nil {
+		return s
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+
+}
+
+// applyEnv adds the variables of StageEnv to the environment of the command.
+func (s *stage) applyEnv() {
+
+	if len(s.env) == 0 {
+		return
+	}
+	env := s.cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	// the environment may be shared with other commands, e.g. by FastStart or clones
+	s.cmd.Env = append(env[:len(env):len(env)], s.env...)
+
+}
@@ -0,0 +1,53 @@
+package piper_test
+
+import (
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/noxer/piper"
+)
+
+func TestStageOptions(t *testing.T) {
+
+	if _, err := exec.LookPath("sh"); err != nil || runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+
+	dir := t.TempDir()
+	script := piper.NewArgList().Flag("-c").Untrusted(`echo "$STAGE_VAR"; pwd -P`)
+	opts := []piper.StageOption{piper.StageEnv("STAGE_VAR=set"), piper.StageDir(dir)}
+	tests := []struct {
+		name  string
+		chain func() *piper.Chain
+	}{
+		{"CommandArgs", func() *piper.Chain { return piper.CommandArgs("sh", script, opts...) }},
+		{"CommandContextArgs", func() *piper.Chain {
+			return piper.CommandContextArgs(t.Context(), "sh", script, opts...)
+		}},
+		{"appended CommandArgs", func() *piper.Chain {
+			return piper.Command("true").CommandArgs("sh", script, opts...)
+		}},
+		{"Cmd", func() *piper.Chain {
+			return piper.Cmd(exec.Command("sh", "-c", `echo "$STAGE_VAR"; pwd -P`), opts...)
+		}},
+		{"appended Cmd", func() *piper.Chain {
+			return piper.Command("true").Cmd(exec.Command("sh", "-c", `echo "$STAGE_VAR"; pwd -P`), opts...)
+		}},
+		{"Configure", func() *piper.Chain {
+			return piper.Command("sh", "-c", `echo "$STAGE_VAR"; pwd -P`).Configure(opts...)
+		}},
+	}
+
+	real, _ := filepath.EvalSymlinks(dir)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.chain().Output()
+			if want := "set\n" + real + "\n"; err != nil || string(out) != want {
+				t.Errorf("got %q, %v, want %q", out, err, want)
+			}
+		})
+	}
+
+}
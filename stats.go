@@ -0,0 +1,103 @@
+package piper
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Stats reports the throughput of the stages of a chain, see Chain.Stats
+type Stats struct {
+	Stages []StageStats
+	// Duration is the time from the start of the chain until the last stage exited, or until now
+	// while stages still run
+	Duration time.Duration
+}
+
+// StageStats reports the throughput of a single stage
+type StageStats struct {
+	// Index is the position of the stage, Path the path of the command or "func"
+	Index int
+	Path  string
+	// BytesIn and BytesOut count the bytes the stage read from its input and wrote to its output.
+	// They are -1 for links which don't pass through the parent process, see Chain.Instrument.
+	BytesIn  int64
+	BytesOut int64
+	// Wall is the time the stage ran, until now if it still runs. Commands count as running until
+	// Wait reaped them.
+	Wall time.Duration
+}
+
+// Throughput returns the bytes the stage wrote per second of its wall time, -1 if they weren't
+// counted. The stage with the lowest throughput is usually the bottleneck of the chain.
+func (s StageStats) Throughput() float64 {
+
+	if s.BytesOut < 0 || s.Wall <= 0 {
+		return -1
+	}
+	return float64(s.BytesOut) / s.Wall.Seconds()
+
+}
+
+// Stats reports the bytes in and out and the wall time of every stage. It can be called while the
+// chain runs and after Wait. Only links passing through the parent process are counted, set
+// Chain.Instrument to count all of them including the input and output of the chain.
+func (c *Chain) Stats() *Stats {
+
+	now := time.Now()
+	links := make([]int64, len(c.stages)+1)
+	for i := range links {
+		links[i] = -1
+	}
+	if c.input != nil {
+		links[0] = c.input.bytes()
+	}
+	for _, rl := range c.relays {
+		links[rl.from+1] = rl.bytes()
+	}
+	if c.output != nil {
+		links[len(c.stages)] = c.output.bytes()
+	}
+
+	st := &Stats{Stages: make([]StageStats, len(c.stages))}
+	var end time.Time
+	running := false
+	for i, s := range c.stages {
+
+		ss := StageStats{Index: i, Path: s.name(), BytesIn: links[i], BytesOut: links[i+1]}
+		began, ended := atomic.LoadInt64(&s.began), atomic.LoadInt64(&s.ended)
+		switch {
+		case began == 0:
+		case ended == 0:
+			ss.Wall = now.Sub(time.Unix(0, began))
+			running = true
+		default:
+			ss.Wall = time.Duration(ended - began)
+			if t := time.Unix(0, ended); t.After(end) {
+				end = t
+			}
+		}
+		st.Stages[i] = ss
+
+	}
+
+	if running {
+		end = now
+	}
+	if !c.started.IsZero() && !end.IsZero() {
+		st.Duration = end.Sub(c.started)
+	}
+	return st
+
+}
+
+// countInput counts the bytes read from in for Stats if the chain is instrumented.
+func (c *Chain) countInput(in io.Reader) io.Reader {
+
+	if !c.Instrument {
+		return in
+	}
+	c.input = &meter{w: io.Discard}
+	return io.TeeReader(in, c.input)
+
+}
@@ -0,0 +1,40 @@
+package piper
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// ExitStatus describes how the process of a command terminated, see StageResult.Exit
+type ExitStatus struct {
+	// Code is the exit code of the process, -1 if it was terminated by a signal. On Windows it is
+	// the full 32 bit exit code, e.g. 0xC0000005 for an access violation.
+	Code int
+	// Signaled is set if a signal terminated the process, Signal is the signal then
+	Signaled bool
+	Signal   syscall.Signal
+	// CoreDumped is set if the process dumped core
+	CoreDumped bool
+	// Crashed is set if the process died from a fault rather than reporting a failure, i.e. it
+	// was terminated by a signal like SIGSEGV or SIGABRT, or exited with an NTSTATUS error on Windows
+	Crashed bool
+}
+
+// Success reports whether the process exited with code zero.
+func (s ExitStatus) Success() bool {
+
+	return !s.Signaled && s.Code == 0
+
+}
+
+func (s ExitStatus) String() string {
+
+	if !s.Signaled {
+		return fmt.Sprintf("exit status %d", s.Code)
+	}
+	if s.CoreDumped {
+		return "signal: " + s.Signal.String() + " (core dumped)"
+	}
+	return "signal: " + s.Signal.String()
+
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+
+package piper
+
+import (
+	"os"
+	"syscall"
+)
+
+// exitStatus returns the termination details of ps.
+func exitStatus(ps *os.ProcessState) *ExitStatus {
+
+	st := &ExitStatus{Code: ps.ExitCode()}
+	ws, ok := ps.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return st
+	}
+
+	st.Signaled = true
+	st.Signal = ws.Signal()
+	st.CoreDumped = ws.CoreDump()
+	switch st.Signal {
+	case syscall.SIGSEGV, syscall.SIGBUS, syscall.SIGILL, syscall.SIGFPE, syscall.SIGABRT, syscall.SIGTRAP, syscall.SIGSYS:
+		st.Crashed = true
+	default:
+		st.Crashed = st.CoreDumped
+	}
+	return st
+
+}
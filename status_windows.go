@@ -0,0 +1,18 @@
+package piper
+
+import (
+	"os"
+	"syscall"
+)
+
+// exitStatus returns the termination details of ps. Windows has no signals, crashes show up as
+// exit codes with the NTSTATUS error severity.
+func exitStatus(ps *os.ProcessState) *ExitStatus {
+
+	code := ps.ExitCode()
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok {
+		code = int(ws.ExitCode)
+	}
+	return &ExitStatus{Code: code, Crashed: uint32(code)&0xC0000000 == 0xC0000000}
+
+}
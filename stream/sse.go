@@ -0,0 +1,97 @@
+package stream
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+)
+
+// SSE streams the sources to the client of req as Server-Sent Events. Every chunk of data becomes
+// one event named after its source, line breaks ("\n", "\r\n" or a lone "\r") are preserved by
+// splitting the data into several data fields, the client receives them as "\n". Heartbeats are
+// sent as comments. SSE returns once all sources are exhausted, the client went away or sending
+// failed.
+func SSE(w http.ResponseWriter, req *http.Request, opts Options, srcs ...Source) error {
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	s := &sseSender{w: w, rc: http.NewResponseController(w), timeout: opts.WriteTimeout}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	return pump(req.Context(), s, opts, srcs)
+
+}
+
+type sseSender struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+	buf     bytes.Buffer
+}
+
+func (s *sseSender) send(event string, data []byte) error {
+
+	s.buf.Reset()
+	s.buf.WriteString("event: ")
+	s.buf.WriteString(event)
+	s.buf.WriteByte('\n')
+	// a carriage return ends a field just like a newline, see the SSE specification
+	for {
+		i := bytes.IndexAny(data, "\r\n")
+		if i < 0 {
+			break
+		}
+		s.field(data[:i])
+		if data[i] == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+			i++
+		}
+		data = data[i+1:]
+	}
+	s.field(data)
+	s.buf.WriteByte('\n')
+	return s.write()
+
+}
+
+func (s *sseSender) field(line []byte) {
+
+	s.buf.WriteString("data: ")
+	s.buf.Write(line)
+	s.buf.WriteByte('\n')
+
+}
+
+func (s *sseSender) heartbeat() error {
+
+	s.buf.Reset()
+	s.buf.WriteString(":\n\n")
+	return s.write()
+
+}
+
+func (s *sseSender) write() error {
+
+	if s.timeout > 0 {
+		s.rc.SetWriteDeadline(time.Now().Add(s.timeout))
+	}
+	if _, err := s.w.Write(s.buf.Bytes()); err != nil {
+		return err
+	}
+	return s.flush()
+
+}
+
+func (s *sseSender) flush() error {
+
+	err := s.rc.Flush()
+	if err == http.ErrNotSupported {
+		return nil
+	}
+	return err
+
+}
@@ -0,0 +1,183 @@
+// Package stream follows the output of running chains from a browser. SSE and WebSocket send the
+// data read from one or more sources, usually readers attached to a piper.Broadcast, to a client
+// as it arrives:
+//
+//	out := c.Broadcast(1 << 20)
+//	...
+//	stream.SSE(w, req, stream.Options{}, stream.Source{Event: "stdout", R: out.Attach()})
+//
+// Clients which can't keep up are handled by the broadcast: once a client is more than its limit
+// behind the source fails with piper.ErrLagged, the client receives a "lagged" event and the
+// stream ends. Sources implementing io.Closer are closed when the stream ends.
+package stream
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/noxer/piper"
+)
+
+// DefaultHeartbeat is the heartbeat interval used if Options.Heartbeat is zero
+const DefaultHeartbeat = 15 * time.Second
+
+// Options configures a stream
+type Options struct {
+	// Heartbeat is the interval of keep-alive messages sent while no data arrives, DefaultHeartbeat
+	// if zero, none if negative
+	Heartbeat time.Duration
+	// WriteTimeout limits the time a single message may take to be sent to the client, a client
+	// exceeding it is disconnected. No limit if zero.
+	WriteTimeout time.Duration
+	// CheckOrigin reports whether a WebSocket handshake is accepted, e.g. by comparing its Origin
+	// header to a list of trusted origins. If it is nil only handshakes without an Origin or with
+	// the host of the request as their origin are, so other sites can't follow the output in the
+	// browsers of their visitors. SSE isn't affected, browsers apply CORS to it.
+	CheckOrigin func(req *http.Request) bool
+}
+
+// Source is a stream of data sent to the client as events named Event, e.g. "stdout"
+type Source struct {
+	Event string
+	R     io.Reader
+}
+
+// Names of the events sent by the stream itself
+const (
+	// EventEnd is sent once all sources are exhausted
+	EventEnd = "end"
+	// EventLagged is sent if a source failed with piper.ErrLagged, other errors are sent as EventError
+	EventLagged = "lagged"
+	EventError  = "error"
+)
+
+// sender delivers messages to a client
+type sender interface {
+	send(event string, data []byte) error
+	heartbeat() error
+}
+
+type message struct {
+	event string
+	data  []byte
+	err   error
+}
+
+// pump reads all sources and hands their data to s until they are exhausted or ctx is done.
+func pump(ctx context.Context, s sender, opts Options, srcs []Source) error {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer func() {
+		for _, src := range srcs {
+			if cl, ok := src.R.(io.Closer); ok {
+				cl.Close()
+			}
+		}
+	}()
+
+	msgs := make(chan message)
+	for _, src := range srcs {
+		go read(ctx, src, msgs)
+	}
+
+	interval := opts.Heartbeat
+	if interval == 0 {
+		interval = DefaultHeartbeat
+	}
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for open := len(srcs); open > 0; {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-tick:
+			if err := s.heartbeat(); err != nil {
+				return err
+			}
+
+		case m := <-msgs:
+			if m.data != nil {
+				if err := s.send(m.event, m.data); err != nil {
+					return err
+				}
+				continue
+			}
+
+			open--
+			if m.err == io.EOF {
+				continue
+			}
+			if m.err == piper.ErrLagged {
+				s.send(EventLagged, []byte(m.event))
+			} else {
+				s.send(EventError, []byte(m.event+": "+m.err.Error()))
+			}
+			return m.err
+		}
+	}
+
+	return s.send(EventEnd, nil)
+
+}
+
+// read forwards the data of src to msgs, the last message carries the error ending the source.
+// A multi-byte character split by a read is held back until it is complete, so every chunk of
+// UTF-8 text is valid on its own.
+func read(ctx context.Context, src Source, msgs chan<- message) {
+
+	var carry []byte
+	for {
+		buf := make([]byte, len(carry), 32<<10)
+		copy(buf, carry)
+		n, err := src.R.Read(buf[len(carry):cap(buf)])
+		n += len(carry)
+
+		keep := 0
+		if err == nil {
+			keep = partialRune(buf[:n])
+		}
+		carry = append(carry[:0], buf[n-keep:n]...)
+		if n -= keep; n > 0 {
+			select {
+			case msgs <- message{event: src.Event, data: buf[:n]}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err != nil {
+			select {
+			case msgs <- message{event: src.Event, err: err}:
+			case <-ctx.Done():
+			}
+			return
+		}
+	}
+
+}
+
+// partialRune returns the length of the incomplete UTF-8 sequence at the end of p, 0 if there is
+// none.
+func partialRune(p []byte) int {
+
+	for i := 1; i < utf8.UTFMax && i <= len(p); i++ {
+		c := p[len(p)-i]
+		if utf8.RuneStart(c) {
+			if c >= utf8.RuneSelf && !utf8.FullRune(p[len(p)-i:]) {
+				return i
+			}
+			return 0
+		}
+	}
+	return 0
+
+}
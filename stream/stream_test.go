@@ -0,0 +1,283 @@
+package stream_test
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noxer/piper"
+	"github.com/noxer/piper/stream"
+)
+
+// chunks is a reader returning one chunk per read
+type chunks struct {
+	parts []string
+	err   error
+}
+
+func (c *chunks) Read(p []byte) (int, error) {
+
+	if len(c.parts) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, c.parts[0])
+	c.parts = c.parts[1:]
+	return n, nil
+
+}
+
+func TestSSE(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		parts []string
+		err   error
+		want  string
+	}{
+		{"lines", []string{"a\nb\r\nc\rd"}, nil, "event: out\ndata: a\ndata: b\ndata: c\ndata: d\n\nevent: end\ndata: \n\n"},
+		{"split rune", []string{"\xc3", "\xa4\n"}, nil, "event: out\ndata: ä\ndata: \n\nevent: end\ndata: \n\n"},
+		{"lagged", []string{"x"}, piper.ErrLagged, "event: out\ndata: x\n\nevent: lagged\ndata: out\n\n"},
+		{"failed", nil, errors.New("boom"), "event: error\ndata: out: boom\n\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/", nil)
+			err := stream.SSE(rec, req, stream.Options{Heartbeat: -1}, stream.Source{Event: "out", R: &chunks{parts: tt.parts, err: tt.err}})
+			if err != tt.err {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Content-Type %q", ct)
+			}
+		})
+	}
+
+}
+
+func TestSSEHeartbeat(t *testing.T) {
+
+	r, w := io.Pipe()
+	defer w.Close()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	done := make(chan error, 1)
+	go func() {
+		done <- stream.SSE(rec, req, stream.Options{Heartbeat: 5 * time.Millisecond}, stream.Source{Event: "out", R: r})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	w.Close()
+	<-done
+	if !strings.HasPrefix(rec.Body.String(), ":\n\n") {
+		t.Errorf("no heartbeat in %q", rec.Body.String())
+	}
+
+}
+
+// wsClient is a minimal WebSocket client
+type wsClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dial performs the handshake with the headers hdr and returns the status of the response.
+func dial(t *testing.T, url string, hdr map[string]string) (*wsClient, int) {
+
+	t.Helper()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	req := "GET / HTTP/1.1\r\nHost: " + strings.TrimPrefix(url, "http://") + "\r\nConnection: Upgrade\r\n" +
+		"Upgrade: websocket\r\nSec-WebSocket-Key: " + key + "\r\n"
+	if _, ok := hdr["Sec-WebSocket-Version"]; !ok {
+		req += "Sec-WebSocket-Version: 13\r\n"
+	}
+	for k, v := range hdr {
+		req += k + ": " + v + "\r\n"
+	}
+	io.WriteString(conn, req+"\r\n")
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		if got := resp.Header.Get("Sec-WebSocket-Accept"); got != base64.StdEncoding.EncodeToString(sum[:]) {
+			t.Errorf("Sec-WebSocket-Accept %q", got)
+		}
+	}
+	return &wsClient{conn: conn, r: r}, resp.StatusCode
+
+}
+
+// read reads a frame sent by the server.
+func (c *wsClient) read(t *testing.T) (byte, []byte) {
+
+	t.Helper()
+
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		t.Fatal(err)
+	}
+	if hdr[0]&0x80 == 0 || hdr[1]&0x80 != 0 {
+		t.Fatalf("frame header %x", hdr)
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.r, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.r, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return hdr[0] & 0x0f, payload
+
+}
+
+// write sends a masked frame.
+func (c *wsClient) write(op byte, payload []byte) {
+
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | op, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	c.conn.Write(frame)
+
+}
+
+type wsMessage struct {
+	Event    string `json:"event"`
+	Data     string `json:"data"`
+	Encoding string `json:"encoding"`
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		hdr    map[string]string
+		check  func(*http.Request) bool
+		status int
+	}{
+		{"no origin", nil, nil, http.StatusSwitchingProtocols},
+		{"same origin", map[string]string{"Origin": "http://HOST"}, nil, http.StatusSwitchingProtocols},
+		{"cross origin", map[string]string{"Origin": "http://evil.example"}, nil, http.StatusForbidden},
+		{"invalid origin", map[string]string{"Origin": "null"}, nil, http.StatusForbidden},
+		{"allowed origin", map[string]string{"Origin": "http://evil.example"}, func(req *http.Request) bool {
+			return req.Header.Get("Origin") == "http://evil.example"
+		}, http.StatusSwitchingProtocols},
+		{"old version", map[string]string{"Sec-WebSocket-Version": "8"}, nil, http.StatusUpgradeRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				stream.WebSocket(w, req, stream.Options{Heartbeat: -1, CheckOrigin: tt.check}, stream.Source{Event: "out", R: strings.NewReader("x")})
+			}))
+			defer srv.Close()
+
+			hdr := map[string]string{}
+			for k, v := range tt.hdr {
+				hdr[k] = strings.ReplaceAll(v, "HOST", strings.TrimPrefix(srv.URL, "http://"))
+			}
+			if _, status := dial(t, srv.URL, hdr); status != tt.status {
+				t.Errorf("status %d, want %d", status, tt.status)
+			}
+		})
+	}
+
+}
+
+func TestWebSocketFraming(t *testing.T) {
+
+	big := strings.Repeat("y", 70000)
+	r, w := io.Pipe()
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		stream.WebSocket(rw, req, stream.Options{Heartbeat: -1}, stream.Source{Event: "out", R: r})
+	}))
+	defer srv.Close()
+
+	c, status := dial(t, srv.URL, nil)
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d", status)
+	}
+
+	// a ping of the client is answered while the stream waits for data
+	c.write(0x9, []byte("hi"))
+	if op, payload := c.read(t); op != 0xa || string(payload) != "hi" {
+		t.Errorf("got opcode %x with %q, want a pong", op, payload)
+	}
+
+	go func() {
+		for _, chunk := range []string{"text\n", "\xff\xfebinary", big} {
+			w.Write([]byte(chunk))
+		}
+		w.Close()
+	}()
+
+	want := []wsMessage{{"out", "text\n", ""}, {"out", base64.StdEncoding.EncodeToString([]byte("\xff\xfebinary")), "base64"}}
+	var got []wsMessage
+	var data strings.Builder
+	for {
+		op, payload := c.read(t)
+		if op == 0x8 {
+			break
+		}
+		if op != 0x1 {
+			t.Fatalf("opcode %x", op)
+		}
+		var m wsMessage
+		if err := json.Unmarshal(payload, &m); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case m.Event == stream.EventEnd:
+		case m.Event == "out" && strings.HasPrefix(m.Data, "y"):
+			data.WriteString(m.Data)
+		default:
+			got = append(got, m)
+		}
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if data.String() != big {
+		t.Errorf("got %d bytes of the large chunk", data.Len())
+	}
+
+}
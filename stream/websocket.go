@@ -0,0 +1,233 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// websocketGUID is appended to the key of the client to compute the accept header, see RFC 6455
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket upgrades the connection of req to a WebSocket and streams the sources to the client.
+// Every chunk of data is sent as a text message holding a JSON object with the fields "event" and
+// "data". Chunks which aren't valid UTF-8, e.g. binary output, are base64 encoded and carry the
+// field "encoding" set to "base64". Heartbeats are sent as pings. Messages of the client are
+// discarded, a close message ends the stream. Handshakes from other origins are refused, see
+// Options.CheckOrigin. WebSocket returns once all sources are exhausted, the client went away or
+// sending failed.
+func WebSocket(w http.ResponseWriter, req *http.Request, opts Options, srcs ...Source) error {
+
+	check := opts.CheckOrigin
+	if check == nil {
+		check = sameOrigin
+	}
+	if !check(req) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return errors.Errorf("stream: WebSocket handshake from the origin %q refused", req.Header.Get("Origin"))
+	}
+
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if !headerHas(req.Header, "Connection", "upgrade") || !headerHas(req.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "expected a WebSocket handshake", http.StatusBadRequest)
+		return errors.New("stream: not a WebSocket handshake")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return errors.New("stream: unsupported WebSocket version")
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return errors.Wrap(err, "unable to take over the connection")
+	}
+	defer conn.Close()
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+
+	s := &wsSender{conn: conn, w: rw.Writer, timeout: opts.WriteTimeout}
+	go s.discard(rw.Reader, cancel)
+
+	err = pump(ctx, s, opts, srcs)
+	s.frame(opClose, []byte{0x03, 0xe8})
+	if err == context.Canceled && req.Context().Err() == nil {
+		// the client closed the stream
+		return nil
+	}
+	return err
+
+}
+
+// sameOrigin reports whether req has no Origin header or one naming the host of req.
+func sameOrigin(req *http.Request) bool {
+
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, req.Host)
+
+}
+
+// headerHas reports whether the comma separated list in header name contains token.
+func headerHas(h http.Header, name, token string) bool {
+
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+
+}
+
+// WebSocket opcodes
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xa
+)
+
+// maxClientMessage limits the size of a single frame the client may send
+const maxClientMessage = 1 << 16
+
+type wsSender struct {
+	conn    net.Conn
+	timeout time.Duration
+
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func (s *wsSender) send(event string, data []byte) error {
+
+	text, encoding := string(data), ""
+	if !utf8.Valid(data) {
+		text, encoding = base64.StdEncoding.EncodeToString(data), "base64"
+	}
+	msg, err := json.Marshal(struct {
+		Event    string `json:"event"`
+		Data     string `json:"data"`
+		Encoding string `json:"encoding,omitempty"`
+	}{event, text, encoding})
+	if err != nil {
+		return err
+	}
+	return s.frame(opText, msg)
+
+}
+
+func (s *wsSender) heartbeat() error {
+
+	return s.frame(opPing, nil)
+
+}
+
+// frame sends a single unmasked frame.
+func (s *wsSender) frame(op byte, payload []byte) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timeout > 0 {
+		s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	}
+
+	hdr := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		hdr = append(hdr, byte(n))
+	case n <= 0xffff:
+		hdr = append(hdr, 126)
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr = append(hdr, 127)
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+
+	s.w.Write(hdr)
+	s.w.Write(payload)
+	return s.w.Flush()
+
+}
+
+// discard reads the frames of the client and answers pings, cancel is called once the client
+// closed the connection.
+func (s *wsSender) discard(r *bufio.Reader, cancel func()) {
+
+	defer cancel()
+
+	var hdr [14]byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:2]); err != nil {
+			return
+		}
+		op := hdr[0] & 0x0f
+		masked := hdr[1]&0x80 != 0
+		n := uint64(hdr[1] & 0x7f)
+
+		switch n {
+		case 126:
+			if _, err := io.ReadFull(r, hdr[2:4]); err != nil {
+				return
+			}
+			n = uint64(binary.BigEndian.Uint16(hdr[2:4]))
+		case 127:
+			if _, err := io.ReadFull(r, hdr[2:10]); err != nil {
+				return
+			}
+			n = binary.BigEndian.Uint64(hdr[2:10])
+		}
+		if n > maxClientMessage {
+			return
+		}
+
+		var mask [4]byte
+		if masked {
+			if _, err := io.ReadFull(r, mask[:]); err != nil {
+				return
+			}
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch op {
+		case opClose:
+			return
+		case opPing:
+			s.frame(opPong, payload)
+		}
+	}
+
+}
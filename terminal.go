@@ -0,0 +1,170 @@
+package piper
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// WindowSize is the size of a terminal in characters
+type WindowSize struct {
+	Rows uint16
+	Cols uint16
+}
+
+// DefaultWindowSize is the size of a terminal without one, see Chain.Terminal
+var DefaultWindowSize = WindowSize{Rows: 24, Cols: 80}
+
+// Terminal runs the last added command on a pseudo terminal of size, DefaultWindowSize if it is
+// zero, so programs which disable colors or buffer their output unless they run on a terminal
+// behave like in a shell. The input of the command, e.g. the output of the previous stage, is
+// typed into the terminal and ends with an end of file character, everything the program prints
+// to the terminal, stdout and stderr, is passed to the next stage. The terminal doesn't echo and
+// passes the output unchanged. The command becomes the leader of a new session, so it isn't part
+// of the process group of WithProcessGroup. Only supported on Linux, it must be called before Start
+// and the pipes of the chain, e.g. StdoutPipe.
+func (c *Chain) Terminal(size WindowSize) *Chain {
+
+	if size == (WindowSize{}) {
+		size = DefaultWindowSize
+	}
+	c.last().termSize = &size
+	return c
+
+}
+
+// Resize changes the size of the terminal of stage i, see Terminal. The command receives SIGWINCH.
+func (c *Chain) Resize(i int, size WindowSize) error {
+
+	if i < 0 || i >= len(c.stages) || c.stages[i].term == nil {
+		return errors.Errorf("piper: stage #%d has no terminal", i)
+	}
+	return setWindowSize(c.stages[i].term.master, size)
+
+}
+
+// attachTerminals puts the commands marked with Terminal on their terminals. The ends of their
+// links are handed to the terminals, which copy between them and the pty.
+func (c *Chain) attachTerminals() error {
+
+	for i, s := range c.stages {
+
+		if s.termSize == nil {
+			continue
+		}
+		if s.cmd == nil || s.warm != nil || s.piped {
+			return errors.Errorf("piper: stage #%d (%s) can't run on a terminal", i, s.name())
+		}
+
+		master, slave, eof, err := openTerminal(*s.termSize)
+		if err != nil {
+			return errors.Wrapf(err, "unable to open a terminal for command #%d (%s)", i, s.name())
+		}
+
+		t := &terminal{master: master, eof: eof, in: s.cmd.Stdin, out: s.cmd.Stdout, done: make(chan struct{})}
+		t.inCloser = s.disown(t.in)
+		t.outCloser = s.disown(t.out)
+		s.term = t
+
+		s.cmd.Stdin = slave
+		s.cmd.Stdout = slave
+		s.cmd.Stderr = slave
+		setControllingTerminal(s.cmd)
+		s.own(slave)
+
+	}
+	return nil
+
+}
+
+// waitTerminals waits for the terminals of the stages, see terminal.wait.
+func (c *Chain) waitTerminals() {
+
+	for _, s := range c.stages {
+		if s.term != nil {
+			s.term.wait()
+		}
+	}
+
+}
+
+// terminal connects a command running on a pty to its neighbours
+type terminal struct {
+	master *os.File
+	eof    byte
+
+	// in is typed into the terminal, the output of the terminal is written to out. The closers
+	// are set if the terminal owns the ends of the links.
+	in        io.Reader
+	inCloser  io.Closer
+	out       io.Writer
+	outCloser io.Closer
+
+	done    chan struct{}
+	started bool
+}
+
+func (t *terminal) start() {
+
+	t.started = true
+	go t.copyIn()
+	go t.copyOut()
+
+}
+
+// copyIn types the input into the terminal and ends it with the end of file character. It
+// takes a second one to end a last line without newline.
+func (t *terminal) copyIn() {
+
+	w := &meter{w: t.master}
+	if t.in != nil {
+		io.Copy(w, t.in)
+	}
+	if w.bytes() > 0 && byte(atomic.LoadInt32(&w.last)) != '\n' {
+		t.master.Write([]byte{t.eof})
+	}
+	t.master.Write([]byte{t.eof})
+
+}
+
+// copyOut passes the output of the terminal on until the command and all processes it left
+// behind closed the terminal. The input is closed then, so the previous stage stops. The pty is
+// kept open until the command was waited for, closing it hangs up the command if it closed its
+// files before it exited.
+func (t *terminal) copyOut() {
+
+	out := t.out
+	if out == nil {
+		out = io.Discard
+	}
+	io.Copy(out, ptyReader{t.master})
+	t.closeLinks()
+	close(t.done)
+
+}
+
+func (t *terminal) closeLinks() {
+
+	if t.inCloser != nil {
+		t.inCloser.Close()
+	}
+	if t.outCloser != nil {
+		t.outCloser.Close()
+	}
+
+}
+
+// wait waits for the output to be passed on and closes the pty, it must be called after the
+// command was waited for. A terminal which was never started closes its links instead. The input isn't waited for, it may block on a reader not owned by the chain.
+func (t *terminal) wait() {
+
+	if t.started {
+		<-t.done
+	} else {
+		t.closeLinks()
+	}
+	t.master.Close()
+
+}
@@ -0,0 +1,353 @@
+package piper
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// NodeKind classifies the stages of a chain
+type NodeKind int
+
+const (
+	// CommandNode is an external command
+	CommandNode NodeKind = iota
+	// FuncNode is an in-process stage
+	FuncNode
+)
+
+// LinkKind classifies the connections of a chain
+type LinkKind int
+
+const (
+	// PipeLink connects two stages directly with an OS pipe
+	PipeLink LinkKind = iota
+	// RelayLink connects two stages through the parent process, used for taps and instrumentation
+	RelayLink
+	// StdinLink feeds Stdin of the chain into the first stage
+	StdinLink
+	// StdoutLink passes the output of the last stage to Stdout of the chain
+	StdoutLink
+	// StderrLink passes the stderr of a stage to Stderr, Allerr or StderrFor
+	StderrLink
+	// TapLink copies the output of a stage to an observer
+	TapLink
+	// SocketLink connects two stages with a Unix stream socket pair, see Chain.Link. Both ends can
+	// be read and written, so the first stage reads the replies of the second one from its stdout
+	// and the second one replies to its stdin, like a service started by inetd.
+	SocketLink
+	// PacketLink works like SocketLink but preserves the boundaries of the messages (SOCK_SEQPACKET)
+	PacketLink
+	// KeepOpenLink is a pipe the chain keeps open after the first stage exited, the second one
+	// sees EOF only after Drain, see Chain.Link
+	KeepOpenLink
+	// PtyLink connects the stdout of the first stage to a pseudo terminal, see Chain.Link. Programs
+	// which buffer their output unless it goes to a terminal flush every line then. The output is
+	// relayed through the parent process and passes the terminal unchanged. Only supported on Linux.
+	PtyLink
+	// InputTapLink copies the input of a stage to an observer, see Chain.TapStdin
+	InputTapLink
+	// AndLink runs the pipeline of To only if the one of From succeeded, see Chain.And. It goes
+	// from the last stage of a pipeline to the first one of the next.
+	AndLink
+	// OrLink runs the pipeline of To only if the one of From failed, see Chain.Or and AndLink
+	OrLink
+)
+
+func (k LinkKind) String() string {
+
+	switch k {
+	case PipeLink:
+		return "pipe"
+	case RelayLink:
+		return "relay"
+	case StdinLink:
+		return "stdin"
+	case StdoutLink:
+		return "stdout"
+	case StderrLink:
+		return "stderr"
+	case TapLink:
+		return "tap"
+	case SocketLink:
+		return "socket"
+	case PacketLink:
+		return "packet"
+	case KeepOpenLink:
+		return "keep-open"
+	case PtyLink:
+		return "pty"
+	case InputTapLink:
+		return "input-tap"
+	case AndLink:
+		return "and"
+	case OrLink:
+		return "or"
+	}
+	return fmt.Sprintf("LinkKind(%d)", int(k))
+
+}
+
+// External is used as From or To of links which leave the chain, e.g. to Stdout or a tap
+const External = -1
+
+// Node is a stage of a chain
+type Node struct {
+	ID   int
+	Kind NodeKind
+	Path string
+	Args []string
+	// Pipeline is the index of the pipeline of a conditional chain the stage belongs to, see
+	// Chain.And, 0 for chains without conditions
+	Pipeline int
+	// IgnoreFailure and Not are set for stages marked with the methods of Chain
+	IgnoreFailure bool
+	Not           bool
+}
+
+// Link is a connection between two nodes, From or To is External for the ends of the chain
+type Link struct {
+	From int
+	To   int
+	Kind LinkKind
+}
+
+// Topology describes what a chain will execute, see Chain.Topology
+type Topology struct {
+	Nodes []Node
+	Links []Link
+}
+
+// Topology returns the stages of the chain and how they will be connected when it is started.
+// The stages of the pipelines of a conditional chain are numbered in order, the pipelines are
+// joined by an AndLink or OrLink.
+func (c *Chain) Topology() Topology {
+
+	var t Topology
+	var prev []Node
+	for i := 0; i <= len(c.parts); i++ {
+
+		stages, cond, final := c.stages, c.cond, i == len(c.parts)
+		if !final {
+			stages, cond = c.parts[i].stages, c.parts[i].cond
+		}
+		nodes := c.pipelineTopology(&t, i, stages, final)
+
+		if len(prev) > 0 && len(nodes) > 0 && cond != condNone {
+			kind := AndLink
+			if cond == condOr {
+				kind = OrLink
+			}
+			t.Links = append(t.Links, Link{From: prev[len(prev)-1].ID, To: nodes[0].ID, Kind: kind})
+		}
+		if len(nodes) > 0 {
+			prev = nodes
+		}
+
+	}
+	return t
+
+}
+
+// pipelineTopology adds the stages of pipeline n and their links to t and returns the nodes.
+// Taps and outputs configured by stage index only apply to the final pipeline, see And.
+func (c *Chain) pipelineTopology(t *Topology, n int, stages []*stage, final bool) []Node {
+
+	first := len(t.Nodes)
+	for i, s := range stages {
+
+		node := Node{ID: first + i, Kind: FuncNode, Path: s.name(), Pipeline: n,
+			IgnoreFailure: s.ignoreFailure, Not: s.negate}
+		if s.cmd != nil {
+			node.Kind = CommandNode
+			node.Args = s.cmd.Args
+		}
+		t.Nodes = append(t.Nodes, node)
+
+	}
+	if len(stages) == 0 {
+		return nil
+	}
+
+	if c.Stdin != nil {
+		t.Links = append(t.Links, Link{From: External, To: first, Kind: StdinLink})
+	}
+	if final {
+		for range c.inTaps {
+			t.Links = append(t.Links, Link{From: first, To: External, Kind: InputTapLink})
+		}
+	}
+
+	last := len(stages) - 1
+	for i, s := range stages {
+
+		id := first + i
+		if i < last {
+			kind := PipeLink
+			if s.link != PipeLink {
+				kind = s.link
+			} else if c.Instrument || (final && len(c.outTaps[i]) > 0) {
+				kind = RelayLink
+			}
+			t.Links = append(t.Links, Link{From: id, To: id + 1, Kind: kind})
+		}
+
+		if s.cmd != nil && (c.Allerr != nil || c.StderrFor != nil || (i == last && c.Stderr != nil)) {
+			t.Links = append(t.Links, Link{From: id, To: External, Kind: StderrLink})
+		}
+
+		if final {
+			taps := len(c.outTaps[i])
+			if i == last {
+				taps += len(c.endTaps)
+			}
+			for range taps {
+				t.Links = append(t.Links, Link{From: id, To: External, Kind: TapLink})
+			}
+			for range c.errTaps[i] {
+				t.Links = append(t.Links, Link{From: id, To: External, Kind: TapLink})
+			}
+		}
+
+	}
+
+	if c.Stdout != nil {
+		t.Links = append(t.Links, Link{From: first + last, To: External, Kind: StdoutLink})
+	}
+
+	return t.Nodes[first:]
+
+}
+
+// DOT renders the topology in the Graphviz DOT language.
+func (t Topology) DOT() string {
+
+	var b strings.Builder
+	b.WriteString("digraph piper {\n\trankdir=LR;\n")
+
+	for _, n := range t.Nodes {
+		shape := "box"
+		if n.Kind == FuncNode {
+			shape = "ellipse"
+		}
+		fmt.Fprintf(&b, "\tn%d [shape=%s label=%q];\n", n.ID, shape, n.label())
+	}
+
+	for i, l := range t.Links {
+
+		from, to := fmt.Sprintf("n%d", l.From), fmt.Sprintf("n%d", l.To)
+		if l.From == External || l.To == External {
+			ext := fmt.Sprintf("x%d", i)
+			fmt.Fprintf(&b, "\t%s [shape=plaintext label=%q];\n", ext, l.Kind.String())
+			if l.From == External {
+				from = ext
+			} else {
+				to = ext
+			}
+		}
+
+		style := "solid"
+		switch l.Kind {
+		case RelayLink:
+			style = "bold"
+		case StderrLink, TapLink, InputTapLink:
+			style = "dashed"
+		case AndLink, OrLink:
+			fmt.Fprintf(&b, "\t%s -> %s [style=dotted label=%q];\n", from, to, l.condition())
+			continue
+		}
+		fmt.Fprintf(&b, "\t%s -> %s [style=%s];\n", from, to, style)
+
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+
+}
+
+// label returns the command line of the node, shortened to the base name of the command, with
+// the markers of Not and IgnoreFailure like in a shell.
+func (n Node) label() string {
+
+	label := filepath.Base(n.Path)
+	if n.Kind != FuncNode && len(n.Args) > 0 {
+		label = strings.Join(append([]string{label}, n.Args[1:]...), " ")
+	}
+	if n.Not {
+		label = "! " + label
+	}
+	if n.IgnoreFailure {
+		label += " || true"
+	}
+	return label
+
+}
+
+// condition returns the shell operator of an AndLink or OrLink.
+func (l Link) condition() string {
+
+	if l.Kind == OrLink {
+		return "||"
+	}
+	return "&&"
+
+}
+
+// Mermaid renders the topology as a Mermaid flowchart.
+func (t Topology) Mermaid() string {
+
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+
+	for _, n := range t.Nodes {
+		label := strings.ReplaceAll(n.label(), `"`, "#quot;")
+		if n.Kind == FuncNode {
+			fmt.Fprintf(&b, "\tn%d([\"%s\"])\n", n.ID, label)
+		} else {
+			fmt.Fprintf(&b, "\tn%d[\"%s\"]\n", n.ID, label)
+		}
+	}
+
+	for i, l := range t.Links {
+
+		from, to := fmt.Sprintf("n%d", l.From), fmt.Sprintf("n%d", l.To)
+		if l.From == External || l.To == External {
+			ext := fmt.Sprintf("x%d", i)
+			fmt.Fprintf(&b, "\t%s>\"%s\"]\n", ext, l.Kind.String())
+			if l.From == External {
+				from = ext
+			} else {
+				to = ext
+			}
+		}
+
+		arrow := "-->"
+		switch l.Kind {
+		case RelayLink:
+			arrow = "==>"
+		case StderrLink, TapLink, InputTapLink:
+			arrow = "-.->"
+		case AndLink, OrLink:
+			arrow = fmt.Sprintf("-.->|\"%s\"|", l.condition())
+		}
+		fmt.Fprintf(&b, "\t%s %s %s\n", from, arrow, to)
+
+	}
+
+	return b.String()
+
+}
+
+// ToDOT renders the chain as a Graphviz diagram, see Topology.
+func (c *Chain) ToDOT() string {
+
+	return c.Topology().DOT()
+
+}
+
+// ToMermaid renders the chain as a Mermaid flowchart, see Topology.
+func (c *Chain) ToMermaid() string {
+
+	return c.Topology().Mermaid()
+
+}
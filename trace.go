@@ -0,0 +1,113 @@
+package piper
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Tracer creates the spans of a traced chain, see WithTracer. It is a thin layer over a tracing
+// library like OpenTelemetry: Start maps to trace.Tracer.Start with trace.WithTimestamp and the
+// attributes converted to attribute.KeyValue, End to recording err and trace.Span.End.
+type Tracer interface {
+	// Start starts a span named name as a child of the span in ctx at the time start
+	Start(ctx context.Context, name string, start time.Time, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a span created by a Tracer
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	// End ends the span at the time end, err is the error of the chain or the stage, if any
+	End(err error, end time.Time)
+}
+
+// Attribute is an attribute of a span, Value is a string, an int64, a bool or a []string
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// WithTracer traces every run of the chain with t. The run is a span named "piper.pipeline",
+// every stage a child span named after its command. The spans of the pipeline are children of the
+// span in the context of WithContext, if any. The stage spans carry the attributes
+// "piper.stage.index", "process.command", "process.command_args", "process.pid",
+// "process.exit.code", "piper.bytes.in" and "piper.bytes.out", the byte counts only for links
+// passing through the parent process, see Chain.Instrument. The pipeline span carries
+// "piper.chain", "piper.stages" and "piper.partial". The spans end once Wait returned.
+func WithTracer(t Tracer) Option {
+
+	return func(c *Chain) { c.tracer = t }
+
+}
+
+// startTrace starts the span of the pipeline.
+func (c *Chain) startTrace() {
+
+	if c.tracer == nil {
+		return
+	}
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	c.traceCtx, c.span = c.tracer.Start(ctx, "piper.pipeline", c.started,
+		Attribute{"piper.chain", c.Name}, Attribute{"piper.stages", int64(len(c.stages))})
+	c.spans = make([]Span, len(c.stages))
+
+}
+
+// startSpan starts the span of stage i.
+func (c *Chain) startSpan(i int, s *stage) {
+
+	if c.span == nil {
+		return
+	}
+	attrs := []Attribute{{"piper.stage.index", int64(i)}}
+	if s.cmd != nil {
+		attrs = append(attrs, Attribute{"process.command", s.cmd.Path}, Attribute{"process.command_args", s.cmd.Args})
+	}
+	_, c.spans[i] = c.tracer.Start(c.traceCtx, s.name(), time.Now(), attrs...)
+
+}
+
+// endTrace ends the spans of the stages and the pipeline, err is the error of the chain.
+func (c *Chain) endTrace(err error) {
+
+	if c.span == nil {
+		return
+	}
+
+	now := time.Now()
+	stats := c.Stats()
+	for i, span := range c.spans {
+		if span == nil {
+			continue
+		}
+		s := c.stages[i]
+		attrs := []Attribute{{"piper.bytes.in", stats.Stages[i].BytesIn}, {"piper.bytes.out", stats.Stages[i].BytesOut}}
+		if pid := atomic.LoadInt32(&s.pid); pid != 0 {
+			attrs = append(attrs, Attribute{"process.pid", int64(pid)})
+		}
+		if s.cmd != nil && s.cmd.ProcessState != nil {
+			attrs = append(attrs, Attribute{"process.exit.code", int64(s.cmd.ProcessState.ExitCode())})
+		}
+		span.SetAttributes(attrs...)
+
+		var serr error
+		if c.result != nil {
+			serr = c.result.Stages[i].Err
+		} else if se, ok := err.(*StartError); ok && se.Index == i {
+			serr = err
+		}
+		end := now
+		if ended := atomic.LoadInt64(&s.ended); ended != 0 {
+			end = time.Unix(0, ended)
+		}
+		span.End(serr, end)
+	}
+
+	c.span.SetAttributes(Attribute{"piper.partial", c.partial()})
+	c.span.End(err, now)
+	c.span, c.spans, c.traceCtx = nil, nil, nil
+
+}
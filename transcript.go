@@ -0,0 +1,216 @@
+package piper
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// transcriptMagic starts every transcript, followed by the start time in Unix nanoseconds
+const transcriptMagic = "PIPERTX1"
+
+// ErrTranscript is the cause of the errors of a TranscriptReader reading a malformed transcript
+var ErrTranscript = errors.New("piper: malformed transcript")
+
+// TranscriptRecord is a chunk of data which went over a link
+type TranscriptRecord struct {
+	// Offset is the time the chunk was written at, relative to the first chunk of the transcript
+	Offset time.Duration
+	Data   []byte
+}
+
+// TranscriptWriter records every write as a timestamped TranscriptRecord to w, writes larger than
+// DefaultMaxRecord are split into several records. The transcript starts with the first write, an
+// empty transcript has no header either.
+type TranscriptWriter struct {
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+	buf   []byte
+}
+
+// NewTranscriptWriter creates a TranscriptWriter writing the transcript to w, e.g. a file.
+func NewTranscriptWriter(w io.Writer) *TranscriptWriter {
+
+	return &TranscriptWriter{w: w}
+
+}
+
+// Write records p with the current time.
+func (t *TranscriptWriter) Write(p []byte) (int, error) {
+
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = t.buf[:0]
+	if t.start.IsZero() {
+		t.start = now
+		t.buf = append(t.buf, transcriptMagic...)
+		t.buf = binary.BigEndian.AppendUint64(t.buf, uint64(now.UnixNano()))
+	}
+	for rest := p; len(rest) > 0; {
+		n := min(len(rest), DefaultMaxRecord)
+		t.buf = binary.AppendUvarint(t.buf, uint64(now.Sub(t.start)))
+		t.buf = binary.AppendUvarint(t.buf, uint64(n))
+		t.buf = append(t.buf, rest[:n]...)
+		rest = rest[n:]
+	}
+
+	if _, err := t.w.Write(t.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+
+}
+
+// Transcribe records everything stage i writes to its stdout to w with the time it was written
+// at, like tcpdump for a link. The transcript can be read with a TranscriptReader or printed with
+// DumpTranscript. The link is relayed through the parent, so chunks are recorded the way the
+// relay read them, which mostly matches the writes of the stage. Failures of w don't affect the
+// chain. It must be called before Start.
+func (c *Chain) Transcribe(i int, w io.Writer) *Chain {
+
+	c.tapStdout(i, NewTranscriptWriter(w))
+	return c
+
+}
+
+// TranscriptReader reads a transcript written by a TranscriptWriter
+type TranscriptReader struct {
+	r     *bufio.Reader
+	start time.Time
+	err   error
+}
+
+// NewTranscriptReader creates a TranscriptReader reading the transcript from r.
+func NewTranscriptReader(r io.Reader) *TranscriptReader {
+
+	return &TranscriptReader{r: bufio.NewReader(r)}
+
+}
+
+// Start returns the wall clock time of the first record, it is zero before Next was called.
+func (t *TranscriptReader) Start() time.Time {
+
+	return t.start
+
+}
+
+// Next returns the next record of the transcript or io.EOF after the last one. A malformed or
+// truncated transcript fails with the cause ErrTranscript.
+func (t *TranscriptReader) Next() (TranscriptRecord, error) {
+
+	if t.err != nil {
+		return TranscriptRecord{}, t.err
+	}
+	rec, err := t.next()
+	if err != nil {
+		t.err = err
+	}
+	return rec, err
+
+}
+
+func (t *TranscriptReader) next() (TranscriptRecord, error) {
+
+	if t.start.IsZero() {
+		var hdr [len(transcriptMagic) + 8]byte
+		_, err := io.ReadFull(t.r, hdr[:])
+		if err == io.EOF {
+			return TranscriptRecord{}, io.EOF
+		}
+		if err != nil || string(hdr[:len(transcriptMagic)]) != transcriptMagic {
+			return TranscriptRecord{}, errors.Wrap(ErrTranscript, "invalid header")
+		}
+		t.start = time.Unix(0, int64(binary.BigEndian.Uint64(hdr[len(transcriptMagic):])))
+	}
+
+	off, err := binary.ReadUvarint(t.r)
+	if err == io.EOF {
+		return TranscriptRecord{}, io.EOF
+	}
+	if err != nil {
+		return TranscriptRecord{}, errors.Wrap(ErrTranscript, "invalid record offset")
+	}
+	n, err := binary.ReadUvarint(t.r)
+	if err != nil || n > DefaultMaxRecord {
+		return TranscriptRecord{}, errors.Wrap(ErrTranscript, "invalid record size")
+	}
+
+	rec := TranscriptRecord{Offset: time.Duration(off), Data: make([]byte, n)}
+	if _, err := io.ReadFull(t.r, rec.Data); err != nil {
+		return TranscriptRecord{}, errors.Wrap(ErrTranscript, "truncated record")
+	}
+	return rec, nil
+
+}
+
+// DumpTranscript prints the transcript read from r to w, every record as its offset and size
+// followed by a hex dump of its data.
+func DumpTranscript(w io.Writer, r io.Reader) error {
+
+	t := NewTranscriptReader(r)
+	for {
+		rec, err := t.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "+%v %d bytes\n%s", rec.Offset, len(rec.Data), hex.Dump(rec.Data)); err != nil {
+			return err
+		}
+	}
+
+}
+
+// Replay returns a reader delivering the data of the transcript read from r at the pace it was
+// recorded at, e.g. as the Stdin of a chain to reproduce the load of a recorded link. The clock
+// starts with the first Read. speed scales the pace, 2 replays twice as fast, and a speed of zero
+// or less delivers the data without delay. The reader fails like TranscriptReader.Next.
+func Replay(r io.Reader, speed float64) io.Reader {
+
+	return &replay{t: NewTranscriptReader(r), speed: speed}
+
+}
+
+// replay is the reader returned by Replay
+type replay struct {
+	t       *TranscriptReader
+	speed   float64
+	start   time.Time
+	pending []byte
+}
+
+func (r *replay) Read(p []byte) (int, error) {
+
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+
+	for len(r.pending) == 0 {
+		rec, err := r.t.Next()
+		if err != nil {
+			return 0, err
+		}
+		if r.speed > 0 {
+			due := r.start.Add(time.Duration(float64(rec.Offset) / r.speed))
+			time.Sleep(time.Until(due))
+		}
+		r.pending = rec.Data
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+
+}
@@ -0,0 +1,25 @@
+package piper
+
+// ErrorTransformer sets fn to map the error of every failed stage before it is recorded, e.g. an
+// exit code or a stderr pattern of a tool to an error of the application, like exit code 23 of
+// rsync to ErrPartialTransfer. fn gets the index of the stage and its error, e.g. an
+// *exec.ExitError, the ErrNegated of a negated stage or the error of a function. The result
+// replaces the error in the StageError and the StageResult, nil makes the stage succeed.
+// Captured stderr, see WithStderrBuffers, is complete when fn is called. fn is called
+// concurrently for stages failing at the same time. It must be called before Wait.
+func (c *Chain) ErrorTransformer(fn func(stage int, err error) error) *Chain {
+
+	c.transform = fn
+	return c
+
+}
+
+// transformError applies the ErrorTransformer of the chain to the error of stage i.
+func (c *Chain) transformError(i int, err error) error {
+
+	if err == nil || c.transform == nil {
+		return err
+	}
+	return c.transform(i, err)
+
+}
@@ -0,0 +1,130 @@
+package typed_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/noxer/piper/typed"
+)
+
+type point struct {
+	X, Y int
+	Name string `json:",omitempty"`
+}
+
+var protobuf = typed.Protobuf[string]{
+	Marshal:   func(s string) ([]byte, error) { return []byte(s), nil },
+	Unmarshal: func(b []byte) (string, error) { return string(b), nil },
+}
+
+// roundTrip encodes values with c and decodes the result again
+func roundTrip[T any](c typed.Codec[T], values []T) (string, []T, error) {
+
+	var buf bytes.Buffer
+	if err := c.Encode(&buf, typed.From(values...)); err != nil {
+		return "", nil, err
+	}
+	encoded := buf.String()
+	decoded, err := typed.Collect(c.Decode(&buf))
+	return encoded, decoded, err
+
+}
+
+func TestCodecs(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		run     func() (string, any, error)
+		encoded string
+		want    any
+	}{
+		{"JSON lines", func() (string, any, error) {
+			return roundTrip[point](typed.JSONLines[point]{}, []point{{1, 2, "<a>"}, {X: 3}})
+		}, "{\"X\":1,\"Y\":2,\"Name\":\"<a>\"}\n{\"X\":3,\"Y\":0}\n", []point{{1, 2, "<a>"}, {X: 3}}},
+		{"CSV", func() (string, any, error) {
+			return roundTrip[[]string](typed.CSV{}, [][]string{{"a", "b,c"}, {"d"}})
+		}, "a,\"b,c\"\nd\n", [][]string{{"a", "b,c"}, {"d"}}},
+		{"CSV comma", func() (string, any, error) {
+			return roundTrip[[]string](typed.CSV{Comma: ';'}, [][]string{{"a", "b,c"}})
+		}, "a;b,c\n", [][]string{{"a", "b,c"}}},
+		{"protobuf", func() (string, any, error) {
+			return roundTrip[string](protobuf, []string{"ab", "", strings.Repeat("x", 200)})
+		}, "\x02ab\x00\xc8\x01" + strings.Repeat("x", 200), []string{"ab", "", strings.Repeat("x", 200)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, got, err := tt.run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encoded != tt.encoded {
+				t.Errorf("encoded %q, want %q", encoded, tt.encoded)
+			}
+			if fmt.Sprintf("%#v", got) != fmt.Sprintf("%#v", tt.want) {
+				t.Errorf("decoded %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+}
+
+func TestCodecErrors(t *testing.T) {
+
+	limited := protobuf
+	limited.MaxLen = 2
+	failing := protobuf
+	failing.Unmarshal = func(b []byte) (string, error) {
+		if len(b) > 1 {
+			return "", errTest
+		}
+		return string(b), nil
+	}
+
+	tests := []struct {
+		name  string
+		run   func() (any, error)
+		want  any
+		ok    bool
+		cause error
+	}{
+		{"JSON empty lines", func() (any, error) {
+			return typed.Collect(typed.JSONLines[int]{}.Decode(strings.NewReader("1\n\n  \n2\n")))
+		}, []int{1, 2}, true, nil},
+		{"invalid JSON", func() (any, error) {
+			return typed.Collect(typed.JSONLines[int]{}.Decode(strings.NewReader("1\n{\n3\n")))
+		}, []int{1}, false, nil},
+		{"CSV comment", func() (any, error) {
+			return typed.Collect(typed.CSV{Comment: '#'}.Decode(strings.NewReader("# head\na,b\n")))
+		}, [][]string{{"a", "b"}}, true, nil},
+		{"invalid CSV", func() (any, error) {
+			return typed.Collect(typed.CSV{}.Decode(strings.NewReader("a\n\"b\n")))
+		}, [][]string{{"a"}}, false, nil},
+		{"protobuf too large", func() (any, error) {
+			return typed.Collect(limited.Decode(strings.NewReader("\x01a\x03abc")))
+		}, []string{"a"}, false, nil},
+		{"truncated protobuf", func() (any, error) {
+			return typed.Collect(protobuf.Decode(strings.NewReader("\x01a\x05ab")))
+		}, []string{"a"}, false, nil},
+		{"unmarshal error", func() (any, error) {
+			return typed.Collect(failing.Decode(strings.NewReader("\x01a\x02ab\x01c")))
+		}, []string{"a"}, false, errTest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.run()
+			if (err == nil) != tt.ok || tt.cause != nil && errors.Cause(err) != tt.cause {
+				t.Errorf("got %v, ok %v, cause %v", err, tt.ok, tt.cause)
+			}
+			if fmt.Sprintf("%#v", got) != fmt.Sprintf("%#v", tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+}
@@ -0,0 +1,57 @@
+package typed
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// CSV is a Codec for comma separated values, every record is a value
+type CSV struct {
+	// Comma is the field delimiter, ',' if zero
+	Comma rune
+	// Comment starts lines which are skipped when decoding, none if zero
+	Comment rune
+}
+
+// Decode returns the records of r.
+func (c CSV) Decode(r io.Reader) Pipe[[]string] {
+
+	return func(yield func([]string, error) bool) {
+		cr := csv.NewReader(r)
+		if c.Comma != 0 {
+			cr.Comma = c.Comma
+		}
+		cr.Comment = c.Comment
+		cr.FieldsPerRecord = -1
+		for {
+			rec, err := cr.Read()
+			if err == io.EOF {
+				return
+			}
+			if !yield(rec, err) || err != nil {
+				return
+			}
+		}
+	}
+
+}
+
+// Encode writes every value of p as a record.
+func (c CSV) Encode(w io.Writer, p Pipe[[]string]) error {
+
+	cw := csv.NewWriter(w)
+	if c.Comma != 0 {
+		cw.Comma = c.Comma
+	}
+	for rec, err := range p {
+		if err != nil {
+			return err
+		}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+
+}
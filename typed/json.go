@@ -0,0 +1,61 @@
+package typed
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// JSONLines is a Codec for newline delimited JSON, every line is one value. Empty lines are skipped.
+type JSONLines[T any] struct {
+	// MaxLen is the longest line accepted, DefaultMaxLine if zero
+	MaxLen int
+}
+
+// Decode returns the values of r, a line which is no valid JSON ends the pipe with an error.
+func (j JSONLines[T]) Decode(r io.Reader) Pipe[T] {
+
+	return func(yield func(T, error) bool) {
+		n := 0
+		for line, err := range (Lines{MaxLen: j.MaxLen}).Decode(r) {
+			var v T
+			if err != nil {
+				yield(v, err)
+				return
+			}
+			n++
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			if err := json.Unmarshal([]byte(line), &v); err != nil {
+				yield(v, errors.Wrapf(err, "invalid JSON in line %d", n))
+				return
+			}
+			if !yield(v, nil) {
+				return
+			}
+		}
+	}
+
+}
+
+// Encode writes every value of p as a line of JSON.
+func (j JSONLines[T]) Encode(w io.Writer, p Pipe[T]) error {
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	for v, err := range p {
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+
+}
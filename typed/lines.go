@@ -0,0 +1,55 @@
+package typed
+
+import (
+	"bufio"
+	"io"
+)
+
+// DefaultMaxLine is the longest line accepted by Lines if MaxLen is zero
+const DefaultMaxLine = 1 << 20
+
+// Lines is a Codec for text, every line is a value without its line break
+type Lines struct {
+	// MaxLen is the longest line accepted, DefaultMaxLine if zero
+	MaxLen int
+}
+
+// Decode returns the lines of r, lines longer than MaxLen end the pipe with bufio.ErrTooLong.
+func (l Lines) Decode(r io.Reader) Pipe[string] {
+
+	return func(yield func(string, error) bool) {
+		max := l.MaxLen
+		if max <= 0 {
+			max = DefaultMaxLine
+		}
+		// the capacity of the buffer raises the limit of the scanner, so it must not exceed max
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 0, min(max, 4096)), max)
+		for s.Scan() {
+			if !yield(s.Text(), nil) {
+				return
+			}
+		}
+		if err := s.Err(); err != nil {
+			yield("", err)
+		}
+	}
+
+}
+
+// Encode writes every value of p followed by a newline.
+func (l Lines) Encode(w io.Writer, p Pipe[string]) error {
+
+	bw := bufio.NewWriter(w)
+	for v, err := range p {
+		if err != nil {
+			return err
+		}
+		bw.WriteString(v)
+		if err := bw.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+
+}
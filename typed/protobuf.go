@@ -0,0 +1,70 @@
+package typed
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/noxer/piper"
+)
+
+// DefaultMaxMessage is the largest message accepted by Protobuf if MaxLen is zero
+const DefaultMaxMessage = 1 << 20
+
+// Protobuf is a Codec for length delimited protobuf messages, every message is preceded by its
+// size as a varint. This is the format of writeDelimitedTo in Java and the protodelim package in Go.
+// The package doesn't depend on a protobuf implementation, the messages are converted by Marshal
+// and Unmarshal, e.g. wrapping proto.Marshal and proto.Unmarshal.
+type Protobuf[T any] struct {
+	Marshal   func(T) ([]byte, error)
+	Unmarshal func([]byte) (T, error)
+	// MaxLen is the largest message accepted, DefaultMaxMessage if zero
+	MaxLen int
+}
+
+// Decode returns the messages of r. A message exceeding MaxLen or a truncated message ends the
+// pipe with an error.
+func (pb Protobuf[T]) Decode(r io.Reader) Pipe[T] {
+
+	return func(yield func(T, error) bool) {
+		max := pb.MaxLen
+		if max <= 0 {
+			max = DefaultMaxMessage
+		}
+
+		sc := piper.NewScanner(r, piper.ScanVarint(max), max)
+		for sc.Scan() {
+			v, err := pb.Unmarshal(sc.Bytes())
+			if !yield(v, err) || err != nil {
+				return
+			}
+		}
+		if err := sc.Err(); err != nil {
+			var zero T
+			yield(zero, err)
+		}
+	}
+
+}
+
+// Encode writes every value of p as a length delimited message.
+func (pb Protobuf[T]) Encode(w io.Writer, p Pipe[T]) error {
+
+	bw := bufio.NewWriter(w)
+	var size [binary.MaxVarintLen64]byte
+	for v, err := range p {
+		if err != nil {
+			return err
+		}
+		msg, err := pb.Marshal(v)
+		if err != nil {
+			return err
+		}
+		bw.Write(size[:binary.PutUvarint(size[:], uint64(len(msg)))])
+		if _, err := bw.Write(msg); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+
+}
@@ -0,0 +1,130 @@
+// Package typed passes Go values instead of bytes between in-process stages of a chain. A Pipe is a
+// lazily evaluated stream of values transformed by Map, Filter and Reduce. Values are only encoded
+// at the boundaries to external commands, see Stage:
+//
+//	c := piper.Command("cat", "words.txt").
+//		Func(typed.Stage(typed.Lines{}, typed.Lines{}, func(p typed.Pipe[string]) typed.Pipe[string] {
+//			return typed.Filter(typed.Map(p, strings.ToUpper), func(s string) bool { return s != "" })
+//		})).
+//		Command("sort")
+package typed
+
+import (
+	"io"
+
+	"github.com/noxer/piper"
+)
+
+// Pipe is a stream of values, it is consumed by ranging over it. An error ends the stream.
+type Pipe[T any] func(yield func(T, error) bool)
+
+// Decoder reads values of type T from a byte stream
+type Decoder[T any] interface {
+	Decode(r io.Reader) Pipe[T]
+}
+
+// Encoder writes values of type T to a byte stream
+type Encoder[T any] interface {
+	Encode(w io.Writer, p Pipe[T]) error
+}
+
+// Codec converts between values of type T and a byte stream
+type Codec[T any] interface {
+	Decoder[T]
+	Encoder[T]
+}
+
+// Stage returns a function stage decoding its input with dec, transforming the values with fn and
+// encoding the result with enc.
+func Stage[T, U any](dec Decoder[T], enc Encoder[U], fn func(Pipe[T]) Pipe[U]) piper.StageFunc {
+
+	return func(r io.Reader, w io.Writer) error {
+		return enc.Encode(w, fn(dec.Decode(r)))
+	}
+
+}
+
+// From returns a pipe of the values.
+func From[T any](values ...T) Pipe[T] {
+
+	return func(yield func(T, error) bool) {
+		for _, v := range values {
+			if !yield(v, nil) {
+				return
+			}
+		}
+	}
+
+}
+
+// Fail returns a pipe ending with err right away.
+func Fail[T any](err error) Pipe[T] {
+
+	return func(yield func(T, error) bool) {
+		var zero T
+		yield(zero, err)
+	}
+
+}
+
+// Map returns a pipe of fn applied to every value of p.
+func Map[T, U any](p Pipe[T], fn func(T) U) Pipe[U] {
+
+	return MapErr(p, func(v T) (U, error) { return fn(v), nil })
+
+}
+
+// MapErr works like Map for functions which may fail, an error ends the pipe.
+func MapErr[T, U any](p Pipe[T], fn func(T) (U, error)) Pipe[U] {
+
+	return func(yield func(U, error) bool) {
+		for v, err := range p {
+			var u U
+			if err == nil {
+				u, err = fn(v)
+			}
+			if !yield(u, err) || err != nil {
+				return
+			}
+		}
+	}
+
+}
+
+// Filter returns a pipe of the values of p for which keep returns true.
+func Filter[T any](p Pipe[T], keep func(T) bool) Pipe[T] {
+
+	return func(yield func(T, error) bool) {
+		for v, err := range p {
+			if err != nil {
+				yield(v, err)
+				return
+			}
+			if keep(v) && !yield(v, nil) {
+				return
+			}
+		}
+	}
+
+}
+
+// Reduce combines the values of p into one, starting with init.
+func Reduce[T, A any](p Pipe[T], init A, fn func(A, T) A) (A, error) {
+
+	acc := init
+	for v, err := range p {
+		if err != nil {
+			return acc, err
+		}
+		acc = fn(acc, v)
+	}
+	return acc, nil
+
+}
+
+// Collect returns all values of p.
+func Collect[T any](p Pipe[T]) ([]T, error) {
+
+	return Reduce(p, []T(nil), func(l []T, v T) []T { return append(l, v) })
+
+}
@@ -0,0 +1,143 @@
+package typed_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/noxer/piper"
+	"github.com/noxer/piper/typed"
+)
+
+var errTest = errors.New("test error")
+
+func TestPipe(t *testing.T) {
+
+	double := func(n int) int { return 2 * n }
+	even := func(n int) bool { return n%2 == 0 }
+	parse := func(s string) (int, error) { return strconv.Atoi(s) }
+
+	tests := []struct {
+		name string
+		pipe typed.Pipe[int]
+		want []int
+		err  bool
+	}{
+		{"from", typed.From(1, 2, 3), []int{1, 2, 3}, false},
+		{"empty", typed.From[int](), nil, false},
+		{"fail", typed.Fail[int](errTest), nil, true},
+		{"map", typed.Map(typed.From(1, 2, 3), double), []int{2, 4, 6}, false},
+		{"filter", typed.Filter(typed.From(1, 2, 3, 4), even), []int{2, 4}, false},
+		{"map and filter", typed.Filter(typed.Map(typed.From(1, 2, 3), double), func(n int) bool { return n > 2 }), []int{4, 6}, false},
+		{"map error", typed.MapErr(typed.From("1", "x", "3"), parse), []int{1}, true},
+		{"error passes map", typed.Map(typed.Fail[int](errTest), double), nil, true},
+		{"error passes filter", typed.Filter(typed.Fail[int](errTest), even), nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := typed.Collect(tt.pipe)
+			if (err != nil) != tt.err || !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, %v, want %v, error %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+
+	sum, err := typed.Reduce(typed.From(1, 2, 3), 10, func(a, n int) int { return a + n })
+	if err != nil || sum != 16 {
+		t.Errorf("Reduce: got %d, %v, want 16", sum, err)
+	}
+
+	// stopping early doesn't read further values
+	read := 0
+	for range typed.Map(typed.From(1, 2, 3), func(n int) int { read++; return n }) {
+		break
+	}
+	if read != 1 {
+		t.Errorf("read %d values after break, want 1", read)
+	}
+
+}
+
+func TestLines(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		lines typed.Lines
+		input string
+		want  []string
+		cause error
+	}{
+		{"lines", typed.Lines{}, "a\n\nb c\n", []string{"a", "", "b c"}, nil},
+		{"no input", typed.Lines{}, "", nil, nil},
+		{"last line without newline", typed.Lines{}, "a\r\nb", []string{"a", "b"}, nil},
+		{"line too long", typed.Lines{MaxLen: 4}, "abc\nabcdef\nx\n", []string{"abc"}, bufio.ErrTooLong},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := typed.Collect(tt.lines.Decode(strings.NewReader(tt.input)))
+			if errors.Cause(err) != tt.cause || !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, %v, want %q, %v", got, err, tt.want, tt.cause)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := (typed.Lines{}).Encode(&buf, typed.From("a", "", "b")); err != nil || buf.String() != "a\n\nb\n" {
+		t.Errorf("encoded %q, %v", buf.String(), err)
+	}
+	if err := (typed.Lines{}).Encode(&buf, typed.Fail[string](errTest)); err != errTest {
+		t.Errorf("got %v, want the error of the pipe", err)
+	}
+
+}
+
+func TestStage(t *testing.T) {
+
+	double := typed.Stage(typed.Lines{}, typed.Lines{}, func(p typed.Pipe[string]) typed.Pipe[string] {
+		return typed.MapErr(typed.Filter(p, func(s string) bool { return s != "" }), func(s string) (string, error) {
+			n, err := strconv.Atoi(s)
+			return strconv.Itoa(2 * n), err
+		})
+	})
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		ok    bool
+	}{
+		{"values", "1\n\n2\n", "2\n4\n", true},
+		{"no input", "", "", true},
+		{"failing transformation", "1\nx\n2\n", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := piper.Func(double)
+			c.Stdin = strings.NewReader(tt.input)
+			out, err := c.Output()
+			if (err == nil) != tt.ok || string(out) != tt.want {
+				t.Errorf("got %q, %v, want %q, ok %v", out, err, tt.want, tt.ok)
+			}
+		})
+	}
+
+	// a typed stage between two other stages
+	out, err := piper.Func(func(_ io.Reader, w io.Writer) error {
+		return typed.Lines{}.Encode(w, typed.From("b", "a"))
+	}).Func(typed.Stage(typed.Lines{}, typed.Lines{}, func(p typed.Pipe[string]) typed.Pipe[string] {
+		return typed.Map(p, strings.ToUpper)
+	})).Output()
+	if err != nil || string(out) != "B\nA\n" {
+		t.Errorf("got %q, %v", out, err)
+	}
+
+}
@@ -0,0 +1,32 @@
+package piper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrChecksum is the cause of the error returned by Wait if the output didn't match its checksum
+var ErrChecksum = errors.New("piper: checksum mismatch")
+
+// VerifyOutputSHA256 hashes the output of the last stage while it is written and fails the chain
+// with the cause ErrChecksum if its SHA-256 digest doesn't match the hex encoded expected digest.
+// The output is checked once every stage exited, before it is published by WithOutputFile.
+// It is the output of the stage which is the last one when the chain starts, so stages may be
+// added afterwards. It must be called before Start.
+func (c *Chain) VerifyOutputSHA256(expected string) *Chain {
+
+	h := sha256.New()
+	c.tapOutput(h)
+	c.checks = append(c.checks, func() error {
+		sum := hex.EncodeToString(h.Sum(nil))
+		if !strings.EqualFold(sum, strings.TrimSpace(expected)) {
+			return errors.Wrapf(ErrChecksum, "output has SHA-256 %s, expected %s", sum, expected)
+		}
+		return nil
+	})
+	return c
+
+}